@@ -0,0 +1,900 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kanisterio/kanister/pkg/apis/cr/v1alpha1"
+	"github.com/swissdatasciencecenter/taweret/internal/retention"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// orphaneddeletion is a completed deletion actionset of a backup config whose backup actionset has been deleted
+type orphaneddeletion struct {
+	name string
+	time time.Time
+}
+
+// queries Kubernetes for Actionsets, adds the actionsets with action name 'backup' to a slice of backup objects and returns the slice
+func getBackups(ctx context.Context, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, taweretMetrics taweretmetrics, taweretSettings taweretsettings, backupConfig backupconfig) ([]retention.Backup, error) {
+	backups, _, err := listBackups(ctx, dynamicClient, gvr, taweretMetrics, taweretSettings, backupConfig)
+	return backups, err
+}
+
+// queries Kubernetes for Actionsets, returns the backups of the backup config and its orphaned deletion actionsets
+func listBackups(ctx context.Context, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, taweretMetrics taweretmetrics, taweretSettings taweretsettings, backupConfig backupconfig) ([]retention.Backup, []orphaneddeletion, error) {
+	ctx, span := tracer.Start(ctx, "listBackups", backupConfigAttributes(backupConfig))
+	defer span.End()
+	var backups []retention.Backup
+
+	log.Printf("%v: retrieving actionsets from Kubernetes", backupConfig.Name)
+
+	// states of the deletion actionsets by name, they may be listed on a later page than their backup actionsets
+	deletionStates := make(map[string]string)
+	// the completed deletion actionsets of the backup config and the names of all other actionsets, to find the orphaned deletions
+	var completedDeletions []orphaneddeletion
+	actionsetNames := make(map[string]bool)
+	invalidTimestamps := 0
+	// the deletion actionsets carry no labels, so with a label selector they are listed in a second pass without it, which also
+	// lists the other actionsets, so that the deletions of backups which the label selector leaves out are not taken as orphaned
+	recordDeletion := func(actionset unstructured.Unstructured) {
+		deletionState, _, _ := unstructured.NestedString(actionset.Object, "status", "state")
+		deletionStates[actionset.GetName()] = normaliseState(deletionState, taweretSettings)
+		if deletionStates[actionset.GetName()] == "complete" && deletionBlueprint(actionset) == backupConfig.BlueprintName {
+			completedDeletions = append(completedDeletions, orphaneddeletion{name: actionset.GetName(), time: actionset.GetCreationTimestamp().Time})
+		}
+	}
+	err := listActionSets(ctx, dynamicClient, gvr, taweretMetrics, taweretSettings, backupConfig, backupConfig.LabelSelector, func(actionset unstructured.Unstructured) {
+		if strings.HasPrefix(actionset.GetName(), "delete-") {
+			if backupConfig.LabelSelector == "" {
+				recordDeletion(actionset)
+			}
+			return
+		}
+		actionsetNames[actionset.GetName()] = true
+		if thisBackup, ok := parseBackup(actionset, backupConfig); ok {
+			// a backup without a time would look like the oldest backup and be deleted first
+			if thisBackup.Time.IsZero() {
+				creationTimestamp, _, _ := unstructured.NestedFieldNoCopy(actionset.Object, "metadata", "creationTimestamp")
+				slog.Warn("skipping backup actionset with a missing or malformed creation timestamp", "backup_config", backupConfig.Name, "backup_name", thisBackup.Name, "creation_timestamp", creationTimestamp)
+				invalidTimestamps++
+				return
+			}
+			log.Printf("Selected actionset: %v", thisBackup.Name)
+			thisBackup.Status = normaliseState(thisBackup.Status, taweretSettings)
+			thisBackup.Pinned = isPinned(actionset, taweretSettings.retainKey)
+			thisBackup.Expired = actionset.GetAnnotations()[expiredAnnotation] != ""
+			// a missing or malformed annotation leaves the time at zero, the backup is then marked again
+			thisBackup.DeletableSince, _ = time.Parse(time.RFC3339, actionset.GetAnnotations()[deletableSinceAnnotation])
+			backups = append(backups, thisBackup)
+		}
+	})
+	if err == nil && backupConfig.LabelSelector != "" {
+		err = listActionSets(ctx, dynamicClient, gvr, taweretMetrics, taweretSettings, backupConfig, "", func(actionset unstructured.Unstructured) {
+			if strings.HasPrefix(actionset.GetName(), "delete-") {
+				recordDeletion(actionset)
+			} else {
+				actionsetNames[actionset.GetName()] = true
+			}
+		})
+	}
+	if err != nil {
+		recordSpanError(span, err)
+		return nil, nil, err
+	}
+	taweretMetrics.invalidTimestamps.WithLabelValues(backupConfig.metricLabels()...).Set(float64(invalidTimestamps))
+	for i := range backups {
+		backups[i].DeletionState = deletionStates[fmt.Sprintf("delete-%v", backups[i].Name)]
+	}
+	var orphanedDeletions []orphaneddeletion
+	for _, completedDeletion := range completedDeletions {
+		if !actionsetNames[strings.TrimPrefix(completedDeletion.name, "delete-")] {
+			orphanedDeletions = append(orphanedDeletions, completedDeletion)
+		}
+	}
+	span.SetAttributes(attribute.Int("backups", len(backups)))
+	return backups, orphanedDeletions, nil
+}
+
+// lists the actionsets in the kanister namespace of the backup config which match the label selector and calls process for each
+// of them, they are listed in pages of listPageSize, so that only a single page is held in memory at a time
+func listActionSets(ctx context.Context, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, taweretMetrics taweretmetrics, taweretSettings taweretsettings, backupConfig backupconfig, labelSelector string, process func(unstructured.Unstructured)) error {
+	listOptions := v1.ListOptions{
+		LabelSelector: labelSelector,
+		Limit:         int64(taweretSettings.listPageSize),
+	}
+	for {
+		// get a page of actionsets
+		var actionsets *unstructured.UnstructuredList
+		err := retryAPICall(ctx, taweretSettings, fmt.Sprintf("list actionsets in namespace %v", backupConfig.KanisterNamespace), func(callCtx context.Context) error {
+			var err error
+			actionsets, err = dynamicClient.Resource(gvr).Namespace(backupConfig.KanisterNamespace).List(callCtx, listOptions)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error getting actionsets: %w", err)
+		}
+
+		log.Printf("%v: filtering %v backup actionsets from Kubernetes", backupConfig.Name, len(actionsets.Items))
+		taweretMetrics.actionSetsScanned.WithLabelValues(backupConfig.KanisterNamespace).Add(float64(len(actionsets.Items)))
+		for _, actionset := range actionsets.Items {
+			process(actionset)
+		}
+
+		// continue with the next page, if there is one
+		if actionsets.GetContinue() == "" {
+			return nil
+		}
+		listOptions.Continue = actionsets.GetContinue()
+	}
+}
+
+// returns the state of an actionset as Taweret knows it, the additional complete and failed states are mapped onto complete and failed,
+// and states are compared ignoring case, so that Taweret adapts to the states of other Kanister versions
+func normaliseState(state string, taweretSettings taweretsettings) string {
+	for _, completeState := range taweretSettings.completeStates {
+		if strings.EqualFold(state, completeState) {
+			return "complete"
+		}
+	}
+	for _, failedState := range taweretSettings.failedStates {
+		if strings.EqualFold(state, failedState) {
+			return "failed"
+		}
+	}
+	return strings.ToLower(state)
+}
+
+// returns whether a backup actionset is pinned with the annotation or the label retainKey set to "true"
+func isPinned(actionset unstructured.Unstructured, retainKey string) bool {
+	return actionset.GetAnnotations()[retainKey] == "true" || actionset.GetLabels()[retainKey] == "true"
+}
+
+// returns the blueprint of the action of a deletion actionset, deletion actionsets are attributed to backup configs by their blueprint
+func deletionBlueprint(actionset unstructured.Unstructured) string {
+	actions, _, _ := unstructured.NestedSlice(actionset.Object, "spec", "actions")
+	if len(actions) == 0 {
+		return ""
+	}
+	action, _ := actions[0].(map[string]interface{})
+	blueprint, _ := action["blueprint"].(string)
+	return blueprint
+}
+
+// deletes the orphaned deletion actionsets once they are older than the deletion actionset retention, errors are only logged
+// as the deletion actionsets are retried in the next evaluation
+func cleanupDeletionActionSets(ctx context.Context, orphanedDeletions []orphaneddeletion, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, taweretSettings taweretsettings, backupConfig backupconfig) {
+	for _, orphanedDeletion := range orphanedDeletions {
+		if taweretSettings.now().Sub(orphanedDeletion.time) < taweretSettings.deletionActionSetRetention {
+			continue
+		}
+		deleteCtx, cancel := apiContext(ctx, taweretSettings)
+		err := dynamicClient.Resource(gvr).Namespace(backupConfig.KanisterNamespace).Delete(deleteCtx, orphanedDeletion.name, v1.DeleteOptions{})
+		cancel()
+		if err != nil && !apierrors.IsNotFound(err) {
+			slog.Warn("error deleting completed deletion actionset", "backup_config", backupConfig.Name, "action", "cleanup", "actionset", orphanedDeletion.name, "error", err)
+			continue
+		}
+		slog.Info("deleted completed deletion actionset", "backup_config", backupConfig.Name, "action", "cleanup", "actionset", orphanedDeletion.name)
+	}
+}
+
+// converts an actionset into a backup object, returns false if the actionset is not a backup of the backup config
+func parseBackup(actionset unstructured.Unstructured, backupConfig backupconfig) (retention.Backup, bool) {
+	// actionsets which do not have the expected shape are skipped, the assertions are guarded so that they cannot panic
+	spec, ok := actionset.Object["spec"].(map[string]interface{})
+	if !ok {
+		slog.Debug("skipping actionset without spec", "backup_config", backupConfig.Name, "actionset", actionset.GetName())
+		return retention.Backup{}, false
+	}
+	actions, ok := spec["actions"].([]interface{})
+	if !ok || len(actions) == 0 {
+		slog.Debug("skipping actionset without actions", "backup_config", backupConfig.Name, "actionset", actionset.GetName())
+		return retention.Backup{}, false
+	}
+	actionMetadata, ok := actionset.Object["metadata"].(map[string]interface{})
+	if !ok {
+		slog.Debug("skipping actionset without metadata", "backup_config", backupConfig.Name, "actionset", actionset.GetName())
+		return retention.Backup{}, false
+	}
+	actionsetName, ok := actionMetadata["name"].(string)
+	if !ok {
+		slog.Debug("skipping actionset without name", "backup_config", backupConfig.Name)
+		return retention.Backup{}, false
+	}
+
+	// skip ahead if no action name starts with one of the backup action prefixes, multi-action blueprints may run the backup
+	// action after other actions, e.g. after a validation
+	actionIndex, actionSpec, ok := backupAction(actions, backupConfig)
+	if !ok {
+		return retention.Backup{}, false
+	}
+
+	// actionsets without a schedule are not backups of any backup config
+	backupSchedule, ok := backupConfig.scheduleSource().schedule(actionset, actionSpec)
+	if !ok {
+		return retention.Backup{}, false
+	}
+
+	// the status is not set yet on a freshly created actionset, its state is then empty
+	status, _ := actionset.Object["status"].(map[string]interface{})
+	state, _ := status["state"].(string)
+
+	// the backup location is taken from the first of the backup location paths which is set
+	var backupLocation, locationArtifact, locationKey string
+	var backupSize int64
+	var missingLocation bool
+	if artifacts := statusArtifacts(status, actionIndex); artifacts != nil {
+		for _, path := range backupConfig.backupLocationPaths() {
+			artifact, _ := artifacts[path[0]].(map[string]interface{})
+			keyValue, _ := artifact["keyValue"].(map[string]interface{})
+			if location, _ := keyValue[path[1]].(string); location != "" {
+				backupLocation, locationArtifact, locationKey = location, path[0], path[1]
+				break
+			}
+		}
+		backupSize = parseBackupSize(artifacts, backupConfig)
+		// only the backup action reporting artifacts without a location makes the location missing, the artifacts of the other
+		// actions alone, e.g. of a validation, do not
+		missingLocation = len(actionArtifacts(status, actionIndex)) > 0 && backupLocation == ""
+	}
+
+	thisBackup := retention.Backup{
+		Name:             actionsetName,
+		Status:           state,
+		Schedule:         backupSchedule,
+		BackupLocation:   backupLocation,
+		LocationArtifact: locationArtifact,
+		LocationKey:      locationKey,
+		MissingLocation:  missingLocation,
+		Size:             backupSize,
+	}
+	// a missing or malformed creation timestamp leaves the time of the backup at zero, the backup is then skipped by the caller
+	creationTimestamp, _ := actionMetadata["creationTimestamp"].(string)
+	if backupTime, err := time.Parse(time.RFC3339, creationTimestamp); err == nil {
+		thisBackup.Time = backupTime
+	}
+	return thisBackup, thisBackup.Schedule == backupConfig.Name
+}
+
+// returns the index and the spec of the first action of an actionset whose name starts with one of the backup action prefixes
+func backupAction(actions []interface{}, backupConfig backupconfig) (int, map[string]interface{}, bool) {
+	for i, action := range actions {
+		actionSpec, _ := action.(map[string]interface{})
+		if actionName, _ := actionSpec["name"].(string); backupConfig.isBackupAction(actionName) {
+			return i, actionSpec, true
+		}
+	}
+	return 0, nil, false
+}
+
+// returns the artifacts of all actions in the status of an actionset, the status actions are in the order of the spec actions
+// the artifacts of the backup action at actionIndex take precedence, so that the artifacts of other actions, e.g. a validation
+// after the backup, are only used for the paths the backup action does not set, returns nil if no action has artifacts
+func statusArtifacts(status map[string]interface{}, actionIndex int) map[string]interface{} {
+	statusActions, _ := status["actions"].([]interface{})
+	var artifacts map[string]interface{}
+	merge := func(i int) {
+		thisArtifacts := actionArtifacts(status, i)
+		if thisArtifacts == nil {
+			return
+		}
+		if artifacts == nil {
+			artifacts = make(map[string]interface{})
+		}
+		for name, artifact := range thisArtifacts {
+			if _, ok := artifacts[name]; !ok {
+				artifacts[name] = artifact
+			}
+		}
+	}
+	merge(actionIndex)
+	for i := range statusActions {
+		if i != actionIndex {
+			merge(i)
+		}
+	}
+	return artifacts
+}
+
+// returns the artifacts of the action at actionIndex in the status of an actionset, nil if the action has no artifacts
+func actionArtifacts(status map[string]interface{}, actionIndex int) map[string]interface{} {
+	statusActions, _ := status["actions"].([]interface{})
+	if actionIndex >= len(statusActions) {
+		return nil
+	}
+	action, _ := statusActions[actionIndex].(map[string]interface{})
+	artifacts, _ := action["artifacts"].(map[string]interface{})
+	return artifacts
+}
+
+// returns the size of a backup read from its artifacts under the backup size path, which may hold plain bytes or a quantity such as 1.5Gi,
+// returns 0 if the backup size path is unset or the size is missing or malformed
+func parseBackupSize(artifacts map[string]interface{}, backupConfig backupconfig) int64 {
+	artifactName, key, ok := strings.Cut(backupConfig.BackupSizePath, ".")
+	if !ok {
+		return 0
+	}
+	artifact, _ := artifacts[artifactName].(map[string]interface{})
+	keyValue, _ := artifact["keyValue"].(map[string]interface{})
+	size, _ := keyValue[key].(string)
+	if size == "" {
+		return 0
+	}
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil {
+		slog.Debug("skipping malformed backup size", "backup_config", backupConfig.Name, "backup_size", size, "error", err)
+		return 0
+	}
+	return quantity.Value()
+}
+
+// checks that a completed deletion actionset reports under the deletion verification path that the backup location was removed,
+// a missing or other value than true is logged and counted, as the delete action may have been a no-op
+// the backup actionset is deleted anyway, so that an unverifiable delete action is not retried in every evaluation
+func verifyDeletion(status map[string]interface{}, unusedBackup retention.Backup, taweretMetrics taweretmetrics, backupConfig backupconfig) {
+	artifactName, key, ok := strings.Cut(backupConfig.DeletionVerificationPath, ".")
+	if !ok {
+		return
+	}
+	var verified string
+	if statusActions, ok := status["actions"].([]interface{}); ok && len(statusActions) > 0 {
+		statusAction, _ := statusActions[0].(map[string]interface{})
+		artifacts, _ := statusAction["artifacts"].(map[string]interface{})
+		artifact, _ := artifacts[artifactName].(map[string]interface{})
+		keyValue, _ := artifact["keyValue"].(map[string]interface{})
+		verified, _ = keyValue[key].(string)
+	}
+	if verified == "true" {
+		return
+	}
+	slog.Warn("deletion actionset completed without confirming the deletion, the backup location may still exist", "backup_config", backupConfig.Name, "backup_name", unusedBackup.Name, "action", "verify", "backup_location", unusedBackup.BackupLocation, "verification_path", backupConfig.DeletionVerificationPath, "verification", verified)
+	taweretMetrics.unverifiedDeletions.WithLabelValues(backupConfig.metricLabels()...).Inc()
+}
+
+// deletedlocations records the backup locations deleted in an evaluation cycle together with the backups they are deleted with, it
+// is shared by the concurrent evaluations of the backup configs and the total backup cap
+type deletedlocations struct {
+	mutex     sync.Mutex
+	locations map[string]deletedlocation
+}
+
+// deletedlocation is the backup a backup location is deleted with, and whether its deletion succeeded or is still running
+type deletedlocation struct {
+	backup string
+	done   bool
+}
+
+// returns the record of the backup locations deleted in an evaluation cycle, which starts out empty
+func newDeletedLocations() *deletedlocations {
+	return &deletedlocations{locations: make(map[string]deletedlocation)}
+}
+
+// claims a backup location for the deletion of a backup, returns false and the backup it is deleted with if it is already claimed
+func (deletedLocations *deletedlocations) claim(location string, backupName string) (deletedlocation, bool) {
+	deletedLocations.mutex.Lock()
+	defer deletedLocations.mutex.Unlock()
+	if existing, ok := deletedLocations.locations[location]; ok {
+		return existing, false
+	}
+	deletedLocations.locations[location] = deletedlocation{backup: backupName}
+	return deletedlocation{}, true
+}
+
+// finishes the deletion of a claimed backup location, a location which was not deleted can be claimed again
+func (deletedLocations *deletedlocations) finish(location string, deleted bool) {
+	deletedLocations.mutex.Lock()
+	defer deletedLocations.mutex.Unlock()
+	if !deleted {
+		delete(deletedLocations.locations, location)
+		return
+	}
+	existing := deletedLocations.locations[location]
+	existing.done = true
+	deletedLocations.locations[location] = existing
+}
+
+// delete a specified number of the oldest backups in a backup slice
+func deleteOldestBackups(ctx context.Context, backups []retention.Backup, count int, deletedLocations *deletedlocations, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, taweretMetrics taweretmetrics, taweretSettings taweretsettings, backupConfig backupconfig) (int, error) {
+	backups = retention.Sort(backups)
+
+	// a backup actionset with artifacts but without a backup location is likely read under the wrong backup location paths, a deletion
+	// actionset without the backup location could not find the backup, so the backup is kept until the paths are fixed
+	// such backups are skipped before the deletion window and the deletion cap, so that they do not use up the deletions of every run
+	var locatedBackups []retention.Backup
+	selected := count
+	for i, aBackup := range backups {
+		if i < selected && aBackup.MissingLocation {
+			slog.Warn("backup actionset has artifacts but no backup location under the backup location paths, skipping deletion", "backup_config", backupConfig.Name, "backup_name", aBackup.Name, "action", "delete", "backup_location_paths", backupConfig.backupLocationPaths())
+			taweretMetrics.missingLocations.WithLabelValues(backupConfig.metricLabels()...).Inc()
+			count--
+			continue
+		}
+		locatedBackups = append(locatedBackups, aBackup)
+	}
+	backups = locatedBackups
+	if count == 0 {
+		return 0, nil
+	}
+
+	// outside of the deletion window, the backups are left to an evaluation within it
+	if !backupConfig.inDeletionWindow(taweretSettings.now()) {
+		slog.Info("outside of the deletion window, deferring deletions", "backup_config", backupConfig.Name, "action", "delete", "deletion_window", backupConfig.DeletionWindow, "timezone", backupConfig.location(), "deferred", count)
+		taweretMetrics.deferredDeletions.WithLabelValues(backupConfig.metricLabels()...).Add(float64(count))
+		return 0, nil
+	}
+
+	// cap the amount of deletions, the remaining backups are deleted in the next evaluations
+	maxDeletions := taweretSettings.maxDeletionsPerRun
+	if backupConfig.MaxDeletionsPerRun > 0 {
+		maxDeletions = int(backupConfig.MaxDeletionsPerRun)
+	}
+	if maxDeletions > 0 && count > maxDeletions {
+		slog.Warn("backups to delete exceed the max deletions per run, leaving the remainder for the next evaluation", "backup_config", backupConfig.Name, "action", "delete", "deletable", count, "max_deletions", maxDeletions, "remaining", count-maxDeletions)
+		count = maxDeletions
+	}
+
+	deleted := 0
+	// backup actionsets sharing a backup location, e.g. a re-created backup actionset, would run a second deletion on a removed backup
+	// location, so the backup location is deleted once in an evaluation cycle and the backup actionsets of the other backups are
+	// deleted right away, a location which another backup config is still deleting is left for the next evaluation
+	for i := 0; i < count; i++ {
+		slog.Info("deleting backup", "backup_config", backupConfig.Name, "backup_name", backups[i].Name, "action", "delete", "backup_time", backups[i].Time.UTC(), "deletion_nr", i+1, "deletions_total", count, "deletable", len(backups))
+		var backupDeleted bool
+		var err error
+		location := backups[i].BackupLocation
+		if location == "" {
+			backupDeleted, err = deleteBackup(ctx, backups[i], dynamicClient, gvr, taweretMetrics, taweretSettings, backupConfig)
+		} else if deletedWith, claimed := deletedLocations.claim(location, backups[i].Name); claimed {
+			backupDeleted, err = deleteBackup(ctx, backups[i], dynamicClient, gvr, taweretMetrics, taweretSettings, backupConfig)
+			deletedLocations.finish(location, backupDeleted)
+		} else if deletedWith.done {
+			slog.Warn("backup location already deleted in this evaluation, deleting only the backup actionset", "backup_config", backupConfig.Name, "backup_name", backups[i].Name, "action", "delete", "backup_location", location, "deleted_with", deletedWith.backup)
+			err = deleteBackupActionSet(ctx, backups[i], dynamicClient, gvr, taweretMetrics, taweretSettings, backupConfig)
+			backupDeleted = err == nil
+		} else {
+			slog.Info("backup location is being deleted with another backup, leaving the backup for the next evaluation", "backup_config", backupConfig.Name, "backup_name", backups[i].Name, "action", "delete", "backup_location", location, "deleted_with", deletedWith.backup)
+			continue
+		}
+		if err != nil {
+			notifyWebhook(taweretSettings, webhookevent{Event: "deletion_failed", BackupConfig: backupConfig.Name, BackupName: backups[i].Name, BackupTime: backups[i].Time.UTC(), Error: err.Error()})
+			recordBackupEvent(taweretSettings, gvr, backupConfig, backups[i], corev1.EventTypeWarning, "BackupDeletionFailed", "Deleting backup %v of backup config %v failed: %v", backups[i].Name, backupConfig.Name, err)
+			return deleted, fmt.Errorf("error deleting backup %v: %w", backups[i].Name, err)
+		}
+		if backupDeleted {
+			deleted++
+			notifyWebhook(taweretSettings, webhookevent{Event: "deleted", BackupConfig: backupConfig.Name, BackupName: backups[i].Name, BackupTime: backups[i].Time.UTC()})
+			recordBackupEvent(taweretSettings, gvr, backupConfig, backups[i], corev1.EventTypeNormal, "BackupDeleted", "Deleted backup %v of backup config %v, taken at %v", backups[i].Name, backupConfig.Name, backups[i].Time.UTC().Format(time.RFC3339))
+		}
+	}
+	return deleted, nil
+}
+
+// returns the deletable backups which have been deletable for the deletion delay and the amount of the other ones, which are held back,
+// those which only became deletable in this evaluation are annotated with the current time, so that the delay outlives restarts of
+// Taweret, in dry run mode nothing is annotated and no backup is held back
+func holdDeletions(ctx context.Context, backups []retention.Backup, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, taweretSettings taweretsettings, backupConfig backupconfig) ([]retention.Backup, int) {
+	if taweretSettings.deletionDelay == 0 || taweretSettings.dryRun {
+		return backups, 0
+	}
+	var dueBackups []retention.Backup
+	held := 0
+	for _, aBackup := range backups {
+		if !deletionHeld(aBackup, taweretSettings) {
+			dueBackups = append(dueBackups, aBackup)
+			continue
+		}
+		held++
+		if aBackup.DeletableSince.IsZero() {
+			slog.Info("backup became deletable, holding back its deletion for the deletion delay", "backup_config", backupConfig.Name, "backup_name", aBackup.Name, "action", "hold", "deletion_delay", taweretSettings.deletionDelay)
+			annotations := map[string]interface{}{deletableSinceAnnotation: taweretSettings.now().UTC().Format(time.RFC3339)}
+			if err := annotateBackupActionSet(ctx, aBackup, annotations, dynamicClient, gvr, taweretSettings, backupConfig); err != nil {
+				slog.Warn("error marking backup as deletable, marking it again in the next evaluation", "backup_config", backupConfig.Name, "backup_name", aBackup.Name, "action", "hold", "error", err)
+			}
+		}
+	}
+	if held > 0 {
+		log.Printf("%v: %v deletable backups held back by the deletion delay of %v", backupConfig.Name, held, taweretSettings.deletionDelay)
+	}
+	return dueBackups, held
+}
+
+// returns whether the deletion of a deletable backup is held back, because it has not been deletable for the deletion delay yet
+// nothing is held back without a deletion delay or in dry run mode
+func deletionHeld(aBackup retention.Backup, taweretSettings taweretsettings) bool {
+	if taweretSettings.deletionDelay == 0 || taweretSettings.dryRun {
+		return false
+	}
+	return aBackup.DeletableSince.IsZero() || taweretSettings.now().Sub(aBackup.DeletableSince) < taweretSettings.deletionDelay
+}
+
+// removes the deletable since annotation of the retained backups, so that a backup which becomes deletable again, e.g. after a
+// mistaken change of its backup config was reverted and repeated, is held back for the full deletion delay
+func releaseHeldDeletions(ctx context.Context, retainedBackups []retention.Backup, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, taweretSettings taweretsettings, backupConfig backupconfig) {
+	for _, aBackup := range retainedBackups {
+		if aBackup.DeletableSince.IsZero() {
+			continue
+		}
+		if err := annotateBackupActionSet(ctx, aBackup, map[string]interface{}{deletableSinceAnnotation: nil}, dynamicClient, gvr, taweretSettings, backupConfig); err != nil {
+			slog.Warn("error unmarking retained backup, unmarking it again in the next evaluation", "backup_config", backupConfig.Name, "backup_name", aBackup.Name, "action", "release", "error", err)
+			continue
+		}
+		slog.Info("backup is retained again, released its held deletion", "backup_config", backupConfig.Name, "backup_name", aBackup.Name, "action", "release", "deletable_since", aBackup.DeletableSince)
+	}
+}
+
+// merges annotations into the backup actionset of a backup, annotations set to nil are removed
+func annotateBackupActionSet(ctx context.Context, aBackup retention.Backup, annotations map[string]interface{}, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, taweretSettings taweretsettings, backupConfig backupconfig) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error building annotation patch: %w", err)
+	}
+	patchCtx, cancel := apiContext(ctx, taweretSettings)
+	defer cancel()
+	_, err = dynamicClient.Resource(gvr).Namespace(backupConfig.KanisterNamespace).Patch(patchCtx, aBackup.Name, types.MergePatchType, patch, v1.PatchOptions{})
+	return err
+}
+
+// marks a backup as expired by annotating its backup actionset with the current time, the backup is then deleted by an external process
+func expireBackup(ctx context.Context, unusedBackup retention.Backup, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, taweretSettings taweretsettings, backupConfig backupconfig) error {
+	annotations := map[string]interface{}{expiredAnnotation: taweretSettings.now().UTC().Format(time.RFC3339)}
+	if err := annotateBackupActionSet(ctx, unusedBackup, annotations, dynamicClient, gvr, taweretSettings, backupConfig); err != nil {
+		return fmt.Errorf("error annotating backup actionset as expired: %w", err)
+	}
+	slog.Info("marked backup as expired", "backup_config", backupConfig.Name, "backup_name", unusedBackup.Name, "action", "expire", "backup_time", unusedBackup.Time.UTC(), "backup_location", unusedBackup.BackupLocation)
+	return nil
+}
+
+// deletes a specified backup by creating an actionset with the action 'delete', returns whether the backup actionset was deleted
+func deleteBackup(ctx context.Context, unusedBackup retention.Backup, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, taweretMetrics taweretmetrics, taweretSettings taweretsettings, backupConfig backupconfig) (deleted bool, err error) {
+	// the span covers waiting for a deletion slot and polling the deletion actionset, each poll is recorded as an event
+	ctx, span := tracer.Start(ctx, "deleteBackup", backupConfigAttributes(backupConfig), trace.WithAttributes(attribute.String("backup_name", unusedBackup.Name)))
+	defer func() {
+		span.SetAttributes(attribute.Bool("deleted", deleted))
+		recordSpanError(span, err)
+		span.End()
+	}()
+	// in dry run mode, only log the backup which would be deleted
+	if taweretSettings.dryRun {
+		slog.Info("dry run: would delete backup", "backup_config", backupConfig.Name, "backup_name", unusedBackup.Name, "action", "dry-run-delete", "backup_time", unusedBackup.Time.UTC(), "backup_location", unusedBackup.BackupLocation)
+		return false, nil
+	}
+	if taweretSettings.softDelete {
+		return false, expireBackup(ctx, unusedBackup, dynamicClient, gvr, taweretSettings, backupConfig)
+	}
+
+	// the slot is held until the backup actionset has been deleted, so that a stampede of deletions does not overwhelm the kanister controller
+	release, err := acquireDeletionSlot(ctx, unusedBackup, taweretSettings, backupConfig)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	// set name of deletion actionset
+	deletionActionsetName := fmt.Sprintf("delete-%v", unusedBackup.Name)
+
+	// check if the deletion actionset already exists, an existing one which has not failed is awaited instead of creating another one,
+	// so that a deletion interrupted by a restart of Taweret is resumed and its backup actionset is still deleted
+	getCtx, cancel := apiContext(ctx, taweretSettings)
+	existingActionSet, err := dynamicClient.Resource(gvr).Namespace(backupConfig.KanisterNamespace).Get(getCtx, deletionActionsetName, v1.GetOptions{})
+	cancel()
+	createDeletion := true
+	if err == nil {
+		state, _, _ := unstructured.NestedString(existingActionSet.Object, "status", "state")
+		if state = normaliseState(state, taweretSettings); state != "failed" {
+			slog.Info("deletion actionset already exists, resuming deletion", "backup_config", backupConfig.Name, "backup_name", unusedBackup.Name, "action", "delete", "actionset", deletionActionsetName, "state", state)
+			createDeletion = false
+		} else {
+			// a failed deletion actionset from a previous evaluation is removed, so that the deletion is retried
+			slog.Info("retrying failed deletion actionset", "backup_config", backupConfig.Name, "backup_name", unusedBackup.Name, "action", "delete", "actionset", deletionActionsetName)
+			deleteCtx, cancel := apiContext(ctx, taweretSettings)
+			err = dynamicClient.Resource(gvr).Namespace(backupConfig.KanisterNamespace).Delete(deleteCtx, deletionActionsetName, v1.DeleteOptions{})
+			cancel()
+			if err != nil {
+				return false, fmt.Errorf("error deleting failed deletion actionset %v: %w", deletionActionsetName, err)
+			}
+		}
+	}
+	if createDeletion {
+		if err := createDeletionActionSet(ctx, unusedBackup, deletionActionsetName, dynamicClient, gvr, taweretSettings, backupConfig); err != nil {
+			return false, err
+		}
+	}
+
+	// loop to check status of deletion actionset whilst actionset is running, quick deletions are noticed early
+	// and long-running deletions are polled less frequently
+	deletionDeadline := time.Now().Add(taweretSettings.deletionTimeout)
+	pollInterval := taweretSettings.deletionPollInterval
+	for {
+		// give up on a stuck deletion actionset, the backup actionset is kept so that the backup is not orphaned
+		if time.Now().After(deletionDeadline) {
+			slog.Warn("deletion actionset did not finish in time, keeping backup actionset", "backup_config", backupConfig.Name, "backup_name", unusedBackup.Name, "action", "delete", "actionset", deletionActionsetName, "timeout", taweretSettings.deletionTimeout)
+			return false, nil
+		}
+
+		log.Printf("%v: waiting for %v to complete... ", backupConfig.Name, deletionActionsetName)
+		select {
+		case <-ctx.Done():
+			return false, fmt.Errorf("stopped waiting for deletion actionset %v: %w", deletionActionsetName, ctx.Err())
+		case <-time.After(pollInterval):
+		}
+		pollInterval = min(2*pollInterval, max(taweretSettings.deletionPollMaxInterval, taweretSettings.deletionPollInterval))
+
+		// get deletion actionset
+		getCtx, cancel := apiContext(ctx, taweretSettings)
+		actionset, err := dynamicClient.Resource(gvr).Namespace(backupConfig.KanisterNamespace).Get(getCtx, deletionActionsetName, v1.GetOptions{})
+		cancel()
+		if err != nil {
+			// handle not found error gracefully (actionset deleted while checking)
+			if apierrors.IsNotFound(err) {
+				slog.Warn("deletion actionset no longer exists (may have been deleted), continuing to next", "backup_config", backupConfig.Name, "backup_name", unusedBackup.Name, "action", "delete", "actionset", deletionActionsetName)
+				return false, nil // continue to next actionset in caller
+			}
+			return false, fmt.Errorf("error retrieving deletion actionset %v: %w", deletionActionsetName, err)
+		}
+
+		status, ok := actionset.Object["status"].(map[string]interface{})
+		if !ok || status == nil {
+			log.Printf("%v: status not available yet for %v, waiting...", backupConfig.Name, deletionActionsetName)
+			continue
+		}
+
+		state, _ := status["state"].(string)
+		state = normaliseState(state, taweretSettings)
+		span.AddEvent("poll", trace.WithAttributes(attribute.String("state", state)))
+		if state == "complete" {
+			slog.Info("deletion actionset has completed", "backup_config", backupConfig.Name, "backup_name", unusedBackup.Name, "action", "delete", "actionset", deletionActionsetName)
+			verifyDeletion(status, unusedBackup, taweretMetrics, backupConfig)
+			break
+		}
+		if state == "failed" {
+			var errMsg interface{} = ""
+			if errVal, ok := status["error"].(map[string]interface{}); ok {
+				errMsg = errVal["message"]
+			}
+			// keep the backup actionset, the backup may not have been removed from the backup location
+			taweretMetrics.deletionFailures.WithLabelValues(backupConfig.metricLabels(unusedBackup.Name)...).Inc()
+			slog.Error("error deleting backup with deletion actionset, keeping backup actionset", "backup_config", backupConfig.Name, "backup_name", unusedBackup.Name, "action", "delete", "actionset", deletionActionsetName, "error", errMsg)
+			return false, fmt.Errorf("deletion actionset %v failed: %v", deletionActionsetName, errMsg)
+		}
+		log.Printf("%v\n", state)
+	}
+
+	if err := deleteBackupActionSet(ctx, unusedBackup, dynamicClient, gvr, taweretMetrics, taweretSettings, backupConfig); err != nil {
+		return false, err
+	}
+
+	// without a retention, the deletion actionset is deleted right away, otherwise it is cleaned up by a later evaluation
+	if taweretSettings.deletionActionSetRetention == 0 {
+		cleanupDeletionActionSets(ctx, []orphaneddeletion{{name: deletionActionsetName, time: taweretSettings.now()}}, dynamicClient, gvr, taweretSettings, backupConfig)
+	}
+	return true, nil
+}
+
+// waits for one of the deletion slots shared by all backup configs and returns the function releasing it, without a limit on the
+// concurrent deletions it returns right away
+func acquireDeletionSlot(ctx context.Context, unusedBackup retention.Backup, taweretSettings taweretsettings, backupConfig backupconfig) (func(), error) {
+	if taweretSettings.deletionSlots == nil {
+		return func() {}, nil
+	}
+	select {
+	case taweretSettings.deletionSlots <- struct{}{}:
+	default:
+		slog.Info("waiting for a deletion slot, the maximum amount of concurrent deletions is reached", "backup_config", backupConfig.Name, "backup_name", unusedBackup.Name, "action", "delete", "max_concurrent_deletions", cap(taweretSettings.deletionSlots))
+		select {
+		case taweretSettings.deletionSlots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("stopped waiting for a deletion slot for backup %v: %w", unusedBackup.Name, ctx.Err())
+		}
+	}
+	return func() { <-taweretSettings.deletionSlots }, nil
+}
+
+// deletes the backup actionset of a backup whose backup location has been deleted
+func deleteBackupActionSet(ctx context.Context, unusedBackup retention.Backup, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, taweretMetrics taweretmetrics, taweretSettings taweretsettings, backupConfig backupconfig) error {
+	// without an audit entry the backup actionset is kept, its deletion is resumed by the next evaluation
+	if taweretSettings.auditLog != nil {
+		entry := auditentry{
+			Time:              taweretSettings.now().UTC(),
+			Instance:          hostname(),
+			BackupConfig:      backupConfig.Name,
+			KanisterNamespace: backupConfig.KanisterNamespace,
+			BackupName:        unusedBackup.Name,
+			BackupTime:        unusedBackup.Time.UTC(),
+			BackupLocation:    unusedBackup.BackupLocation,
+			Retention:         backupConfig.Retention,
+		}
+		if err := taweretSettings.auditLog.record(ctx, taweretSettings, entry); err != nil {
+			return fmt.Errorf("error recording audit entry, keeping backup actionset: %w", err)
+		}
+	}
+
+	deleteCtx, cancel := apiContext(ctx, taweretSettings)
+	err := dynamicClient.Resource(gvr).Namespace(backupConfig.KanisterNamespace).Delete(deleteCtx, unusedBackup.Name, v1.DeleteOptions{})
+	cancel()
+	if err != nil {
+		return fmt.Errorf("error deleting backup actionset: %w", err)
+	}
+	taweretMetrics.backupsDeleted.WithLabelValues(backupConfig.metricLabels()...).Inc()
+	if taweretSettings.backupDeletionTimeout > 0 {
+		return waitForBackupActionSetDeletion(ctx, unusedBackup, dynamicClient, gvr, taweretSettings, backupConfig)
+	}
+	return nil
+}
+
+// polls a deleted backup actionset until it is gone, e.g. once its finalizers are removed, a backup actionset which is still there
+// after the backup deletion timeout is only logged, as its deletion has been accepted
+func waitForBackupActionSetDeletion(ctx context.Context, unusedBackup retention.Backup, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, taweretSettings taweretsettings, backupConfig backupconfig) error {
+	deletionDeadline := time.Now().Add(taweretSettings.backupDeletionTimeout)
+	pollInterval := taweretSettings.deletionPollInterval
+	for {
+		getCtx, cancel := apiContext(ctx, taweretSettings)
+		_, err := dynamicClient.Resource(gvr).Namespace(backupConfig.KanisterNamespace).Get(getCtx, unusedBackup.Name, v1.GetOptions{})
+		cancel()
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error checking deleted backup actionset: %w", err)
+		}
+		if time.Now().After(deletionDeadline) {
+			slog.Warn("backup actionset still exists after its deletion, it may be held by a finalizer", "backup_config", backupConfig.Name, "backup_name", unusedBackup.Name, "action", "delete", "timeout", taweretSettings.backupDeletionTimeout)
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("stopped waiting for backup actionset deletion: %w", ctx.Err())
+		case <-time.After(pollInterval):
+		}
+		pollInterval = min(2*pollInterval, max(taweretSettings.deletionPollMaxInterval, taweretSettings.deletionPollInterval))
+	}
+}
+
+// creates the deletion actionset of a backup, which runs the delete action of the blueprint on the backup location
+// transient errors are retried, and a deletion actionset created in the meantime, e.g. by another instance, is awaited like an existing one
+func createDeletionActionSet(ctx context.Context, unusedBackup retention.Backup, deletionActionsetName string, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, taweretSettings taweretsettings, backupConfig backupconfig) error {
+	// construct actionset crd manifest to delete backup
+	deletionActionSet := v1alpha1.ActionSet{
+		Spec: &v1alpha1.ActionSetSpec{
+			Actions: []v1alpha1.ActionSpec{
+				{
+					Name:      backupConfig.deleteAction(),
+					Blueprint: backupConfig.BlueprintName,
+					Object:    backupConfig.deletionObject(),
+					Profile:   backupConfig.deletionProfile(),
+				},
+			},
+		},
+		TypeMeta: v1.TypeMeta{
+			APIVersion: gvr.GroupVersion().String(),
+			Kind:       "ActionSet",
+		},
+		ObjectMeta: v1.ObjectMeta{
+			Name:            deletionActionsetName,
+			Namespace:       backupConfig.KanisterNamespace,
+			OwnerReferences: backupConfig.deletionOwnerReferences(taweretSettings),
+		},
+	}
+
+	// Add Artifacts if backupLocation exists, under the artifact and key it was read from
+	if unusedBackup.BackupLocation != "" {
+		locationArtifact, locationKey := unusedBackup.LocationArtifact, unusedBackup.LocationKey
+		if locationArtifact == "" || locationKey == "" {
+			locationArtifact, locationKey = "cloudObject", "backupLocation"
+		}
+		deletionActionSet.Spec.Actions[0].Artifacts = map[string]v1alpha1.Artifact{
+			locationArtifact: {
+				KeyValue: map[string]string{
+					locationKey: unusedBackup.BackupLocation,
+				},
+			},
+		}
+	}
+
+	// convert to unstructured to apply with dynamicClient
+	myCRAsUnstructured, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&deletionActionSet)
+	if err != nil {
+		return fmt.Errorf("error converting deletion actionset %v: %w", deletionActionsetName, err)
+	}
+	myCRUnstructured := &unstructured.Unstructured{Object: myCRAsUnstructured}
+
+	// apply deletion actionset
+	var appliedActionSet *unstructured.Unstructured
+	err = retryAPICall(ctx, taweretSettings, fmt.Sprintf("create deletion actionset %v", deletionActionsetName), func(callCtx context.Context) error {
+		var err error
+		appliedActionSet, err = dynamicClient.Resource(gvr).Namespace(backupConfig.KanisterNamespace).Create(callCtx, myCRUnstructured, v1.CreateOptions{})
+		return err
+	})
+	if apierrors.IsAlreadyExists(err) {
+		slog.Info("deletion actionset was created concurrently, awaiting it", "backup_config", backupConfig.Name, "backup_name", unusedBackup.Name, "action", "delete", "actionset", deletionActionsetName)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error creating deletion actionset %v: %w", deletionActionsetName, err)
+	}
+	log.Printf("Applying the following deletion actionset: %v", appliedActionSet)
+	return nil
+}
+
+// checks with the discovery client that the API server serves the actionset resource, returns a NotFound error if it does not
+func checkActionSetResource(clientSet kubernetes.Interface, gvr schema.GroupVersionResource) error {
+	resources, err := clientSet.Discovery().ServerResourcesForGroupVersion(gvr.GroupVersion().String())
+	if err != nil {
+		return err
+	}
+	for _, resource := range resources.APIResources {
+		if resource.Name == gvr.Resource {
+			return nil
+		}
+	}
+	return apierrors.NewNotFound(gvr.GroupResource(), "")
+}
+
+// returns a context for a single Kubernetes API call, which is cancelled once the API timeout has passed
+func apiContext(ctx context.Context, taweretSettings taweretsettings) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, taweretSettings.apiTimeout)
+}
+
+// calls the Kubernetes API with an API timeout context, retrying transient errors with exponential backoff until the retries are exhausted
+func retryAPICall(ctx context.Context, taweretSettings taweretsettings, description string, call func(ctx context.Context) error) error {
+	backoff := taweretSettings.apiRetryBackoff
+	for attempt := 0; ; attempt++ {
+		callCtx, cancel := apiContext(ctx, taweretSettings)
+		err := call(callCtx)
+		cancel()
+		if err == nil || !isRetryableAPIError(err) || attempt >= taweretSettings.apiRetries || ctx.Err() != nil {
+			return err
+		}
+
+		slog.Warn("transient Kubernetes API error, retrying", "call", description, "attempt", attempt+1, "retries", taweretSettings.apiRetries, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxAPIRetryBackoff {
+			backoff = maxAPIRetryBackoff
+		}
+	}
+}
+
+// reports whether a Kubernetes API error is transient, i.e. a timeout, throttling, a server error or a network error
+func isRetryableAPIError(err error) bool {
+	if apierrors.IsTimeout(err) || apierrors.IsServerTimeout(err) || apierrors.IsTooManyRequests(err) ||
+		apierrors.IsInternalError(err) || apierrors.IsServiceUnavailable(err) || apierrors.IsUnexpectedServerError(err) {
+		return true
+	}
+	// other API errors, e.g. forbidden or not found, are permanent unless they are server errors
+	var apiStatus apierrors.APIStatus
+	if errors.As(err, &apiStatus) {
+		return apiStatus.Status().Code >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// key of the audit ConfigMap holding the audit entries
+const auditKey = "audit.json"
+
+// default amount of audit entries kept in the audit ConfigMap
+const defaultAuditSize int = 100
+
+// auditentry records the deletion of a backup actionset, it is written before the backup actionset is deleted
+type auditentry struct {
+	Time              time.Time       `json:"time"`
+	Instance          string          `json:"instance"`
+	BackupConfig      string          `json:"backupConfig"`
+	KanisterNamespace string          `json:"kanisterNamespace"`
+	BackupName        string          `json:"backupName"`
+	BackupTime        time.Time       `json:"backupTime"`
+	BackupLocation    string          `json:"backupLocation,omitempty"`
+	Retention         backupretention `json:"retention"`
+}
+
+// auditlog keeps the latest audit entries in a ConfigMap as a ring buffer, so that the deletion history outlives the pod logs
+type auditlog struct {
+	clientSet kubernetes.Interface
+	namespace string
+	name      string
+	size      int
+	// serialises the updates of concurrently evaluated backup configs, conflicts with other instances are retried
+	mutex sync.Mutex
+}
+
+// returns the audit log writing to the ConfigMap namespace/name, or name in the first config namespace
+func newAuditLog(clientSet kubernetes.Interface, taweretSettings taweretsettings) *auditlog {
+	namespace, name, ok := strings.Cut(taweretSettings.auditConfigMap, "/")
+	if !ok {
+		namespace, name = taweretSettings.configNamespaces[0], taweretSettings.auditConfigMap
+	}
+	return &auditlog{clientSet: clientSet, namespace: namespace, name: name, size: taweretSettings.auditSize}
+}
+
+// appends an entry to the audit ConfigMap, creating it if it does not exist, the oldest entries beyond the size are dropped
+func (auditLog *auditlog) record(ctx context.Context, taweretSettings taweretsettings, entry auditentry) error {
+	auditLog.mutex.Lock()
+	defer auditLog.mutex.Unlock()
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		getCtx, cancel := apiContext(ctx, taweretSettings)
+		configMap, err := auditLog.clientSet.CoreV1().ConfigMaps(auditLog.namespace).Get(getCtx, auditLog.name, v1.GetOptions{})
+		cancel()
+		found := err == nil
+		if apierrors.IsNotFound(err) {
+			configMap = &corev1.ConfigMap{ObjectMeta: v1.ObjectMeta{Name: auditLog.name, Namespace: auditLog.namespace}}
+		} else if err != nil {
+			return fmt.Errorf("error getting audit configmap %v/%v: %w", auditLog.namespace, auditLog.name, err)
+		}
+
+		entries, err := parseAuditEntries(configMap)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		if len(entries) > auditLog.size {
+			entries = entries[len(entries)-auditLog.size:]
+		}
+		data, err := json.Marshal(entries)
+		if err != nil {
+			return fmt.Errorf("error marshalling audit entries: %w", err)
+		}
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		configMap.Data[auditKey] = string(data)
+
+		writeCtx, cancel := apiContext(ctx, taweretSettings)
+		defer cancel()
+		if !found {
+			_, err = auditLog.clientSet.CoreV1().ConfigMaps(auditLog.namespace).Create(writeCtx, configMap, v1.CreateOptions{})
+			// a concurrently created audit configmap is retried like a conflicting update
+			if apierrors.IsAlreadyExists(err) {
+				return apierrors.NewConflict(corev1.Resource("configmaps"), auditLog.name, err)
+			}
+		} else {
+			_, err = auditLog.clientSet.CoreV1().ConfigMaps(auditLog.namespace).Update(writeCtx, configMap, v1.UpdateOptions{})
+		}
+		if err != nil && !apierrors.IsConflict(err) {
+			return fmt.Errorf("error writing audit configmap %v/%v: %w", auditLog.namespace, auditLog.name, err)
+		}
+		return err
+	})
+}
+
+// returns the audit entries of the audit ConfigMap, oldest first, and none if it does not exist yet
+func (auditLog *auditlog) entries(ctx context.Context, taweretSettings taweretsettings) ([]auditentry, error) {
+	getCtx, cancel := apiContext(ctx, taweretSettings)
+	defer cancel()
+	configMap, err := auditLog.clientSet.CoreV1().ConfigMaps(auditLog.namespace).Get(getCtx, auditLog.name, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return []auditentry{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error getting audit configmap %v/%v: %w", auditLog.namespace, auditLog.name, err)
+	}
+	return parseAuditEntries(configMap)
+}
+
+// parses the audit entries of an audit ConfigMap
+func parseAuditEntries(configMap *corev1.ConfigMap) ([]auditentry, error) {
+	entries := []auditentry{}
+	if data := configMap.Data[auditKey]; data != "" {
+		if err := json.Unmarshal([]byte(data), &entries); err != nil {
+			return nil, fmt.Errorf("error parsing %v of audit configmap %v/%v: %w", auditKey, configMap.Namespace, configMap.Name, err)
+		}
+	}
+	return entries, nil
+}
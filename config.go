@@ -0,0 +1,652 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kanisterio/kanister/pkg/apis/cr/v1alpha1"
+	"github.com/swissdatasciencecenter/taweret/internal/retention"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+type backupconfig struct {
+	Name              string `yaml:"name" json:"name"`
+	KanisterNamespace string `yaml:"kanisterNamespace" json:"kanisterNamespace"`
+	BlueprintName     string `yaml:"blueprintName" json:"blueprintName"`
+	ProfileName       string `yaml:"profileName" json:"profileName"`
+	// name of the blueprint action run by the deletion actionsets, defaults to delete
+	DeleteActionName string `yaml:"deleteActionName" json:"deleteActionName"`
+	// namespace of the profile, defaults to the kanister namespace
+	ProfileNamespace string `yaml:"profileNamespace" json:"profileNamespace"`
+	// object referenced by the deletion actionsets, defaults to the kanister namespace
+	ObjectKind      string `yaml:"objectKind" json:"objectKind"`
+	ObjectName      string `yaml:"objectName" json:"objectName"`
+	ObjectNamespace string `yaml:"objectNamespace" json:"objectNamespace"`
+	// pauses the evaluation of the backup config if set to false, defaults to true
+	Enabled *bool `yaml:"enabled" json:"enabled"`
+	// if set, the backups are categorised and reported in the metrics, but never deleted
+	ReportOnly bool `yaml:"reportOnly" json:"reportOnly"`
+	// label selector passed to the API server to filter the listed actionsets, backups are still matched by their backup-schedule
+	LabelSelector string `yaml:"labelSelector" json:"labelSelector"`
+	// where the schedule of backups matched against the name is read from, option:<key>, label:<key> or annotation:<key>,
+	// defaults to option:backup-schedule
+	ScheduleSource string `yaml:"scheduleSource" json:"scheduleSource"`
+	// prefixes of the action names of backup actionsets, defaults to backup
+	BackupActionPrefixes []string `yaml:"backupActionPrefixes" json:"backupActionPrefixes"`
+	// ordered candidate artifact.key paths of the backup location in the artifacts of backup actionsets, defaults to cloudObject.backupLocation
+	BackupLocationPaths []string `yaml:"backupLocationPaths" json:"backupLocationPaths"`
+	// artifact.key path of the backup size in the artifacts of backup actionsets, the size is not read if unset
+	BackupSizePath string `yaml:"backupSizePath" json:"backupSizePath"`
+	// artifact.key path in the artifacts of deletion actionsets which the delete action sets to true once the backup location is
+	// removed, deletions are not verified if unset
+	DeletionVerificationPath string `yaml:"deletionVerificationPath" json:"deletionVerificationPath"`
+	// IANA time zone in which day, week and month boundaries are determined, defaults to UTC
+	Timezone string `yaml:"timezone" json:"timezone"`
+	// backups are only deleted within this daily window in the time zone, e.g. 02:00-04:00, deletions outside of it are deferred,
+	// a window ending before its start spans midnight, backups are deleted at any time if unset
+	DeletionWindow string `yaml:"deletionWindow" json:"deletionWindow"`
+	// caps the amount of backups deleted per evaluation, overrides TAWERET_MAX_DELETIONS_PER_RUN when set
+	MaxDeletionsPerRun StringInt `yaml:"maxDeletionsPerRun" json:"maxDeletionsPerRun"`
+	// the newest completed backup is always retained, unless allowDeletingNewestBackup is set
+	AllowDeletingNewestBackup bool            `yaml:"allowDeletingNewestBackup" json:"allowDeletingNewestBackup"`
+	Retention                 backupretention `yaml:"retention" json:"retention"`
+	// ConfigMap or BackupConfig resource the backup config was read from and its namespace, it owns the deletion actionsets
+	// if TAWERET_DELETION_OWNER is config
+	source          v1.OwnerReference
+	sourceNamespace string
+}
+
+// backupretention is the retention section of a backup config
+type backupretention struct {
+	Backups StringInt `yaml:"backups" json:"backups"`
+	Minutes StringInt `yaml:"minutes" json:"minutes"`
+	Hours   StringInt `yaml:"hours" json:"hours"`
+	Days    StringInt `yaml:"days" json:"days"`
+	Months  StringInt `yaml:"months" json:"months"`
+	Years   StringInt `yaml:"years" json:"years"`
+	// grandfather-father-son retention, keeps the newest backup of each of the most recent days, weeks and months
+	KeepDaily   StringInt `yaml:"keepDaily" json:"keepDaily"`
+	KeepWeekly  StringInt `yaml:"keepWeekly" json:"keepWeekly"`
+	KeepMonthly StringInt `yaml:"keepMonthly" json:"keepMonthly"`
+	// restricts the weekly buckets to backups taken on this weekday, e.g. Sunday
+	KeepWeekday string `yaml:"keepWeekday" json:"keepWeekday"`
+	// tiered retention, keeps the newest backup of each of the most recent keep intervals of each tier
+	Tiers []backupretentiontier `yaml:"tiers" json:"tiers"`
+	// the most recent minBackups completed backups are always retained, regardless of the rules above
+	MinBackups StringInt `yaml:"minBackups" json:"minBackups"`
+	// if set, the most recent keepFailed failed backups within the retention period are retained separately from the completed backups
+	KeepFailed StringInt `yaml:"keepFailed" json:"keepFailed"`
+	// if false, failed backups within the retention period are retained without counting towards backups, defaults to true
+	CountFailedTowardRetention *bool `yaml:"countFailedTowardRetention" json:"countFailedTowardRetention"`
+	// if set, the nearest completed backup before each failed backup within the retention period is retained
+	KeepBeforeFailure bool `yaml:"keepBeforeFailure" json:"keepBeforeFailure"`
+}
+
+// backupretentiontier is a tier of the retention section, the interval is a duration such as 12h or a number of days or weeks such as 7d or 4w
+type backupretentiontier struct {
+	Interval string    `yaml:"interval" json:"interval"`
+	Keep     StringInt `yaml:"keep" json:"keep"`
+}
+
+// backupConfigGVR is the BackupConfig custom resource, from which backup configs are read if TAWERET_CONFIG_SOURCE is crd
+var backupConfigGVR = schema.GroupVersionResource{
+	Group:    "cr.taweret.io",
+	Version:  "v1alpha1",
+	Resource: "backupconfigs",
+}
+
+// StringInt is a type for custom YAML unmarshalling
+type StringInt int
+
+// a backup config together with the ConfigMap or custom resource it was read from
+type loadedbackupconfig struct {
+	source       string
+	backupConfig backupconfig
+}
+
+// reads the valid backup configs from the config namespaces, invalid backup configs are logged and skipped
+func getBackupConfigs(ctx context.Context, dynamicClient dynamic.Interface, clientset kubernetes.Interface, taweretMetrics taweretmetrics, taweretSettings taweretsettings) ([]backupconfig, error) {
+	var backupConfigs []backupconfig
+
+	for _, configNamespace := range taweretSettings.configNamespaces {
+		var loadedBackupConfigs []loadedbackupconfig
+		var err error
+		if taweretSettings.configSource == "crd" {
+			loadedBackupConfigs, err = getResourceBackupConfigs(ctx, dynamicClient, taweretMetrics, taweretSettings, configNamespace)
+		} else {
+			loadedBackupConfigs, err = getConfigMapBackupConfigs(ctx, clientset, taweretMetrics, taweretSettings, configNamespace)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// backup configs left to other instances and invalid backup configs do not count as loaded
+		loadedConfigs := 0
+		for _, loaded := range loadedBackupConfigs {
+			backupConfig := loaded.backupConfig
+			// backup configs left to other Taweret instances are skipped before validation, so that their errors are not reported twice
+			if !configSelected(backupConfig.Name, taweretSettings) {
+				slog.Debug("backup config not selected by the allowlist and denylist, skipping", "backup_config", backupConfig.Name, "source", loaded.source)
+				continue
+			}
+			backupConfig.Retention = backupConfig.Retention.withDefaults(taweretSettings.defaultRetention)
+
+			// skip backup configs whose retention would mark every backup for deletion
+			if err := backupConfig.validate(); err != nil {
+				slog.Error("invalid backup config, skipping", "backup_config", backupConfig.Name, "source", loaded.source, "error", err)
+				taweretMetrics.invalidConfigs.WithLabelValues(backupConfig.metricLabels()...).Set(1)
+				continue
+			}
+			taweretMetrics.invalidConfigs.WithLabelValues(backupConfig.metricLabels()...).Set(0)
+
+			backupConfigs = append(backupConfigs, backupConfig)
+			loadedConfigs++
+
+			log.Printf("backup config:\n name: %v\n kanister namespace: %v\n blueprint name: %v\n profile name: %v\n retention:\n backups: %v\n years: %v months: %v days: %v hours %v minutes: %v\n keep daily: %v keep weekly: %v keep monthly: %v", backupConfig.Name, backupConfig.KanisterNamespace, backupConfig.BlueprintName, backupConfig.ProfileName, backupConfig.Retention.Backups, backupConfig.Retention.Years, backupConfig.Retention.Months, backupConfig.Retention.Days, backupConfig.Retention.Hours, backupConfig.Retention.Minutes, backupConfig.Retention.KeepDaily, backupConfig.Retention.KeepWeekly, backupConfig.Retention.KeepMonthly)
+		}
+		taweretMetrics.configsLoaded.WithLabelValues(configNamespace).Set(float64(loadedConfigs))
+	}
+	return backupConfigs, nil
+}
+
+// returns whether a backup config is managed by this Taweret instance, that is whether its name matches a glob of the allowlist, if
+// one is set, and none of the denylist
+func configSelected(name string, taweretSettings taweretsettings) bool {
+	matchesAny := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			// the patterns are validated at startup
+			if matched, _ := path.Match(pattern, name); matched {
+				return true
+			}
+		}
+		return false
+	}
+	if len(taweretSettings.configAllowlist) > 0 && !matchesAny(taweretSettings.configAllowlist) {
+		return false
+	}
+	return !matchesAny(taweretSettings.configDenylist)
+}
+
+// reads the backup configs from the config key of the ConfigMaps in a namespace, backup-config.yaml by default, ConfigMaps with
+// malformed YAML are logged and skipped, so that they do not stop the retention of the other backup configs
+func getConfigMapBackupConfigs(ctx context.Context, clientset kubernetes.Interface, taweretMetrics taweretmetrics, taweretSettings taweretsettings, configNamespace string) ([]loadedbackupconfig, error) {
+	var loadedBackupConfigs []loadedbackupconfig
+
+	// get configmaps
+	var configmaps *corev1.ConfigMapList
+	err := retryAPICall(ctx, taweretSettings, fmt.Sprintf("list configmaps in namespace %v", configNamespace), func(callCtx context.Context) error {
+		var err error
+		configmaps, err = clientset.CoreV1().ConfigMaps(configNamespace).List(callCtx, v1.ListOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting configmaps in namespace %v: %w", configNamespace, err)
+	}
+	// ConfigMaps without the config key, e.g. because of a typo in the key, are skipped silently, comparing the amount
+	// of scanned ConfigMaps with the amount of loaded backup configs reveals them
+	taweretMetrics.configMapsScanned.WithLabelValues(configNamespace).Set(float64(len(configmaps.Items)))
+
+	for _, configmap := range configmaps.Items {
+		if configmap.Data[taweretSettings.backupConfigKey()] != "" {
+			backupConfigs, err := parseBackupConfigs(configmap.Data[taweretSettings.backupConfigKey()])
+			if err != nil {
+				slog.Error("error unmarshalling backup configs, skipping configmap", "source", fmt.Sprintf("configmap %v/%v", configNamespace, configmap.Name), "config_key", taweretSettings.backupConfigKey(), "error", err)
+				taweretMetrics.configParseErrors.WithLabelValues(configNamespace, configmap.Name).Inc()
+				continue
+			}
+			for _, backupConfig := range backupConfigs {
+				backupConfig.source = v1.OwnerReference{APIVersion: "v1", Kind: "ConfigMap", Name: configmap.Name, UID: configmap.UID}
+				backupConfig.sourceNamespace = configNamespace
+				loadedBackupConfigs = append(loadedBackupConfigs, loadedbackupconfig{source: fmt.Sprintf("configmap %v/%v", configNamespace, configmap.Name), backupConfig: backupConfig})
+			}
+		}
+	}
+	return loadedBackupConfigs, nil
+}
+
+// parses the config key of a ConfigMap, which holds either a single backup config or a YAML list of backup configs
+func parseBackupConfigs(data string) ([]backupconfig, error) {
+	var document interface{}
+	if err := yaml.Unmarshal([]byte(data), &document); err != nil {
+		return nil, err
+	}
+	if _, isList := document.([]interface{}); isList {
+		var backupConfigs []backupconfig
+		if err := yaml.Unmarshal([]byte(data), &backupConfigs); err != nil {
+			return nil, err
+		}
+		return backupConfigs, nil
+	}
+	var backupConfig backupconfig
+	if err := yaml.Unmarshal([]byte(data), &backupConfig); err != nil {
+		return nil, err
+	}
+	return []backupconfig{backupConfig}, nil
+}
+
+// reads the backup configs from the BackupConfig custom resources in a namespace, the name defaults to the name of the resource
+// resources whose spec cannot be decoded are logged and skipped
+func getResourceBackupConfigs(ctx context.Context, dynamicClient dynamic.Interface, taweretMetrics taweretmetrics, taweretSettings taweretsettings, configNamespace string) ([]loadedbackupconfig, error) {
+	var loadedBackupConfigs []loadedbackupconfig
+
+	var resources *unstructured.UnstructuredList
+	err := retryAPICall(ctx, taweretSettings, fmt.Sprintf("list backupconfigs in namespace %v", configNamespace), func(callCtx context.Context) error {
+		var err error
+		resources, err = dynamicClient.Resource(backupConfigGVR).Namespace(configNamespace).List(callCtx, v1.ListOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting backupconfigs in namespace %v: %w", configNamespace, err)
+	}
+
+	for _, resource := range resources.Items {
+		// the spec has the same fields as backup-config.yaml and is validated by the CRD schema, JSON is decoded as YAML
+		var backupConfig backupconfig
+		spec, err := json.Marshal(resource.Object["spec"])
+		if err == nil {
+			err = yaml.Unmarshal(spec, &backupConfig)
+		}
+		if err != nil {
+			slog.Error("error unmarshalling spec, skipping backupconfig", "source", fmt.Sprintf("backupconfig %v/%v", configNamespace, resource.GetName()), "error", err)
+			taweretMetrics.configParseErrors.WithLabelValues(configNamespace, resource.GetName()).Inc()
+			continue
+		}
+		if backupConfig.Name == "" {
+			backupConfig.Name = resource.GetName()
+		}
+		backupConfig.source = v1.OwnerReference{APIVersion: backupConfigGVR.GroupVersion().String(), Kind: "BackupConfig", Name: resource.GetName(), UID: resource.GetUID()}
+		backupConfig.sourceNamespace = configNamespace
+		loadedBackupConfigs = append(loadedBackupConfigs, loadedbackupconfig{source: fmt.Sprintf("backupconfig %v/%v", configNamespace, resource.GetName()), backupConfig: backupConfig})
+	}
+	return loadedBackupConfigs, nil
+}
+
+// checks that the backup location and size paths and the keep weekday of the backup config are well-formed and that its retention
+// policy is valid
+func (backupConfig backupconfig) validate() error {
+	for _, path := range backupConfig.BackupLocationPaths {
+		if artifact, key, ok := strings.Cut(path, "."); !ok || artifact == "" || key == "" {
+			return fmt.Errorf("backup location path %q must have the form artifact.key", path)
+		}
+	}
+	if backupConfig.BackupSizePath != "" {
+		if artifact, key, ok := strings.Cut(backupConfig.BackupSizePath, "."); !ok || artifact == "" || key == "" {
+			return fmt.Errorf("backup size path %q must have the form artifact.key", backupConfig.BackupSizePath)
+		}
+	}
+	if backupConfig.DeletionVerificationPath != "" {
+		if artifact, key, ok := strings.Cut(backupConfig.DeletionVerificationPath, "."); !ok || artifact == "" || key == "" {
+			return fmt.Errorf("deletion verification path %q must have the form artifact.key", backupConfig.DeletionVerificationPath)
+		}
+	}
+	if _, err := parseScheduleSource(backupConfig.ScheduleSource); err != nil {
+		return err
+	}
+	if backupConfig.Timezone != "" {
+		if _, err := time.LoadLocation(backupConfig.Timezone); err != nil {
+			return fmt.Errorf("unknown time zone %q: %w", backupConfig.Timezone, err)
+		}
+	}
+	if backupConfig.DeletionWindow != "" {
+		if _, _, err := parseDeletionWindow(backupConfig.DeletionWindow); err != nil {
+			return err
+		}
+	}
+	if backupConfig.Retention.KeepWeekday != "" {
+		if _, err := parseWeekday(backupConfig.Retention.KeepWeekday); err != nil {
+			return err
+		}
+	}
+	for _, tier := range backupConfig.Retention.Tiers {
+		if _, err := parseInterval(tier.Interval); err != nil {
+			return err
+		}
+	}
+	return backupConfig.policy().Validate()
+}
+
+// returns the retention with its unset fields inherited from the default retention, a zero value counts as unset
+func (backupRetention backupretention) withDefaults(defaultRetention backupretention) backupretention {
+	inherit := func(value *StringInt, defaultValue StringInt) {
+		if *value == 0 {
+			*value = defaultValue
+		}
+	}
+	inherit(&backupRetention.Backups, defaultRetention.Backups)
+	inherit(&backupRetention.Minutes, defaultRetention.Minutes)
+	inherit(&backupRetention.Hours, defaultRetention.Hours)
+	inherit(&backupRetention.Days, defaultRetention.Days)
+	inherit(&backupRetention.Months, defaultRetention.Months)
+	inherit(&backupRetention.Years, defaultRetention.Years)
+	inherit(&backupRetention.KeepDaily, defaultRetention.KeepDaily)
+	inherit(&backupRetention.KeepWeekly, defaultRetention.KeepWeekly)
+	inherit(&backupRetention.KeepMonthly, defaultRetention.KeepMonthly)
+	inherit(&backupRetention.MinBackups, defaultRetention.MinBackups)
+	inherit(&backupRetention.KeepFailed, defaultRetention.KeepFailed)
+	if backupRetention.KeepWeekday == "" {
+		backupRetention.KeepWeekday = defaultRetention.KeepWeekday
+	}
+	if len(backupRetention.Tiers) == 0 {
+		backupRetention.Tiers = defaultRetention.Tiers
+	}
+	if !backupRetention.KeepBeforeFailure {
+		backupRetention.KeepBeforeFailure = defaultRetention.KeepBeforeFailure
+	}
+	if backupRetention.CountFailedTowardRetention == nil {
+		backupRetention.CountFailedTowardRetention = defaultRetention.CountFailedTowardRetention
+	}
+	return backupRetention
+}
+
+// returns the retention policy of the backup config, an invalid keep weekday is ignored as it is rejected by validate
+func (backupConfig backupconfig) policy() retention.Policy {
+	var keepWeekday *time.Weekday
+	if weekday, err := parseWeekday(backupConfig.Retention.KeepWeekday); err == nil {
+		keepWeekday = &weekday
+	}
+	// tiers with an invalid interval are rejected by validate
+	var tiers []retention.Tier
+	for _, tier := range backupConfig.Retention.Tiers {
+		if interval, err := parseInterval(tier.Interval); err == nil {
+			tiers = append(tiers, retention.Tier{Interval: interval, Keep: int(tier.Keep)})
+		}
+	}
+	return retention.Policy{
+		Backups:     int(backupConfig.Retention.Backups),
+		Minutes:     int(backupConfig.Retention.Minutes),
+		Hours:       int(backupConfig.Retention.Hours),
+		Days:        int(backupConfig.Retention.Days),
+		Months:      int(backupConfig.Retention.Months),
+		Years:       int(backupConfig.Retention.Years),
+		KeepDaily:   int(backupConfig.Retention.KeepDaily),
+		KeepWeekly:  int(backupConfig.Retention.KeepWeekly),
+		KeepMonthly: int(backupConfig.Retention.KeepMonthly),
+		KeepWeekday: keepWeekday,
+		Tiers:       tiers,
+		MinBackups:  int(backupConfig.Retention.MinBackups),
+		KeepFailed:  int(backupConfig.Retention.KeepFailed),
+		// failed backups count towards the backups unless explicitly disabled
+		ExcludeFailed:     backupConfig.Retention.CountFailedTowardRetention != nil && !*backupConfig.Retention.CountFailedTowardRetention,
+		KeepBeforeFailure: backupConfig.Retention.KeepBeforeFailure,
+		Location:          backupConfig.location(),
+		// the safety rule retaining the newest completed backup is only disabled on request
+		AllowDeletingNewest: backupConfig.AllowDeletingNewestBackup,
+	}
+}
+
+// returns the owner references of the deletion actionsets of the backup config, the source of the backup config if
+// TAWERET_DELETION_OWNER is config, Kubernetes only allows owners in the namespace of the deletion actionsets
+func (backupConfig backupconfig) deletionOwnerReferences(taweretSettings taweretsettings) []v1.OwnerReference {
+	if taweretSettings.deletionOwner != "config" || backupConfig.source.UID == "" {
+		return nil
+	}
+	if backupConfig.sourceNamespace != backupConfig.KanisterNamespace {
+		slog.Warn("backup config is not in its kanister namespace, creating deletion actionset without owner", "backup_config", backupConfig.Name, "config_namespace", backupConfig.sourceNamespace, "kanister_namespace", backupConfig.KanisterNamespace)
+		return nil
+	}
+	return []v1.OwnerReference{backupConfig.source}
+}
+
+// returns the candidate artifact and key pairs of the backup location, in the order in which they are tried
+func (backupConfig backupconfig) backupLocationPaths() [][2]string {
+	paths := backupConfig.BackupLocationPaths
+	if len(paths) == 0 {
+		paths = []string{"cloudObject.backupLocation"}
+	}
+	var artifactKeys [][2]string
+	for _, path := range paths {
+		if artifact, key, ok := strings.Cut(path, "."); ok {
+			artifactKeys = append(artifactKeys, [2]string{artifact, key})
+		}
+	}
+	return artifactKeys
+}
+
+// parses the interval of a retention tier, either a duration such as 12h or a number of days or weeks such as 7d or 4w
+func parseInterval(interval string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if count, err := strconv.Atoi(strings.TrimSuffix(interval, suffix)); err == nil && strings.HasSuffix(interval, suffix) {
+			return time.Duration(count) * unit, nil
+		}
+	}
+	duration, err := time.ParseDuration(interval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid retention tier interval %q, expected a duration such as 12h or a number of days or weeks such as 7d or 4w", interval)
+	}
+	return duration, nil
+}
+
+// parses a deletion window of the form HH:MM-HH:MM, returns its start and end as minutes after midnight
+func parseDeletionWindow(window string) (int, int, error) {
+	minutes := func(clock string) (int, error) {
+		parsed, err := time.Parse("15:04", strings.TrimSpace(clock))
+		if err != nil {
+			return 0, err
+		}
+		return parsed.Hour()*60 + parsed.Minute(), nil
+	}
+	start, end, ok := strings.Cut(window, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid deletion window %q, expected HH:MM-HH:MM", window)
+	}
+	startMinutes, err := minutes(start)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid deletion window %q, expected HH:MM-HH:MM: %w", window, err)
+	}
+	endMinutes, err := minutes(end)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid deletion window %q, expected HH:MM-HH:MM: %w", window, err)
+	}
+	if startMinutes == endMinutes {
+		return 0, 0, fmt.Errorf("invalid deletion window %q, the start and the end must differ", window)
+	}
+	return startMinutes, endMinutes, nil
+}
+
+// returns whether backups of the backup config may be deleted at now, always without a deletion window
+func (backupConfig backupconfig) inDeletionWindow(now time.Time) bool {
+	start, end, err := parseDeletionWindow(backupConfig.DeletionWindow)
+	if backupConfig.DeletionWindow == "" || err != nil {
+		return true
+	}
+	local := now.In(backupConfig.location())
+	minute := local.Hour()*60 + local.Minute()
+	if start < end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// parses a weekday by its English name or its three-letter abbreviation, ignoring case
+func parseWeekday(name string) (time.Weekday, error) {
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		if strings.EqualFold(name, weekday.String()) || strings.EqualFold(name, weekday.String()[:3]) {
+			return weekday, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown weekday %q", name)
+}
+
+// returns the object referenced by the deletion actionsets, each unset field defaults to the kanister namespace
+func (backupConfig backupconfig) deletionObject() v1alpha1.ObjectReference {
+	object := v1alpha1.ObjectReference{
+		Kind:      backupConfig.ObjectKind,
+		Name:      backupConfig.ObjectName,
+		Namespace: backupConfig.ObjectNamespace,
+	}
+	if object.Kind == "" {
+		object.Kind = "namespace"
+	}
+	if object.Name == "" {
+		object.Name = backupConfig.KanisterNamespace
+	}
+	if object.Namespace == "" {
+		object.Namespace = backupConfig.KanisterNamespace
+	}
+	return object
+}
+
+// returns the name of the blueprint action run by the deletion actionsets
+func (backupConfig backupconfig) deleteAction() string {
+	if backupConfig.DeleteActionName == "" {
+		return "delete"
+	}
+	return backupConfig.DeleteActionName
+}
+
+// returns the profile referenced by the deletion actionsets, or nil if the backup config has no profile
+func (backupConfig backupconfig) deletionProfile() *v1alpha1.ObjectReference {
+	if backupConfig.ProfileName == "" {
+		return nil
+	}
+	profile := &v1alpha1.ObjectReference{
+		Name:      backupConfig.ProfileName,
+		Namespace: backupConfig.ProfileNamespace,
+	}
+	if profile.Namespace == "" {
+		profile.Namespace = backupConfig.KanisterNamespace
+	}
+	return profile
+}
+
+// schedulesource is where the schedule of a backup actionset is read from, an option of its backup action, a label or an annotation
+type schedulesource struct {
+	kind string
+	key  string
+}
+
+// defaultScheduleSource is the backup-schedule option set by kanctl create actionset --options backup-schedule=<name>
+var defaultScheduleSource = schedulesource{kind: "option", key: "backup-schedule"}
+
+// parses a schedule source of the form kind:key, the default schedule source if it is empty
+func parseScheduleSource(value string) (schedulesource, error) {
+	if value == "" {
+		return defaultScheduleSource, nil
+	}
+	kind, key, ok := strings.Cut(value, ":")
+	if !ok || key == "" || (kind != "option" && kind != "label" && kind != "annotation") {
+		return schedulesource{}, fmt.Errorf("schedule source %q must have the form option:<key>, label:<key> or annotation:<key>", value)
+	}
+	return schedulesource{kind: kind, key: key}, nil
+}
+
+// returns the schedule source of the backup config, an invalid schedule source is rejected by validate
+func (backupConfig backupconfig) scheduleSource() schedulesource {
+	source, err := parseScheduleSource(backupConfig.ScheduleSource)
+	if err != nil {
+		return defaultScheduleSource
+	}
+	return source
+}
+
+// returns the schedule of an actionset read from the schedule source, and false if it is not set
+func (source schedulesource) schedule(actionset unstructured.Unstructured, actionSpec map[string]interface{}) (string, bool) {
+	var schedule string
+	switch source.kind {
+	case "label":
+		schedule = actionset.GetLabels()[source.key]
+	case "annotation":
+		schedule = actionset.GetAnnotations()[source.key]
+	default:
+		options, _ := actionSpec["options"].(map[string]interface{})
+		schedule, _ = options[source.key].(string)
+	}
+	return schedule, schedule != ""
+}
+
+func (source schedulesource) String() string {
+	return source.kind + ":" + source.key
+}
+
+// returns whether an action name starts with one of the backup action prefixes of the backup config
+func (backupConfig backupconfig) isBackupAction(actionName string) bool {
+	prefixes := backupConfig.BackupActionPrefixes
+	if len(prefixes) == 0 {
+		prefixes = []string{"backup"}
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(actionName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns whether the backup config is evaluated, backup configs are enabled unless enabled is explicitly set to false
+func (backupConfig backupconfig) enabled() bool {
+	return backupConfig.Enabled == nil || *backupConfig.Enabled
+}
+
+// returns whether the backups of the backup config are only reported, because the backup config or all backup configs are report only
+func (backupConfig backupconfig) reportOnly(taweretSettings taweretsettings) bool {
+	return backupConfig.ReportOnly || taweretSettings.reportOnly
+}
+
+// returns the time zone of the backup config, falling back to UTC if it is unset or unknown
+// validate rejects unknown time zones, so the fallback only applies to backup configs which were not validated
+func (backupConfig backupconfig) location() *time.Location {
+	if backupConfig.Timezone == "" {
+		return time.UTC
+	}
+	location, err := time.LoadLocation(backupConfig.Timezone)
+	if err != nil {
+		slog.Warn("unknown time zone, falling back to UTC", "backup_config", backupConfig.Name, "timezone", backupConfig.Timezone, "error", err)
+		return time.UTC
+	}
+	return location
+}
+
+// UnmarshalYAML is a custom YAML unmarshaller to allow string to stringint type conversion
+// unrecognised types are rejected, so that a malformed value cannot silently become a zero retention
+func (st *StringInt) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var item interface{}
+	if err := unmarshal(&item); err != nil {
+		return err
+	}
+	switch v := item.(type) {
+	case int:
+		*st = StringInt(v)
+	case int64:
+		if v > math.MaxInt || v < math.MinInt {
+			return fmt.Errorf("integer %v is out of range", v)
+		}
+		*st = StringInt(v)
+	case uint64:
+		if v > math.MaxInt {
+			return fmt.Errorf("integer %v is out of range", v)
+		}
+		*st = StringInt(v)
+	case float64:
+		// fractions are rejected instead of truncated, float64(math.MaxInt) rounds up to 2^63, which does not fit into an int
+		if v != math.Trunc(v) {
+			return fmt.Errorf("number %v is not an integer", v)
+		}
+		if v >= math.MaxInt || v < math.MinInt {
+			return fmt.Errorf("integer %v is out of range", v)
+		}
+		*st = StringInt(int(v))
+	case string:
+		i, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return err
+		}
+		*st = StringInt(i)
+	default:
+		return fmt.Errorf("cannot unmarshal %v of type %T into an integer", v, v)
+	}
+	return nil
+}
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-co-op/gocron"
+	"github.com/swissdatasciencecenter/taweret/internal/retention"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// taweretstatus tracks the outcome of the evaluations, it is shared between the scheduler and the HTTP handlers
+type taweretstatus struct {
+	mutex                    sync.RWMutex
+	lastSuccessfulEvaluation time.Time
+	nextEvaluation           time.Time
+	// set while another instance holds the leader election lease, followers do not evaluate
+	follower bool
+	// held for the duration of an evaluation, so that scheduled and on-demand evaluations do not overlap
+	evaluationMutex sync.Mutex
+}
+
+// evaluationsummary summarises the outcome of an evaluation, it is logged at the end of each evaluation and returned by the evaluate endpoint
+type evaluationsummary struct {
+	Configs int `json:"configs"`
+	Backups int `json:"backups"`
+	Deleted int `json:"deleted"`
+	// amount of backups whose deletion failed
+	Failed int `json:"failed"`
+	// amount of deletable backups which are left to later evaluations by the deletion limits, a failed deletion, dry run or soft delete mode
+	Skipped int `json:"skipped"`
+	// amount of backup configs whose evaluation failed, and the errors with which they failed
+	Errors   int               `json:"errors"`
+	Failures []evaluationerror `json:"failures,omitempty"`
+}
+
+// adds the outcome of the evaluation of a backup config to the summary of an evaluation
+func (summary *evaluationsummary) add(configSummary evaluationsummary) {
+	summary.Backups += configSummary.Backups
+	summary.Deleted += configSummary.Deleted
+	summary.Failed += configSummary.Failed
+	summary.Skipped += configSummary.Skipped
+	summary.Errors += configSummary.Errors
+	summary.Failures = append(summary.Failures, configSummary.Failures...)
+}
+
+// evaluationerror is the error with which the evaluation of a backup config failed
+type evaluationerror struct {
+	BackupConfig string
+	// step of the evaluation which failed, list, delete, refetch or panic
+	Action string
+	Err    error
+}
+
+func (e evaluationerror) Error() string {
+	return fmt.Sprintf("backup config %v: %v: %v", e.BackupConfig, e.Action, e.Err)
+}
+
+func (e evaluationerror) Unwrap() error {
+	return e.Err
+}
+
+// the error is marshalled by its message, errors do not marshal to JSON themselves
+func (e evaluationerror) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{"backupConfig": e.BackupConfig, "action": e.Action, "error": e.Err.Error()})
+}
+
+// records the failed evaluation of a backup config in the summary
+func (summary *evaluationsummary) fail(backupConfig backupconfig, action string, err error) {
+	summary.Errors++
+	summary.Failures = append(summary.Failures, evaluationerror{BackupConfig: backupConfig.Name, Action: action, Err: err})
+}
+
+// schedules the evaluations, the jitter delaying them is interrupted once ctx is cancelled
+func scheduleEvaluations(ctx context.Context, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, clientSet kubernetes.Interface, taweretMetrics taweretmetrics, taweretSettings taweretsettings, taweretStatus *taweretstatus) *gocron.Scheduler {
+	// the cron schedule fires at the same wall clock time in every instance, so the offset is applied to every evaluation rather than
+	// to the start of the scheduler
+	jitter := randomDuration(taweretSettings.evalJitter)
+
+	// schedule backup evaluations, gocron validates the cron expression when the job is created
+	s := gocron.NewScheduler(time.UTC)
+	var job *gocron.Job
+	recordNextEvaluation := func() {
+		nextEvaluation := job.NextRun().Add(jitter)
+		taweretStatus.setNextEvaluation(nextEvaluation)
+		taweretMetrics.nextEvaluation.Set(float64(nextEvaluation.Unix()))
+	}
+	job, err := s.Cron(taweretSettings.evalSchedule).Do(func() {
+		if sleepContext(ctx, jitter) {
+			startEvaluation(dynamicClient, gvr, clientSet, taweretMetrics, taweretSettings, taweretStatus)
+			recordNextEvaluation()
+		}
+	})
+	if err != nil {
+		log.Fatalf("error creating job with evaluation schedule %q: %v", taweretSettings.evalSchedule, err)
+	}
+	s.StartAsync()
+	recordNextEvaluation()
+	log.Printf("first evaluation scheduled: %v, evaluation schedule: %v, jitter: %v", job.NextRun(), taweretSettings.evalSchedule, jitter)
+	return s
+}
+
+// scheduled evaluation of all backup configs, skipped if the previous scheduled or an on-demand evaluation is still running
+func startEvaluation(dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, clientSet kubernetes.Interface, taweretMetrics taweretmetrics, taweretSettings taweretsettings, taweretStatus *taweretstatus) {
+	// deleteBackup blocks until the deletion actionsets finish, so an evaluation may outlast the evaluation schedule
+	if !taweretStatus.evaluationMutex.TryLock() {
+		slog.Warn("previous evaluation still running, skipping scheduled evaluation", "evaluation_schedule", taweretSettings.evalSchedule)
+		taweretMetrics.evaluationsSkipped.Inc()
+		return
+	}
+	defer taweretStatus.evaluationMutex.Unlock()
+
+	// errors are logged and counted by runEvaluations
+	_, _ = runEvaluations(context.Background(), dynamicClient, gvr, clientSet, taweretMetrics, taweretSettings, taweretStatus, "")
+	taweretMetrics.lastEvaluation.SetToCurrentTime()
+}
+
+// single evaluation of all backup configs for TAWERET_RUN_ONCE, the metrics are served for the linger period afterwards so that they can be
+// scraped before the process exits, returns whether all backup configs were evaluated without errors
+func runOnce(ctx context.Context, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, clientSet kubernetes.Interface, taweretMetrics taweretmetrics, taweretSettings taweretsettings, taweretStatus *taweretstatus) bool {
+	taweretStatus.evaluationMutex.Lock()
+	summary, err := runEvaluations(ctx, dynamicClient, gvr, clientSet, taweretMetrics, taweretSettings, taweretStatus, "")
+	taweretStatus.evaluationMutex.Unlock()
+	taweretMetrics.lastEvaluation.SetToCurrentTime()
+
+	if taweretSettings.runOnceLinger > 0 {
+		log.Printf("serving metrics for %v before exiting", taweretSettings.runOnceLinger)
+		sleepContext(ctx, taweretSettings.runOnceLinger)
+	}
+	return err == nil && summary.Errors == 0
+}
+
+// evaluation of all backup configs triggered by a change to the backup configs, waits for a running evaluation to finish first
+func startConfigChangeEvaluation(dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, clientSet kubernetes.Interface, taweretMetrics taweretmetrics, taweretSettings taweretsettings, taweretStatus *taweretstatus) {
+	// a running evaluation may have loaded the backup configs before the change, so the evaluation is not skipped
+	taweretStatus.evaluationMutex.Lock()
+	defer taweretStatus.evaluationMutex.Unlock()
+
+	log.Printf("backup configs changed, starting evaluation")
+	// errors are logged and counted by runEvaluations
+	_, _ = runEvaluations(context.Background(), dynamicClient, gvr, clientSet, taweretMetrics, taweretSettings, taweretStatus, "")
+}
+
+// evaluates the backup config named configName, or all backup configs if configName is empty, the caller must hold the evaluation mutex
+func runEvaluations(ctx context.Context, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, clientSet kubernetes.Interface, taweretMetrics taweretmetrics, taweretSettings taweretsettings, taweretStatus *taweretstatus, configName string) (evaluationsummary, error) {
+	log.Printf("starting backup config evaluations\n")
+	var summary evaluationsummary
+	// the spans of the backup config evaluations and their deletions are children of the span of the evaluation cycle
+	ctx, span := tracer.Start(ctx, "evaluation", trace.WithAttributes(attribute.String("config_name", configName)))
+	defer span.End()
+
+	// get backupConfigs
+	backupConfigs, err := getBackupConfigs(ctx, dynamicClient, clientSet, taweretMetrics, taweretSettings)
+	if err != nil {
+		recordSpanError(span, err)
+		slog.Error("error getting backup configs, skipping evaluations", "error", err)
+		taweretMetrics.evaluationErrors.WithLabelValues(backupconfig{}.metricLabels()...).Inc()
+		return summary, err
+	}
+	// the schedules are only checked against all backup configs, a single backup config would leave the other schedules unmanaged
+	if taweretSettings.checkSchedules && configName == "" {
+		checkSchedules(ctx, dynamicClient, gvr, taweretMetrics, taweretSettings, backupConfigs)
+	}
+	if configName != "" {
+		var selectedConfigs []backupconfig
+		for _, backupConfig := range backupConfigs {
+			if backupConfig.Name == configName {
+				selectedConfigs = append(selectedConfigs, backupConfig)
+			}
+		}
+		backupConfigs = selectedConfigs
+	}
+	summary.Configs = len(backupConfigs)
+
+	// evaluate backupConfigs concurrently, at most maxConcurrency at a time
+	var wg sync.WaitGroup
+	var summaryMutex sync.Mutex
+	var retainedBackups []configbackup
+	// backup locations are only deleted once in an evaluation cycle, even if backups of several backup configs share them
+	deletedLocations := newDeletedLocations()
+	semaphore := make(chan struct{}, taweretSettings.maxConcurrency)
+	for _, backupConfig := range backupConfigs {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(backupConfig backupconfig) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			// isolate a panicking evaluation from the evaluations of the other backup configs
+			defer func() {
+				if r := recover(); r != nil {
+					slog.Error("backup evaluation panicked", "backup_config", backupConfig.Name, "error", r)
+					taweretMetrics.evaluationErrors.WithLabelValues(backupConfig.metricLabels()...).Inc()
+					summaryMutex.Lock()
+					defer summaryMutex.Unlock()
+					summary.fail(backupConfig, "panic", fmt.Errorf("%v", r))
+				}
+			}()
+			if !sleepContext(ctx, randomDuration(taweretSettings.configJitter)) {
+				return
+			}
+			configSummary, configRetained := evaluateBackups(ctx, dynamicClient, gvr, taweretMetrics, taweretSettings, backupConfig, deletedLocations)
+			summaryMutex.Lock()
+			defer summaryMutex.Unlock()
+			summary.add(configSummary)
+			for _, aBackup := range configRetained {
+				retainedBackups = append(retainedBackups, configbackup{backupConfig: backupConfig, backup: aBackup})
+			}
+		}(backupConfig)
+	}
+	wg.Wait()
+	// the cap applies to the backups of all backup configs, a single backup config is evaluated without it
+	if taweretSettings.maxTotalBackups > 0 && configName == "" {
+		summary.add(deleteBackupsOverTotal(ctx, dynamicClient, gvr, taweretMetrics, taweretSettings, retainedBackups, deletedLocations))
+	}
+	taweretStatus.setLastSuccessfulEvaluation(taweretSettings.now())
+	taweretMetrics.push(taweretSettings)
+	span.SetAttributes(attribute.Int("configs", summary.Configs), attribute.Int("deleted", summary.Deleted), attribute.Int("errors", summary.Errors))
+	slog.Info("backup config evaluations complete", "configs", summary.Configs, "backups", summary.Backups, "deleted", summary.Deleted, "failed", summary.Failed, "skipped", summary.Skipped, "errors", summary.Errors)
+	return summary, nil
+}
+
+// configbackup is a retained backup together with its backup config
+type configbackup struct {
+	backupConfig backupconfig
+	backup       retention.Backup
+}
+
+// configbackupname identifies a backup by its name and the name of its backup config
+type configbackupname struct {
+	backupConfig string
+	backup       string
+}
+
+// deletes the oldest retained backups of all backup configs in excess of maxTotalBackups, pinned backups and the newest completed
+// backup of each backup config are never deleted by the cap and count towards it, as do the backups of report only backup configs,
+// the deletion delay and the deletion limits of the backup configs apply
+func deleteBackupsOverTotal(ctx context.Context, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, taweretMetrics taweretmetrics, taweretSettings taweretsettings, retainedBackups []configbackup, deletedLocations *deletedlocations) evaluationsummary {
+	var summary evaluationsummary
+	excess := max(len(retainedBackups)-taweretSettings.maxTotalBackups, 0)
+
+	newest := make(map[string]time.Time)
+	for _, retained := range retainedBackups {
+		if retained.backup.Status == "complete" && retained.backup.Time.After(newest[retained.backupConfig.Name]) {
+			newest[retained.backupConfig.Name] = retained.backup.Time
+		}
+	}
+	var candidates []configbackup
+	for _, retained := range retainedBackups {
+		isNewest := retained.backup.Status == "complete" && retained.backup.Time.Equal(newest[retained.backupConfig.Name])
+		if retained.backup.Pinned || (isNewest && !retained.backupConfig.AllowDeletingNewestBackup) || retained.backupConfig.reportOnly(taweretSettings) {
+			continue
+		}
+		candidates = append(candidates, retained)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].backup.Time.Before(candidates[j].backup.Time)
+	})
+	if len(candidates) < excess {
+		slog.Warn("not enough deletable backups to enforce the total backup cap", "max_total_backups", taweretSettings.maxTotalBackups, "retained_backups", len(retainedBackups), "candidates", len(candidates))
+		excess = len(candidates)
+	}
+	if excess > 0 {
+		log.Printf("%v retained backups exceed the total backup cap of %v, deleting the %v oldest backups", len(retainedBackups), taweretSettings.maxTotalBackups, excess)
+	}
+
+	// the oldest backups are deleted grouped by backup config, in the order in which the backup configs first appear
+	var backupConfigs []backupconfig
+	backupsByConfig := make(map[string][]retention.Backup)
+	for _, candidate := range candidates[:excess] {
+		if _, ok := backupsByConfig[candidate.backupConfig.Name]; !ok {
+			backupConfigs = append(backupConfigs, candidate.backupConfig)
+		}
+		candidate.backup.InUse = false
+		backupsByConfig[candidate.backupConfig.Name] = append(backupsByConfig[candidate.backupConfig.Name], candidate.backup)
+	}
+	// the retained backups which are no longer over the cap are released, like the backups of a backup config which are retained again
+	if !taweretSettings.dryRun && taweretSettings.deletionDelay > 0 {
+		releaseBackupsUnderTotal(ctx, dynamicClient, gvr, taweretSettings, retainedBackups, backupsByConfig)
+	}
+	for _, backupConfig := range backupConfigs {
+		backups := backupsByConfig[backupConfig.Name]
+		// backups which only recently went over the cap are held back by the deletion delay and count as skipped
+		dueBackups, held := holdDeletions(ctx, backups, dynamicClient, gvr, taweretSettings, backupConfig)
+		taweretMetrics.heldDeletions.WithLabelValues(backupConfig.metricLabels()...).Add(float64(held))
+		configSummary := evaluationsummary{Skipped: held}
+		if len(dueBackups) == 0 {
+			summary.add(configSummary)
+			continue
+		}
+		deleted, err := deleteOldestBackups(ctx, dueBackups, len(dueBackups), deletedLocations, dynamicClient, gvr, taweretMetrics, taweretSettings, backupConfig)
+		configSummary.Deleted = deleted
+		configSummary.Skipped += len(dueBackups) - deleted
+		if err != nil {
+			slog.Error("error deleting backups over the total backup cap", "backup_config", backupConfig.Name, "action", "delete", "error", err)
+			taweretMetrics.evaluationErrors.WithLabelValues(backupConfig.metricLabels()...).Inc()
+			configSummary.Failed = 1
+			configSummary.Skipped--
+			configSummary.fail(backupConfig, "delete", err)
+		}
+		summary.add(configSummary)
+	}
+	return summary
+}
+
+// releases the held deletions of the retained backups which are not deleted by the total backup cap, the backups of report only
+// backup configs are never annotated
+func releaseBackupsUnderTotal(ctx context.Context, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, taweretSettings taweretsettings, retainedBackups []configbackup, overTotal map[string][]retention.Backup) {
+	deleting := make(map[configbackupname]bool)
+	for configName, backups := range overTotal {
+		for _, aBackup := range backups {
+			deleting[configbackupname{configName, aBackup.Name}] = true
+		}
+	}
+	var backupConfigs []backupconfig
+	underTotal := make(map[string][]retention.Backup)
+	for _, retained := range retainedBackups {
+		if retained.backupConfig.reportOnly(taweretSettings) || deleting[configbackupname{retained.backupConfig.Name, retained.backup.Name}] {
+			continue
+		}
+		if _, ok := underTotal[retained.backupConfig.Name]; !ok {
+			backupConfigs = append(backupConfigs, retained.backupConfig)
+		}
+		underTotal[retained.backupConfig.Name] = append(underTotal[retained.backupConfig.Name], retained.backup)
+	}
+	for _, backupConfig := range backupConfigs {
+		releaseHeldDeletions(ctx, underTotal[backupConfig.Name], dynamicClient, gvr, taweretSettings, backupConfig)
+	}
+}
+
+// records the time at which the last evaluation completed successfully
+func (taweretStatus *taweretstatus) setLastSuccessfulEvaluation(evaluationTime time.Time) {
+	taweretStatus.mutex.Lock()
+	defer taweretStatus.mutex.Unlock()
+	taweretStatus.lastSuccessfulEvaluation = evaluationTime
+}
+
+// returns the time at which the last evaluation completed successfully, or the zero time if none has completed yet
+func (taweretStatus *taweretstatus) getLastSuccessfulEvaluation() time.Time {
+	taweretStatus.mutex.RLock()
+	defer taweretStatus.mutex.RUnlock()
+	return taweretStatus.lastSuccessfulEvaluation
+}
+
+// records the time of the next scheduled evaluation
+func (taweretStatus *taweretstatus) setNextEvaluation(evaluationTime time.Time) {
+	taweretStatus.mutex.Lock()
+	defer taweretStatus.mutex.Unlock()
+	taweretStatus.nextEvaluation = evaluationTime
+}
+
+// returns the time of the next scheduled evaluation, or the zero time if the evaluations are not scheduled yet
+func (taweretStatus *taweretstatus) getNextEvaluation() time.Time {
+	taweretStatus.mutex.RLock()
+	defer taweretStatus.mutex.RUnlock()
+	return taweretStatus.nextEvaluation
+}
+
+// records whether this instance follows the leader of the leader election
+func (taweretStatus *taweretstatus) setFollower(follower bool) {
+	taweretStatus.mutex.Lock()
+	defer taweretStatus.mutex.Unlock()
+	taweretStatus.follower = follower
+}
+
+// returns whether this instance follows the leader of the leader election, it is never a follower without leader election
+func (taweretStatus *taweretstatus) isFollower() bool {
+	taweretStatus.mutex.RLock()
+	defer taweretStatus.mutex.RUnlock()
+	return taweretStatus.follower
+}
+
+// evaluates the backups of a single backup config and deletes the backups which are not retained, returns the summary of the
+// evaluation and the retained backups, which are nil if the evaluation failed
+func evaluateBackups(ctx context.Context, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, taweretMetrics taweretmetrics, taweretSettings taweretsettings, backupConfig backupconfig, deletedLocations *deletedlocations) (evaluationsummary, []retention.Backup) {
+	// paused backup configs are neither evaluated nor are their backups deleted
+	if !backupConfig.enabled() {
+		log.Printf("%v: backup config is paused, skipping evaluation\n", backupConfig.Name)
+		taweretMetrics.pausedConfigs.WithLabelValues(backupConfig.metricLabels()...).Set(1)
+		return evaluationsummary{}, nil
+	}
+	taweretMetrics.pausedConfigs.WithLabelValues(backupConfig.metricLabels()...).Set(0)
+
+	log.Printf("%v: evaluating backups\n", backupConfig.Name)
+	ctx, span := tracer.Start(ctx, "evaluateBackups", backupConfigAttributes(backupConfig))
+	defer span.End()
+	evaluationStart := time.Now()
+	defer func() {
+		taweretMetrics.evaluationDuration.WithLabelValues(backupConfig.metricLabels()...).Observe(time.Since(evaluationStart).Seconds())
+	}()
+
+	var summary evaluationsummary
+	backups, orphanedDeletions, err := listBackups(ctx, dynamicClient, gvr, taweretMetrics, taweretSettings, backupConfig)
+	if err != nil {
+		slog.Error("error getting backups, skipping evaluation", "backup_config", backupConfig.Name, "error", err)
+		taweretMetrics.evaluationErrors.WithLabelValues(backupConfig.metricLabels()...).Inc()
+		summary.fail(backupConfig, "list", err)
+		return summary, nil
+	}
+	// a backup config matching no backups usually has a typo in its schedule or label selector
+	if len(backups) == 0 {
+		slog.Warn("backup config matches no backups", "backup_config", backupConfig.Name, "kanister_namespace", backupConfig.KanisterNamespace)
+		taweretMetrics.matchedConfigs.WithLabelValues(backupConfig.metricLabels()...).Set(0)
+	} else {
+		taweretMetrics.matchedConfigs.WithLabelValues(backupConfig.metricLabels()...).Set(1)
+	}
+	summary.Backups = len(backups)
+
+	categorisedBackups, deletableBackups, backupCounts := categoriseBackups(backups, backupConfig, taweretSettings)
+	// the actionsets of a report only backup config are never modified, its backups are only categorised and reported
+	reportOnly := backupConfig.reportOnly(taweretSettings)
+	// backups which only recently became deletable are held back by the deletion delay and count as skipped
+	dueBackups := deletableBackups
+	if !reportOnly {
+		var held int
+		dueBackups, held = holdDeletions(ctx, deletableBackups, dynamicClient, gvr, taweretSettings, backupConfig)
+		taweretMetrics.heldDeletions.WithLabelValues(backupConfig.metricLabels()...).Set(float64(held))
+	}
+
+	// if there are deletable backups, delete them starting with the oldest, then refetch and recategorise the backups
+	wouldDelete := 0
+	if reportOnly {
+		log.Printf("%v: report only, not deleting %v deletable backups\n", backupConfig.Name, len(deletableBackups))
+		summary.Skipped = len(deletableBackups)
+		wouldDelete = len(deletableBackups)
+	} else if len(dueBackups) > 0 {
+		deleted, err := deleteOldestBackups(ctx, dueBackups, len(dueBackups), deletedLocations, dynamicClient, gvr, taweretMetrics, taweretSettings, backupConfig)
+		summary.Deleted = deleted
+		if err != nil {
+			slog.Error("error deleting backups, skipping evaluation", "backup_config", backupConfig.Name, "action", "delete", "error", err)
+			taweretMetrics.evaluationErrors.WithLabelValues(backupConfig.metricLabels()...).Inc()
+			// the deletions stop at the first failed deletion
+			summary.Failed = 1
+			summary.Skipped = len(deletableBackups) - deleted - 1
+			summary.fail(backupConfig, "delete", err)
+			return summary, nil
+		}
+		summary.Skipped = len(deletableBackups) - deleted
+		// in dry run mode nothing was deleted, so there is no need to refetch the backups
+		if taweretSettings.dryRun {
+			wouldDelete = len(dueBackups)
+		} else {
+			backups, orphanedDeletions, err = listBackups(ctx, dynamicClient, gvr, taweretMetrics, taweretSettings, backupConfig)
+			if err != nil {
+				slog.Error("error refetching backups, skipping evaluation", "backup_config", backupConfig.Name, "error", err)
+				taweretMetrics.evaluationErrors.WithLabelValues(backupConfig.metricLabels()...).Inc()
+				summary.fail(backupConfig, "refetch", err)
+				return summary, nil
+			}
+			categorisedBackups, deletableBackups, backupCounts = categoriseBackups(backups, backupConfig, taweretSettings)
+		}
+	} else {
+		summary.Skipped = len(deletableBackups)
+		log.Printf("%v: no backups deleted: current: %v limit: %v\n", backupConfig.Name, len(categorisedBackups), backupConfig.Retention.Backups)
+	}
+	taweretMetrics.backupsWouldDelete.WithLabelValues(backupConfig.metricLabels()...).Set(float64(wouldDelete))
+
+	// completed deletion actionsets would otherwise accumulate and slow down listing the actionsets
+	if !taweretSettings.dryRun && !reportOnly {
+		cleanupDeletionActionSets(ctx, orphanedDeletions, dynamicClient, gvr, taweretSettings, backupConfig)
+	}
+	// with a total backup cap, retained backups may be held back by the cap, which releases the other ones itself
+	if !taweretSettings.dryRun && !reportOnly && taweretSettings.deletionDelay > 0 && taweretSettings.maxTotalBackups == 0 {
+		releaseHeldDeletions(ctx, categorisedBackups, dynamicClient, gvr, taweretSettings, backupConfig)
+	}
+
+	// failed deletion actionsets are retried in the next evaluations, until then their backups are stuck
+	stuckDeletions := 0
+	for _, aBackup := range backups {
+		if aBackup.DeletionState == "failed" {
+			stuckDeletions++
+		}
+	}
+	taweretMetrics.stuckDeletions.WithLabelValues(backupConfig.metricLabels()...).Set(float64(stuckDeletions))
+
+	// backups which are still deletable after the deletions are held back by the deletion limits or failing deletions
+	oldestDeletableAge := 0.0
+	for _, aBackup := range deletableBackups {
+		oldestDeletableAge = max(oldestDeletableAge, taweretSettings.now().Sub(aBackup.Time).Seconds())
+	}
+	taweretMetrics.oldestDeletableAge.WithLabelValues(backupConfig.metricLabels()...).Set(oldestDeletableAge)
+
+	oldestUnfinishedAge, backupStuck := unfinishedBackups(backups, backupConfig, taweretSettings)
+	taweretMetrics.oldestUnfinishedAge.WithLabelValues(backupConfig.metricLabels()...).Set(oldestUnfinishedAge.Seconds())
+	taweretMetrics.backupStuck.WithLabelValues(backupConfig.metricLabels()...).Set(backupStuck)
+
+	// the time since the last deletion climbs while deletable backups are held back, e.g. by failing deletions, and is reset by a
+	// deletion or once no backups are deletable
+	resetDeletionTime := summary.Deleted > 0 || len(deletableBackups) == 0
+	taweretMetrics.sinceLastDeletion.WithLabelValues(backupConfig.metricLabels()...).Set(taweretMetrics.lastDeletions.since(backupConfig.Name, resetDeletionTime, taweretSettings.now()))
+
+	taweretMetrics.setMetrics(categorisedBackups, backupConfig, backupCounts)
+	span.SetAttributes(attribute.Int("backups", summary.Backups), attribute.Int("deleted", summary.Deleted), attribute.Int("skipped", summary.Skipped))
+
+	log.Printf("%v: backup evaluation complete\n", backupConfig.Name)
+	return summary, categorisedBackups
+}
+
+// categorises the backups of a backup config with its retention policy, returns the retained and the deletable backups
+func categoriseBackups(backups []retention.Backup, backupConfig backupconfig, taweretSettings taweretsettings) ([]retention.Backup, []retention.Backup, retention.Counts) {
+	log.Printf("%v: categorising backups\n", backupConfig.Name)
+	for _, aBackup := range backups {
+		if !retention.KnownStatus(aBackup.Status) {
+			slog.Warn("backup in an unknown state, counting it as unknown", "backup_config", backupConfig.Name, "backup_name", aBackup.Name, "state", aBackup.Status)
+		}
+	}
+	policy := backupConfig.policy()
+	policy.Grace = taweretSettings.retentionGrace
+	retainedBackups, deletableBackups, backupCounts := retention.Categorise(backups, policy, taweretSettings.now())
+	log.Printf("%v: categorised backups: %v, deletable backups: %v\n", backupConfig.Name, len(retainedBackups), len(deletableBackups))
+	if backupCounts.NewestProtected {
+		slog.Warn("retention would delete the newest completed backup, retaining it", "backup_config", backupConfig.Name)
+	}
+	return retainedBackups, deletableBackups, backupCounts
+}
+
+// returns the age of the oldest pending or running backup, measured from the creation of its actionset, and 1 if a backup has been
+// pending or running for longer than the stuck backup threshold, usually because its Kanister job hangs, or 0 otherwise
+func unfinishedBackups(backups []retention.Backup, backupConfig backupconfig, taweretSettings taweretsettings) (time.Duration, float64) {
+	var oldestAge time.Duration
+	stuck := 0.0
+	for _, aBackup := range backups {
+		if aBackup.Status != "pending" && aBackup.Status != "" && aBackup.Status != "running" {
+			continue
+		}
+		age := taweretSettings.now().Sub(aBackup.Time)
+		oldestAge = max(oldestAge, age)
+		if taweretSettings.stuckBackupThreshold > 0 && age > taweretSettings.stuckBackupThreshold {
+			slog.Warn("backup stuck in its state, its Kanister job may hang", "backup_config", backupConfig.Name, "backup_name", aBackup.Name, "state", aBackup.Status, "age", age.Round(time.Second))
+			stuck = 1
+		}
+	}
+	return oldestAge, stuck
+}
+
+// returns a random duration in [0, maximum), or 0 if maximum is not positive
+func randomDuration(maximum time.Duration) time.Duration {
+	if maximum <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(maximum)))
+}
+
+// sleeps for duration, returns false if ctx is cancelled before the duration has passed
+func sleepContext(ctx context.Context, duration time.Duration) bool {
+	if duration <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
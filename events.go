@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/swissdatasciencecenter/taweret/internal/retention"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// creates the recorder of the Kubernetes events about deletions, the broadcaster must be shut down on exit to flush pending events
+func newEventRecorder(clientSet kubernetes.Interface) (record.EventRecorder, record.EventBroadcaster) {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientSet.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "taweret"}), broadcaster
+}
+
+// records a Kubernetes event about a backup on its backup actionset, nothing is recorded if events are disabled
+func recordBackupEvent(taweretSettings taweretsettings, gvr schema.GroupVersionResource, backupConfig backupconfig, aBackup retention.Backup, eventType, reason, messageFmt string, args ...interface{}) {
+	if taweretSettings.eventRecorder == nil {
+		return
+	}
+	// the backup actionset may already be deleted, so the event refers to it by name rather than by the object
+	actionSet := &corev1.ObjectReference{
+		APIVersion: gvr.GroupVersion().String(),
+		Kind:       "ActionSet",
+		Namespace:  backupConfig.KanisterNamespace,
+		Name:       aBackup.Name,
+	}
+	taweretSettings.eventRecorder.Eventf(actionSet, eventType, reason, messageFmt, args...)
+}
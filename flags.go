@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// returns a flag set with a flag for each setting, named after its environment variable without the TAWERET_ prefix, e.g.
+// --eval-schedule for TAWERET_EVAL_SCHEDULE. The flags default to the values in taweretSettings, so that flags take precedence
+// over the environment variables which were read into taweretSettings before
+func settingsFlags(taweretSettings *taweretsettings, errorHandling flag.ErrorHandling) *flag.FlagSet {
+	flags := flag.NewFlagSet("taweret", errorHandling)
+	name := func(env string) string {
+		return strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(env, "TAWERET_"), "_", "-"))
+	}
+	usage := func(env, description string) string {
+		return description + ", overrides " + env
+	}
+	stringFlag := func(value *string, env, description string) {
+		flags.StringVar(value, name(env), *value, usage(env, description))
+	}
+	boolFlag := func(value *bool, env, description string) {
+		flags.BoolVar(value, name(env), *value, usage(env, description))
+	}
+	intFlag := func(value *int, env, description string) {
+		flags.IntVar(value, name(env), *value, usage(env, description))
+	}
+	floatFlag := func(value *float64, env, description string) {
+		flags.Float64Var(value, name(env), *value, usage(env, description))
+	}
+	durationFlag := func(value *time.Duration, env, description string) {
+		flags.DurationVar(value, name(env), *value, usage(env, description))
+	}
+	valueFlag := func(value flag.Value, env, description string) {
+		flags.Var(value, name(env), usage(env, description))
+	}
+
+	stringFlag(&taweretSettings.evalSchedule, "TAWERET_EVAL_SCHEDULE", "cron expression of the scheduled evaluations")
+	durationFlag(&taweretSettings.evalJitter, "TAWERET_EVAL_JITTER", "maximum random offset of the scheduled evaluations")
+	durationFlag(&taweretSettings.configJitter, "TAWERET_CONFIG_JITTER", "maximum random delay before each backup config evaluation")
+	stringFlag(&taweretSettings.metricsAddr, "TAWERET_METRICS_ADDR", "listen address of the metrics endpoint")
+	valueFlag((*listValue)(&taweretSettings.configNamespaces), "TAWERET_CONFIG_NAMESPACE", "comma-separated namespaces of the backup configs")
+	stringFlag(&taweretSettings.configSource, "TAWERET_CONFIG_SOURCE", "source of the backup configs, configmap or crd")
+	stringFlag(&taweretSettings.configKey, "TAWERET_CONFIG_KEY", "key of the backup configs in the ConfigMaps")
+	boolFlag(&taweretSettings.dryRun, "TAWERET_DRY_RUN", "only log the backups which would be deleted")
+	boolFlag(&taweretSettings.reportOnly, "TAWERET_REPORT_ONLY", "only report the backups in the metrics, never delete them")
+	intFlag(&taweretSettings.maxDeletionsPerRun, "TAWERET_MAX_DELETIONS_PER_RUN", "maximum amount of backups deleted per backup config and evaluation, 0 for no limit")
+	intFlag(&taweretSettings.maxTotalBackups, "TAWERET_MAX_TOTAL_BACKUPS", "maximum amount of retained backups of all backup configs, 0 for no limit")
+	durationFlag(&taweretSettings.apiTimeout, "TAWERET_API_TIMEOUT", "timeout of a single Kubernetes API call")
+	intFlag(&taweretSettings.apiRetries, "TAWERET_API_RETRIES", "retries of a Kubernetes API call failing with a transient error")
+	durationFlag(&taweretSettings.apiRetryBackoff, "TAWERET_API_RETRY_BACKOFF", "backoff before the first retry of a Kubernetes API call")
+	stringFlag(&taweretSettings.logFormat, "TAWERET_LOG_FORMAT", "log format, text or json")
+	intFlag(&taweretSettings.maxConcurrency, "TAWERET_MAX_CONCURRENCY", "amount of backup configs evaluated concurrently")
+	durationFlag(&taweretSettings.deletionPollInterval, "TAWERET_DELETION_POLL_INTERVAL", "interval before the first poll of a deletion actionset")
+	durationFlag(&taweretSettings.deletionPollMaxInterval, "TAWERET_DELETION_POLL_MAX_INTERVAL", "maximum interval between polls of a deletion actionset")
+	durationFlag(&taweretSettings.deletionTimeout, "TAWERET_DELETION_TIMEOUT", "time after which a deletion actionset is no longer awaited")
+	durationFlag(&taweretSettings.backupDeletionTimeout, "TAWERET_BACKUP_DELETION_TIMEOUT", "time for which a deleted backup actionset is awaited to be gone, 0 to not wait")
+	intFlag(&taweretSettings.maxConcurrentDeletions, "TAWERET_MAX_CONCURRENT_DELETIONS", "maximum amount of deletions in flight across all backup configs, 0 for no limit")
+	intFlag(&taweretSettings.listPageSize, "TAWERET_LIST_PAGE_SIZE", "amount of actionsets listed per API call, 0 for no pagination")
+	stringFlag(&taweretSettings.webhookURL, "TAWERET_WEBHOOK_URL", "URL to which deletions are posted")
+	stringFlag(&taweretSettings.webhookFormat, "TAWERET_WEBHOOK_FORMAT", "format of the webhook notifications, json or slack")
+	durationFlag(&taweretSettings.shutdownGracePeriod, "TAWERET_SHUTDOWN_GRACE_PERIOD", "time for which a running evaluation is awaited on shutdown")
+	boolFlag(&taweretSettings.watchConfigs, "TAWERET_WATCH_CONFIGS", "evaluate the backup configs when they change")
+	durationFlag(&taweretSettings.watchDebounce, "TAWERET_WATCH_DEBOUNCE", "time for which backup config changes settle before an evaluation")
+	boolFlag(&taweretSettings.requirePermissions, "TAWERET_REQUIRE_PERMISSIONS", "exit at startup if permissions are missing")
+	stringFlag(&taweretSettings.actionSetGVR.Group, "TAWERET_ACTIONSET_GROUP", "API group of the actionsets")
+	stringFlag(&taweretSettings.actionSetGVR.Version, "TAWERET_ACTIONSET_VERSION", "API version of the actionsets")
+	stringFlag(&taweretSettings.actionSetGVR.Resource, "TAWERET_ACTIONSET_RESOURCE", "resource of the actionsets")
+	durationFlag(&taweretSettings.deletionActionSetRetention, "TAWERET_DELETION_ACTIONSET_RETENTION", "time for which completed deletion actionsets are kept")
+	durationFlag(&taweretSettings.retentionGrace, "TAWERET_RETENTION_GRACE", "time by which the retention period is extended, it does not apply to the backup count")
+	durationFlag(&taweretSettings.stuckBackupThreshold, "TAWERET_STUCK_BACKUP_THRESHOLD", "time after which a pending or running backup is reported as stuck, 0 to not report them")
+	valueFlag((*retentionValue)(&taweretSettings.defaultRetention), "TAWERET_DEFAULT_RETENTION", "default retention section in YAML")
+	boolFlag(&taweretSettings.events, "TAWERET_EVENTS", "record Kubernetes events for deletions")
+	stringFlag(&taweretSettings.retainKey, "TAWERET_RETAIN_KEY", "annotation or label key with which backups are pinned")
+	valueFlag((*listValue)(&taweretSettings.completeStates), "TAWERET_COMPLETE_STATES", "comma-separated additional actionset states which count as complete")
+	valueFlag((*listValue)(&taweretSettings.failedStates), "TAWERET_FAILED_STATES", "comma-separated additional actionset states which count as failed")
+	boolFlag(&taweretSettings.softDelete, "TAWERET_SOFT_DELETE", "annotate deletable backups as expired instead of deleting them")
+	durationFlag(&taweretSettings.deletionDelay, "TAWERET_DELETION_DELAY", "time for which backups must have been deletable before they are deleted, 0 for no delay")
+	valueFlag((*listValue)(&taweretSettings.configAllowlist), "TAWERET_CONFIG_ALLOWLIST", "comma-separated globs of the names of the managed backup configs")
+	valueFlag((*listValue)(&taweretSettings.configDenylist), "TAWERET_CONFIG_DENYLIST", "comma-separated globs of the names of the backup configs which are not managed")
+	boolFlag(&taweretSettings.runOnce, "TAWERET_RUN_ONCE", "run a single evaluation and exit, non-zero if it failed")
+	durationFlag(&taweretSettings.runOnceLinger, "TAWERET_RUN_ONCE_LINGER", "time for which the metrics are served after a single evaluation")
+	stringFlag(&taweretSettings.pushgatewayURL, "TAWERET_PUSHGATEWAY_URL", "URL of the Pushgateway to which the metrics are pushed after each evaluation")
+	stringFlag(&taweretSettings.pushgatewayInstance, "TAWERET_PUSHGATEWAY_INSTANCE", "instance grouping key of the pushed metrics")
+	floatFlag(&taweretSettings.k8sQPS, "TAWERET_K8S_QPS", "client-side rate limit of the Kubernetes clients in requests per second")
+	intFlag(&taweretSettings.k8sBurst, "TAWERET_K8S_BURST", "burst of the client-side rate limit of the Kubernetes clients")
+	stringFlag(&taweretSettings.tlsCert, "TAWERET_TLS_CERT", "path of the certificate with which the metrics endpoint is served over TLS")
+	stringFlag(&taweretSettings.tlsKey, "TAWERET_TLS_KEY", "path of the key of the TLS certificate")
+	stringFlag(&taweretSettings.tlsClientCA, "TAWERET_TLS_CLIENT_CA", "path of the CA certificates with which client certificates are verified")
+	stringFlag(&taweretSettings.adminToken, "TAWERET_ADMIN_TOKEN", "bearer token required by the introspection and evaluation endpoints")
+	stringFlag(&taweretSettings.deletionOwner, "TAWERET_DELETION_OWNER", "owner of the deletion actionsets, config for the source of their backup config")
+	stringFlag(&taweretSettings.auditConfigMap, "TAWERET_AUDIT_CONFIGMAP", "namespace/name of the ConfigMap in which the deletions are recorded")
+	intFlag(&taweretSettings.auditSize, "TAWERET_AUDIT_SIZE", "amount of deletions kept in the audit ConfigMap")
+	boolFlag(&taweretSettings.leaderElection, "TAWERET_LEADER_ELECTION", "only evaluate while holding the leader election lease")
+	stringFlag(&taweretSettings.leaderElectionLease, "TAWERET_LEADER_ELECTION_LEASE", "namespace/name of the leader election lease")
+	boolFlag(&taweretSettings.checkSchedules, "TAWERET_CHECK_SCHEDULES", "warn about backup configs and backup schedules without a counterpart")
+
+	return flags
+}
+
+// logs the effective settings after the environment variables and flags have been applied, the webhook URL may hold a token
+// and is only logged as set or unset, as is the admin token
+func logSettings(taweretSettings taweretsettings) {
+	if taweretSettings.webhookURL != "" {
+		taweretSettings.webhookURL = "(set)"
+	}
+	if taweretSettings.adminToken != "" {
+		taweretSettings.adminToken = "(set)"
+	}
+	var settings []string
+	settingsFlags(&taweretSettings, flag.ContinueOnError).VisitAll(func(f *flag.Flag) {
+		settings = append(settings, f.Name+"="+f.Value.String())
+	})
+	log.Printf("effective configuration: %v", strings.Join(settings, " "))
+}
+
+// listValue is a flag holding a comma-separated list
+type listValue []string
+
+func (l *listValue) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(value string) error {
+	*l = splitList(value)
+	return nil
+}
+
+// retentionValue is a flag holding a retention section in YAML
+type retentionValue backupretention
+
+func (r *retentionValue) String() string {
+	// JSON is a subset of YAML, so the printed value can be passed to the flag again
+	printed, _ := json.Marshal((*backupretention)(r))
+	return string(printed)
+}
+
+func (r *retentionValue) Set(value string) error {
+	var parsed backupretention
+	if err := yaml.UnmarshalStrict([]byte(value), &parsed); err != nil {
+		return err
+	}
+	*r = retentionValue(parsed)
+	return nil
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/swissdatasciencecenter/taweret/internal/retention"
+	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// backupsummary is the JSON representation of a backup and its categorisation, it is returned by the backups endpoint
+// Held is set for deletable backups whose deletion is held back by the deletion delay
+type backupsummary struct {
+	Name           string    `json:"name"`
+	Time           time.Time `json:"time"`
+	Status         string    `json:"status"`
+	InUse          bool      `json:"inUse"`
+	Deletable      bool      `json:"deletable"`
+	Held           bool      `json:"held"`
+	BackupLocation string    `json:"backupLocation"`
+}
+
+// configbackups lists the backups of a backup config, it is returned by the backups endpoint
+type configbackups struct {
+	Config  string          `json:"config"`
+	Backups []backupsummary `json:"backups"`
+}
+
+// categorisation lists the backups of a backup config as categorised with a hypothetical retention, it is returned by the
+// categorise endpoint
+type categorisation struct {
+	Config    string          `json:"config"`
+	Retention backupretention `json:"retention"`
+	Backups   []backupsummary `json:"backups"`
+}
+
+// liveness handler, reports that the process is up and the HTTP server is responding
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readiness handler, reports ready once an evaluation has completed successfully and the Kubernetes API server is reachable
+// followers never evaluate, they are ready while the Kubernetes API server is reachable so that their metrics are scraped
+func readyzHandler(clientSet kubernetes.Interface, taweretStatus *taweretstatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := map[string]string{"status": "ok"}
+		status := http.StatusOK
+
+		lastSuccessfulEvaluation := taweretStatus.getLastSuccessfulEvaluation()
+		if taweretStatus.isFollower() {
+			response["role"] = "follower"
+			if _, err := clientSet.Discovery().ServerVersion(); err != nil {
+				response["status"] = fmt.Sprintf("kubernetes api server unreachable: %v", err)
+				status = http.StatusServiceUnavailable
+			}
+		} else if lastSuccessfulEvaluation.IsZero() {
+			response["status"] = "no successful evaluation yet"
+			status = http.StatusServiceUnavailable
+		} else {
+			response["lastSuccessfulEvaluation"] = lastSuccessfulEvaluation.UTC().Format(time.RFC3339)
+			if _, err := clientSet.Discovery().ServerVersion(); err != nil {
+				response["status"] = fmt.Sprintf("kubernetes api server unreachable: %v", err)
+				status = http.StatusServiceUnavailable
+			}
+		}
+
+		writeJSON(w, status, response)
+	}
+}
+
+// schedule handler, reports the evaluation schedule, the time of the next scheduled evaluation and of the last successful evaluation
+func scheduleHandler(taweretSettings taweretsettings, taweretStatus *taweretstatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := map[string]string{"schedule": taweretSettings.evalSchedule}
+		if nextEvaluation := taweretStatus.getNextEvaluation(); !nextEvaluation.IsZero() {
+			response["nextEvaluation"] = nextEvaluation.UTC().Format(time.RFC3339)
+		}
+		if lastSuccessfulEvaluation := taweretStatus.getLastSuccessfulEvaluation(); !lastSuccessfulEvaluation.IsZero() {
+			response["lastSuccessfulEvaluation"] = lastSuccessfulEvaluation.UTC().Format(time.RFC3339)
+		}
+		writeJSON(w, http.StatusOK, response)
+	}
+}
+
+// config handler, responds with the valid backup configs as Taweret parsed them, including the resolved retention values
+// the backup configs are listed with unregistered metrics, which leaves the metrics of the evaluations untouched
+func configHandler(dynamicClient dynamic.Interface, clientSet kubernetes.Interface, taweretSettings taweretsettings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"status": "method not allowed, use GET"})
+			return
+		}
+
+		backupConfigs, err := getBackupConfigs(r.Context(), dynamicClient, clientSet, newMetrics(), taweretSettings)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"status": fmt.Sprintf("error getting backup configs: %v", err)})
+			return
+		}
+		if backupConfigs == nil {
+			backupConfigs = []backupconfig{}
+		}
+		writeJSON(w, http.StatusOK, backupConfigs)
+	}
+}
+
+// on-demand evaluation handler, evaluates all backup configs or the one named by the config query parameter and responds with a summary
+func evaluateHandler(dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, clientSet kubernetes.Interface, taweretMetrics taweretmetrics, taweretSettings taweretsettings, taweretStatus *taweretstatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"status": "method not allowed, use POST"})
+			return
+		}
+
+		// only the leader evaluates, so that the deletions of several instances do not overlap
+		if taweretStatus.isFollower() {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not the leader, send the request to the leader"})
+			return
+		}
+		// reject the request instead of queueing it behind a running evaluation
+		if !taweretStatus.evaluationMutex.TryLock() {
+			writeJSON(w, http.StatusConflict, map[string]string{"status": "evaluation already running"})
+			return
+		}
+		defer taweretStatus.evaluationMutex.Unlock()
+
+		configName := r.URL.Query().Get("config")
+		log.Printf("on-demand evaluation requested, config: %q", configName)
+		// the evaluation is not bound to the request context, so that a disconnecting client does not abort running deletions
+		summary, err := runEvaluations(context.Background(), dynamicClient, gvr, clientSet, taweretMetrics, taweretSettings, taweretStatus, configName)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"status": fmt.Sprintf("error getting backup configs: %v", err)})
+			return
+		}
+		if configName != "" && summary.Configs == 0 {
+			writeJSON(w, http.StatusNotFound, map[string]string{"status": fmt.Sprintf("backup config %q not found", configName)})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, summary)
+	}
+}
+
+// backups handler, lists the backups of all backup configs or of the one named by the config query parameter with their categorisation
+// like the categorise handler it lists with unregistered metrics, which leaves the metrics of the evaluations untouched
+func backupsHandler(dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, clientSet kubernetes.Interface, taweretSettings taweretsettings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"status": "method not allowed, use GET"})
+			return
+		}
+
+		listingMetrics := newMetrics()
+		backupConfigs, err := getBackupConfigs(r.Context(), dynamicClient, clientSet, listingMetrics, taweretSettings)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"status": fmt.Sprintf("error getting backup configs: %v", err)})
+			return
+		}
+
+		configName := r.URL.Query().Get("config")
+		response := []configbackups{}
+		for _, backupConfig := range backupConfigs {
+			if configName != "" && backupConfig.Name != configName {
+				continue
+			}
+			backups, err := getBackups(r.Context(), dynamicClient, gvr, listingMetrics, taweretSettings, backupConfig)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"status": fmt.Sprintf("error getting backups of backup config %v: %v", backupConfig.Name, err)})
+				return
+			}
+
+			response = append(response, configbackups{Config: backupConfig.Name, Backups: summariseBackups(backups, backupConfig, taweretSettings)})
+		}
+		if configName != "" && len(response) == 0 {
+			writeJSON(w, http.StatusNotFound, map[string]string{"status": fmt.Sprintf("backup config %q not found", configName)})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, response)
+	}
+}
+
+// audit handler, returns the recorded deletions of the audit ConfigMap, oldest first
+func auditHandler(taweretSettings taweretsettings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if taweretSettings.auditLog == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"status": "deletion audit disabled, set TAWERET_AUDIT_CONFIGMAP"})
+			return
+		}
+		entries, err := taweretSettings.auditLog.entries(r.Context(), taweretSettings)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"status": fmt.Sprintf("error getting audit entries: %v", err)})
+			return
+		}
+		writeJSON(w, http.StatusOK, entries)
+	}
+}
+
+// categorise handler, categorises the backups of the backup config named by the config query parameter with the retention section
+// in the request body instead of its own retention, so that a retention can be tried out, nothing is deleted
+// the retention inherits the default retention like the retention of a backup config, an empty body keeps the retention of the backup config
+// the backup configs and backups are listed with unregistered metrics, so that the handler leaves the metrics of the evaluations untouched
+func categoriseHandler(dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, clientSet kubernetes.Interface, taweretSettings taweretsettings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"status": "method not allowed, use POST"})
+			return
+		}
+		configName := r.URL.Query().Get("config")
+		if configName == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"status": "missing config query parameter"})
+			return
+		}
+		// JSON is a subset of YAML, so the retention is parsed like a retention section of backup-config.yaml
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"status": fmt.Sprintf("error reading request body: %v", err)})
+			return
+		}
+		var overrideRetention *backupretention
+		if len(bytes.TrimSpace(body)) > 0 {
+			overrideRetention = &backupretention{}
+			if err := yaml.UnmarshalStrict(body, overrideRetention); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"status": fmt.Sprintf("error parsing retention: %v", err)})
+				return
+			}
+		}
+
+		listingMetrics := newMetrics()
+		backupConfigs, err := getBackupConfigs(r.Context(), dynamicClient, clientSet, listingMetrics, taweretSettings)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"status": fmt.Sprintf("error getting backup configs: %v", err)})
+			return
+		}
+		for _, backupConfig := range backupConfigs {
+			if backupConfig.Name != configName {
+				continue
+			}
+			if overrideRetention != nil {
+				backupConfig.Retention = overrideRetention.withDefaults(taweretSettings.defaultRetention)
+				if err := backupConfig.validate(); err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"status": fmt.Sprintf("invalid retention: %v", err)})
+					return
+				}
+			}
+			backups, err := getBackups(r.Context(), dynamicClient, gvr, listingMetrics, taweretSettings, backupConfig)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"status": fmt.Sprintf("error getting backups of backup config %v: %v", backupConfig.Name, err)})
+				return
+			}
+			writeJSON(w, http.StatusOK, categorisation{Config: backupConfig.Name, Retention: backupConfig.Retention, Backups: summariseBackups(backups, backupConfig, taweretSettings)})
+			return
+		}
+		writeJSON(w, http.StatusNotFound, map[string]string{"status": fmt.Sprintf("backup config %q not found", configName)})
+	}
+}
+
+// categorises the backups of a backup config and returns their summaries, sorted with the oldest backups first
+// backups which are neither retained nor deletable, e.g. running backups, are listed with both flags unset
+// a deletable backup which was not annotated by an evaluation yet is listed as held, like the evaluation would hold it back
+func summariseBackups(backups []retention.Backup, backupConfig backupconfig, taweretSettings taweretsettings) []backupsummary {
+	retainedBackups, deletableBackups, _ := categoriseBackups(backups, backupConfig, taweretSettings)
+	retained := make(map[string]bool, len(retainedBackups))
+	for _, retainedBackup := range retainedBackups {
+		retained[retainedBackup.Name] = true
+	}
+	deletable := make(map[string]bool, len(deletableBackups))
+	for _, deletableBackup := range deletableBackups {
+		deletable[deletableBackup.Name] = true
+	}
+
+	summaries := []backupsummary{}
+	for _, aBackup := range retention.Sort(backups) {
+		summaries = append(summaries, backupsummary{
+			Name:           aBackup.Name,
+			Time:           aBackup.Time.UTC(),
+			Status:         aBackup.Status,
+			InUse:          retained[aBackup.Name],
+			Deletable:      deletable[aBackup.Name],
+			Held:           deletable[aBackup.Name] && deletionHeld(aBackup, taweretSettings),
+			BackupLocation: aBackup.BackupLocation,
+		})
+	}
+	return summaries
+}
+
+// protects a handler with the admin token, requests must then carry it as a bearer token in the Authorization header
+// the handler is left open if no admin token is set
+func requireAdminToken(taweretSettings taweretsettings, handler http.HandlerFunc) http.HandlerFunc {
+	if taweretSettings.adminToken == "" {
+		return handler
+	}
+	expected := []byte("Bearer " + taweretSettings.adminToken)
+	return func(w http.ResponseWriter, r *http.Request) {
+		// the comparison takes constant time, so that the token cannot be guessed from the response times
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="taweret"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"status": "unauthorized, use the admin token as bearer token"})
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// writes body as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("error writing JSON response: %v\n", err)
+	}
+}
@@ -0,0 +1,438 @@
+// Package retention decides which backups of a backup config are retained and which are deletable
+// it holds the pure retention logic of Taweret and does not depend on Kubernetes
+package retention
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Backup is a backup actionset of a backup config
+type Backup struct {
+	Name, Schedule, Status, BackupLocation string
+	Time                                   time.Time
+	InUse                                  bool
+	// state of the deletion actionset of the backup, empty if there is none
+	DeletionState string
+	// artifact and key of the backup actionset under which BackupLocation was found, passed on to the deletion actionset
+	LocationArtifact, LocationKey string
+	// set if the backup actionset has artifacts, but none of them holds the backup location under the backup location paths
+	MissingLocation bool
+	// pinned backups are always retained and are left out of all retention rules
+	Pinned bool
+	// expired backups were marked for deletion by an external process, they are neither retained nor deletable
+	Expired bool
+	// time at which the backup was first found deletable while a deletion delay is set, zero if it has not been
+	DeletableSince time.Time
+	// size of the backup in bytes as recorded in its artifacts, 0 if it is unknown
+	Size int64
+}
+
+// Counts holds the amount of backups per state which are neither retained nor deletable, and whether the newest completed
+// backup is only retained by the safety rule
+type Counts struct {
+	Pending  int
+	Running  int
+	Failed   int
+	Skipped  int
+	Deleting int
+	// backups in a state which Taweret does not know, e.g. one introduced by a newer Kanister version
+	Unknown int
+	// set if the newest completed backup would have been deletable and was retained so that a backup remains
+	NewestProtected bool
+	// backups marked as expired which still exist
+	Expired int
+}
+
+// states of backup actionsets which Taweret knows
+var knownStatuses = map[string]bool{
+	"pending":       true,
+	"running":       true,
+	"complete":      true,
+	"failed":        true,
+	"attemptfailed": true,
+	"skipped":       true,
+	"deleting":      true,
+}
+
+// KnownStatus returns whether a backup in the state status is categorised, backups in other states are only counted as unknown
+// the state of a freshly created actionset is empty until Kanister has picked it up, it is counted as pending
+func KnownStatus(status string) bool {
+	return status == "" || knownStatuses[status]
+}
+
+// Policy defines which backups of a backup config are retained
+type Policy struct {
+	// the most recent Backups backups within the retention period are retained, all of them if Backups is 0
+	Backups int
+	// retention period
+	Minutes, Hours, Days, Months, Years int
+	// grandfather-father-son retention, keeps the newest backup of each of the most recent days, weeks and months
+	KeepDaily, KeepWeekly, KeepMonthly int
+	// if set, the weekly buckets only hold backups taken on KeepWeekday, and are kept for every week unless KeepWeekly is set
+	KeepWeekday *time.Weekday
+	// tiered retention, keeps the newest backup of each of the most recent buckets of each tier, e.g. 1 per day for a week
+	// and 1 per week for a month
+	Tiers []Tier
+	// the most recent MinBackups completed backups are always retained, regardless of the rules above
+	MinBackups int
+	// if set, the most recent KeepFailed failed backups within the retention period are retained, the other ones are deletable,
+	// and failed backups do not count towards Backups
+	KeepFailed int
+	// if set, failed backups within the retention period are all retained and do not count towards Backups, KeepFailed takes
+	// precedence if it is set as well
+	ExcludeFailed bool
+	// if set, the nearest completed backup taken before each failed backup within the retention period is retained, regardless
+	// of the backup count, so that the last good state before a failure can be inspected
+	KeepBeforeFailure bool
+	// Grace extends the retention period, a backup only falls out of it once it is older than the period by more than Grace
+	// it does not protect backups from the backup count, the oldest backups in excess of Backups are deletable regardless
+	Grace time.Duration
+	// time zone in which day, week and month boundaries are determined, UTC if nil
+	Location *time.Location
+	// the newest completed backup is always retained, unless AllowDeletingNewest is set
+	AllowDeletingNewest bool
+}
+
+// Tier keeps the newest completed backup of each of the most recent Keep buckets which are Interval long
+// the buckets are aligned to the Unix epoch in the time zone of the policy, so that they do not move between evaluations
+type Tier struct {
+	Interval time.Duration
+	Keep     int
+}
+
+// Validate checks that the policy has no negative values and retains at least some backups
+func (policy Policy) Validate() error {
+	retentionValues := map[string]int{
+		"backups":     policy.Backups,
+		"minutes":     policy.Minutes,
+		"hours":       policy.Hours,
+		"days":        policy.Days,
+		"months":      policy.Months,
+		"years":       policy.Years,
+		"keepDaily":   policy.KeepDaily,
+		"keepWeekly":  policy.KeepWeekly,
+		"keepMonthly": policy.KeepMonthly,
+		"minBackups":  policy.MinBackups,
+	}
+	// KeepFailed alone retains no completed backup, so it does not make a policy valid
+	if policy.KeepFailed < 0 {
+		return fmt.Errorf("retention keepFailed must not be negative, got %v", policy.KeepFailed)
+	}
+	retains := policy.KeepWeekday != nil
+	for _, tier := range policy.Tiers {
+		if tier.Interval < time.Second {
+			return fmt.Errorf("retention tier interval must be at least 1s, got %v", tier.Interval)
+		}
+		if tier.Keep < 0 {
+			return fmt.Errorf("retention tier keep must not be negative, got %v", tier.Keep)
+		}
+		if tier.Keep > 0 {
+			retains = true
+		}
+	}
+	for field, value := range retentionValues {
+		if value < 0 {
+			return fmt.Errorf("retention %v must not be negative, got %v", field, value)
+		}
+		if value > 0 {
+			retains = true
+		}
+	}
+	if !retains {
+		return fmt.Errorf("retention must set a positive backup count, retention period, grandfather-father-son bucket or tier")
+	}
+	return nil
+}
+
+// Cutoff returns the start of the retention period ending at now, moved back by the grace period
+// backups taken after the cutoff are within the retention period, which only limits them by the backup count
+func (policy Policy) Cutoff(now time.Time) time.Time {
+	cutoff := now.In(policy.location()).Add(-policy.Grace)
+	cutoff = cutoff.Add(time.Minute * time.Duration(policy.Minutes) * -1)
+	cutoff = cutoff.Add(time.Hour * time.Duration(policy.Hours) * -1)
+	return cutoff.AddDate(policy.Years*-1, policy.Months*-1, policy.Days*-1)
+}
+
+// returns the time zone of the policy, UTC if it is unset
+func (policy Policy) location() *time.Location {
+	if policy.Location == nil {
+		return time.UTC
+	}
+	return policy.Location
+}
+
+// returns whether grandfather-father-son or tiered retention is enabled, both of which select backups from buckets
+func (policy Policy) gfs() bool {
+	return policy.KeepDaily > 0 || policy.KeepWeekly > 0 || policy.KeepMonthly > 0 || policy.KeepWeekday != nil || len(policy.Tiers) > 0
+}
+
+// Categorise determines whether individual backups are required based on the retention period, the max backup count,
+// the grandfather-father-son buckets, the tiers and the minimum backup count at the time now
+// returns the retained backups and the deletable backups, both sorted with the oldest backups placed at the start of the slice
+func Categorise(backups []Backup, policy Policy, now time.Time) ([]Backup, []Backup, Counts) {
+	var retainedBackups, deletableBackups, expiredBackups, failedBackups, pinnedBackups, unpinnedBackups []Backup
+	var counts Counts
+
+	cutoff := policy.Cutoff(now)
+	gfs := policy.gfs()
+
+	// pinned backups neither count towards the max backup count nor towards the minimum backup count
+	for _, aBackup := range backups {
+		if aBackup.Pinned {
+			aBackup.InUse = true
+			pinnedBackups = append(pinnedBackups, aBackup)
+		} else if aBackup.Expired {
+			counts.Expired++
+		} else {
+			unpinnedBackups = append(unpinnedBackups, aBackup)
+		}
+	}
+
+	for _, aBackup := range unpinnedBackups {
+		if (policy.KeepFailed > 0 || policy.ExcludeFailed) && aBackup.Time.After(cutoff) && aBackup.Status == "failed" {
+			failedBackups = append(failedBackups, aBackup)
+		} else if aBackup.Time.After(cutoff) && (aBackup.Status == "complete" || aBackup.Status == "failed") {
+			aBackup.InUse = true
+			retainedBackups = append(retainedBackups, aBackup)
+		} else if gfs && aBackup.Status == "complete" {
+			// completed backups outside of the retention period are only candidates for the grandfather-father-son buckets
+			expiredBackups = append(expiredBackups, aBackup)
+		} else if aBackup.Status == "pending" || aBackup.Status == "" {
+			counts.Pending++
+		} else if aBackup.Status == "running" {
+			counts.Running++
+		} else if aBackup.Status == "failed" || aBackup.Status == "attemptfailed" {
+			counts.Failed++
+		} else if aBackup.Status == "skipped" {
+			counts.Skipped++
+		} else if aBackup.Status == "deleting" {
+			counts.Deleting++
+		} else if !KnownStatus(aBackup.Status) {
+			counts.Unknown++
+		}
+	}
+
+	// the oldest backups in excess of the max backup count are deletable, without a max backup count the retention period alone applies
+	retainedBackups = Sort(retainedBackups)
+	if excess := len(retainedBackups) - policy.Backups; policy.Backups > 0 && excess > 0 {
+		deletableBackups = append(deletableBackups, retainedBackups[:excess]...)
+		retainedBackups = retainedBackups[excess:]
+		for i := range deletableBackups {
+			deletableBackups[i].InUse = false
+		}
+	}
+
+	// the oldest failed backups in excess of KeepFailed are deletable
+	failedBackups = Sort(failedBackups)
+	if excess := len(failedBackups) - policy.KeepFailed; policy.KeepFailed > 0 && excess > 0 {
+		deletableBackups = append(deletableBackups, failedBackups[:excess]...)
+		failedBackups = failedBackups[excess:]
+	}
+	for i := range failedBackups {
+		failedBackups[i].InUse = true
+	}
+
+	if gfs {
+		retainedBackups, deletableBackups = selectGFSBackups(retainedBackups, append(deletableBackups, expiredBackups...), policy)
+	}
+	if policy.MinBackups > 0 {
+		retainedBackups, deletableBackups = retainMinBackups(retainedBackups, deletableBackups, unpinnedBackups, policy)
+	}
+	if policy.KeepBeforeFailure {
+		retainedBackups, deletableBackups = retainBackupsBeforeFailures(retainedBackups, deletableBackups, unpinnedBackups, cutoff)
+	}
+	if !policy.AllowDeletingNewest {
+		retainedBackups, deletableBackups, counts.NewestProtected = retainNewestBackup(retainedBackups, deletableBackups)
+	}
+
+	return Sort(append(append(retainedBackups, failedBackups...), pinnedBackups...)), Sort(deletableBackups), counts
+}
+
+// retains the most recent MinBackups completed backups, including backups which are deletable or older than the retention period
+func retainMinBackups(retainedBackups []Backup, deletableBackups []Backup, allBackups []Backup, policy Policy) ([]Backup, []Backup) {
+	var completedBackups []Backup
+	for _, aBackup := range allBackups {
+		if aBackup.Status == "complete" {
+			completedBackups = append(completedBackups, aBackup)
+		}
+	}
+	completedBackups = Sort(completedBackups)
+	if excess := len(completedBackups) - policy.MinBackups; excess > 0 {
+		completedBackups = completedBackups[excess:]
+	}
+
+	retained := make(map[string]bool, len(retainedBackups))
+	for _, aBackup := range retainedBackups {
+		retained[aBackup.Name] = true
+	}
+	protected := make(map[string]bool, len(completedBackups))
+	for _, aBackup := range completedBackups {
+		protected[aBackup.Name] = true
+		if !retained[aBackup.Name] {
+			aBackup.InUse = true
+			retainedBackups = append(retainedBackups, aBackup)
+		}
+	}
+
+	var remainingBackups []Backup
+	for _, aBackup := range deletableBackups {
+		if !protected[aBackup.Name] {
+			remainingBackups = append(remainingBackups, aBackup)
+		}
+	}
+	return retainedBackups, remainingBackups
+}
+
+// retains the nearest completed backup taken before each failed backup which is within the retention period and not deletable
+// completed backups which are not deletable are kept anyway, so only deletable ones have to be retained
+func retainBackupsBeforeFailures(retainedBackups []Backup, deletableBackups []Backup, allBackups []Backup, cutoff time.Time) ([]Backup, []Backup) {
+	deletable := make(map[string]bool, len(deletableBackups))
+	for _, aBackup := range deletableBackups {
+		deletable[aBackup.Name] = true
+	}
+
+	// walks the backups from the oldest to the newest, remembering the latest completed backup
+	protected := make(map[string]bool)
+	var lastCompleted *Backup
+	for _, aBackup := range Sort(append([]Backup{}, allBackups...)) {
+		if aBackup.Status == "complete" {
+			aBackup := aBackup
+			lastCompleted = &aBackup
+		} else if aBackup.Status == "failed" && aBackup.Time.After(cutoff) && !deletable[aBackup.Name] && lastCompleted != nil {
+			protected[lastCompleted.Name] = true
+		}
+	}
+	if len(protected) == 0 {
+		return retainedBackups, deletableBackups
+	}
+
+	var remainingBackups []Backup
+	for _, aBackup := range deletableBackups {
+		if protected[aBackup.Name] {
+			aBackup.InUse = true
+			retainedBackups = append(retainedBackups, aBackup)
+		} else {
+			remainingBackups = append(remainingBackups, aBackup)
+		}
+	}
+	return retainedBackups, remainingBackups
+}
+
+// retains the newest completed backup if it is deletable, so that the retention never leaves a backup config without a completed backup
+// returns whether the newest completed backup had to be retained
+func retainNewestBackup(retainedBackups []Backup, deletableBackups []Backup) ([]Backup, []Backup, bool) {
+	newest := -1
+	for i, aBackup := range deletableBackups {
+		if aBackup.Status == "complete" && (newest < 0 || aBackup.Time.After(deletableBackups[newest].Time)) {
+			newest = i
+		}
+	}
+	if newest < 0 {
+		return retainedBackups, deletableBackups, false
+	}
+	for _, aBackup := range retainedBackups {
+		if aBackup.Status == "complete" && !aBackup.Time.Before(deletableBackups[newest].Time) {
+			return retainedBackups, deletableBackups, false
+		}
+	}
+
+	newestBackup := deletableBackups[newest]
+	newestBackup.InUse = true
+	remainingBackups := append(append([]Backup{}, deletableBackups[:newest]...), deletableBackups[newest+1:]...)
+	return append(retainedBackups, newestBackup), remainingBackups, true
+}
+
+// retains the newest completed backup of each of the most recent daily, weekly and monthly buckets and of the buckets of the tiers
+// a backup which is the newest of several buckets is retained once and counts towards each of those buckets
+func selectGFSBackups(retainedBackups []Backup, candidateBackups []Backup, policy Policy) ([]Backup, []Backup) {
+	// all backups sorted with the newest backups placed at the start of the slice
+	allBackups := Sort(append(append([]Backup{}, retainedBackups...), candidateBackups...))
+	for i, j := 0, len(allBackups)-1; i < j; i, j = i+1, j-1 {
+		allBackups[i], allBackups[j] = allBackups[j], allBackups[i]
+	}
+
+	keepWeekly, weeklyMatches := policy.KeepWeekly, func(time.Time) bool { return true }
+	if policy.KeepWeekday != nil {
+		if keepWeekly == 0 {
+			keepWeekly = math.MaxInt
+		}
+		weeklyMatches = func(t time.Time) bool { return t.Weekday() == *policy.KeepWeekday }
+	}
+
+	type bucketRule struct {
+		keep      int
+		bucketKey func(time.Time) string
+		// backups which do not match are not selected for the buckets of the rule
+		matches func(time.Time) bool
+	}
+	bucketRules := []bucketRule{
+		{policy.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") }, nil},
+		{keepWeekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}, weeklyMatches},
+		{policy.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") }, nil},
+	}
+	for _, tier := range policy.Tiers {
+		interval := int64(tier.Interval / time.Second)
+		bucketRules = append(bucketRules, bucketRule{tier.Keep, func(t time.Time) string {
+			// the offset of the time zone aligns day-long buckets to local midnight
+			_, offset := t.Zone()
+			seconds := t.Unix() + int64(offset)
+			// flooring division, so that the buckets before the epoch are as long as the other ones
+			bucket := seconds / interval
+			if seconds%interval < 0 {
+				bucket--
+			}
+			return strconv.FormatInt(bucket, 10)
+		}, nil})
+	}
+
+	location := policy.location()
+	selected := make(map[string]bool)
+	for _, rule := range bucketRules {
+		kept := 0
+		lastBucket := ""
+		for _, aBackup := range allBackups {
+			if kept >= rule.keep {
+				break
+			}
+			localTime := aBackup.Time.In(location)
+			if aBackup.Status != "complete" || (rule.matches != nil && !rule.matches(localTime)) {
+				continue
+			}
+			if bucket := rule.bucketKey(localTime); bucket != lastBucket {
+				lastBucket = bucket
+				selected[aBackup.Name] = true
+				kept++
+			}
+		}
+	}
+
+	var deletableBackups []Backup
+	for _, aBackup := range candidateBackups {
+		if selected[aBackup.Name] {
+			aBackup.InUse = true
+			retainedBackups = append(retainedBackups, aBackup)
+		} else {
+			deletableBackups = append(deletableBackups, aBackup)
+		}
+	}
+	return retainedBackups, deletableBackups
+}
+
+// Sort sorts the backups in place with the oldest backups placed at the start of the slice and returns the slice
+// backups with identical times are sorted by name, so that the same backups are deleted in every evaluation
+func Sort(backups []Backup) []Backup {
+	sort.SliceStable(backups, func(q, p int) bool {
+		if backups[q].Time.Equal(backups[p].Time) {
+			return backups[q].Name < backups[p].Name
+		}
+		return backups[q].Time.Before(backups[p].Time)
+	})
+	return backups
+}
@@ -0,0 +1,465 @@
+package retention
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	// the time zone tests must not depend on the time zone database of the host
+	_ "time/tzdata"
+)
+
+// returns the names of the backups, in order
+func names(backups []Backup) []string {
+	var backupNames []string
+	for _, aBackup := range backups {
+		backupNames = append(backupNames, aBackup.Name)
+	}
+	return backupNames
+}
+
+func TestCategorise(t *testing.T) {
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	newBackup := func(name, status string, age time.Duration) Backup {
+		return Backup{Name: name, Schedule: "daily", Status: status, Time: now.Add(-age)}
+	}
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name      string
+		policy    Policy
+		backups   []Backup
+		retained  []string
+		deletable []string
+		counts    Counts
+	}{
+		{
+			name:     "within retention period",
+			policy:   Policy{Backups: 10, Days: 7},
+			backups:  []Backup{newBackup("backup-a", "complete", 2*day), newBackup("backup-b", "complete", day)},
+			retained: []string{"backup-a", "backup-b"},
+		},
+		{
+			name:   "excess backups",
+			policy: Policy{Backups: 2, Days: 7},
+			backups: []Backup{
+				newBackup("backup-d", "complete", day),
+				newBackup("backup-a", "complete", 4*day),
+				newBackup("backup-c", "complete", 2*day),
+				newBackup("backup-b", "complete", 3*day),
+			},
+			retained:  []string{"backup-c", "backup-d"},
+			deletable: []string{"backup-a", "backup-b"},
+		},
+		{
+			name:   "retention period without backup count",
+			policy: Policy{Days: 7},
+			backups: []Backup{
+				newBackup("backup-a", "complete", 10*day),
+				newBackup("backup-b", "complete", 2*day),
+				newBackup("backup-c", "complete", day),
+				newBackup("backup-d", "failed", day),
+			},
+			// without a backup count, every backup within the retention period is retained
+			retained: []string{"backup-b", "backup-c", "backup-d"},
+		},
+		{
+			name:    "outside retention period",
+			policy:  Policy{Backups: 5, Days: 1},
+			backups: []Backup{newBackup("backup-a", "complete", 3*day)},
+		},
+		{
+			name:     "failed backups within retention period",
+			policy:   Policy{Backups: 5, Days: 1},
+			backups:  []Backup{newBackup("backup-a", "failed", time.Hour), newBackup("backup-b", "failed", 2*day)},
+			retained: []string{"backup-a"},
+			counts:   Counts{Failed: 1},
+		},
+		{
+			name:   "hours and minutes",
+			policy: Policy{Backups: 5, Hours: 1, Minutes: 30},
+			backups: []Backup{
+				newBackup("backup-a", "complete", 100*time.Minute),
+				newBackup("backup-b", "complete", 80*time.Minute),
+			},
+			retained: []string{"backup-b"},
+		},
+		{
+			name:    "grace period",
+			policy:  Policy{Backups: 5, Hours: 1, Grace: 5 * time.Minute},
+			backups: []Backup{newBackup("backup-a", "complete", 70*time.Minute), newBackup("backup-b", "complete", 62*time.Minute)},
+			// backup-b is past the retention period, but still within the grace period
+			retained: []string{"backup-b"},
+		},
+		{
+			name:   "grace period with excess backups",
+			policy: Policy{Backups: 1, Hours: 1, Grace: 5 * time.Minute},
+			backups: []Backup{
+				newBackup("backup-a", "complete", 62*time.Minute),
+				newBackup("backup-b", "complete", 30*time.Minute),
+			},
+			// the grace period does not protect backup-a from the backup count
+			retained:  []string{"backup-b"},
+			deletable: []string{"backup-a"},
+		},
+		{
+			name:   "months and years",
+			policy: Policy{Backups: 5, Months: 1, Years: 1},
+			backups: []Backup{
+				newBackup("backup-a", "complete", 400*day),
+				newBackup("backup-b", "complete", 390*day),
+				newBackup("backup-c", "complete", 20*day),
+			},
+			retained: []string{"backup-b", "backup-c"},
+		},
+		{
+			name:   "keep failed backups",
+			policy: Policy{Backups: 2, Days: 7, KeepFailed: 1},
+			backups: []Backup{
+				newBackup("backup-a", "failed", 4*day),
+				newBackup("backup-b", "complete", 3*day),
+				newBackup("backup-c", "failed", 2*day),
+				newBackup("backup-d", "complete", day),
+				newBackup("backup-e", "failed", 10*day),
+			},
+			retained:  []string{"backup-b", "backup-c", "backup-d"},
+			deletable: []string{"backup-a"},
+			counts:    Counts{Failed: 1},
+		},
+		{
+			name:   "failed backups excluded from the backup count",
+			policy: Policy{Backups: 2, Days: 7, ExcludeFailed: true},
+			backups: []Backup{
+				newBackup("backup-a", "complete", 4*day),
+				newBackup("backup-b", "complete", 3*day),
+				newBackup("backup-c", "failed", 2*day),
+				newBackup("backup-d", "failed", day),
+				newBackup("backup-e", "failed", 10*day),
+			},
+			retained: []string{"backup-a", "backup-b", "backup-c", "backup-d"},
+			counts:   Counts{Failed: 1},
+		},
+		{
+			name:   "keep the completed backup before each failure",
+			policy: Policy{Backups: 2, Days: 7, KeepFailed: 2, KeepBeforeFailure: true},
+			backups: []Backup{
+				newBackup("backup-a", "complete", 6*day),
+				newBackup("backup-b", "complete", 5*day),
+				newBackup("backup-c", "failed", 4*day+time.Hour),
+				newBackup("backup-d", "failed", 4*day),
+				newBackup("backup-e", "complete", 3*day),
+				newBackup("backup-f", "complete", 2*day),
+				newBackup("backup-g", "complete", day),
+			},
+			retained:  []string{"backup-b", "backup-c", "backup-d", "backup-f", "backup-g"},
+			deletable: []string{"backup-a", "backup-e"},
+		},
+		{
+			name:   "pinned backups",
+			policy: Policy{Backups: 2, Days: 7},
+			backups: []Backup{
+				{Name: "backup-e", Schedule: "daily", Status: "complete", Time: now.Add(-30 * day), Pinned: true},
+				{Name: "backup-a", Schedule: "daily", Status: "complete", Time: now.Add(-4 * day), Pinned: true},
+				newBackup("backup-b", "complete", 3*day),
+				newBackup("backup-c", "complete", 2*day),
+				newBackup("backup-d", "complete", day),
+			},
+			retained:  []string{"backup-e", "backup-a", "backup-c", "backup-d"},
+			deletable: []string{"backup-b"},
+		},
+		{
+			name:   "expired backups",
+			policy: Policy{Backups: 1, Days: 7},
+			backups: []Backup{
+				{Name: "backup-a", Schedule: "daily", Status: "complete", Time: now.Add(-3 * day), Expired: true},
+				newBackup("backup-b", "complete", 2*day),
+				newBackup("backup-c", "complete", day),
+			},
+			retained:  []string{"backup-c"},
+			deletable: []string{"backup-b"},
+			counts:    Counts{Expired: 1},
+		},
+		{
+			name:   "backup states",
+			policy: Policy{Backups: 5, Days: 7},
+			backups: []Backup{
+				newBackup("backup-a", "pending", time.Hour),
+				newBackup("backup-b", "running", time.Hour),
+				newBackup("backup-c", "attemptfailed", time.Hour),
+				newBackup("backup-d", "skipped", time.Hour),
+				newBackup("backup-e", "deleting", time.Hour),
+				newBackup("backup-f", "running", time.Hour),
+				newBackup("backup-g", "", time.Hour),
+				newBackup("backup-h", "cancelled", time.Hour),
+			},
+			counts: Counts{Pending: 2, Running: 2, Failed: 1, Skipped: 1, Deleting: 1, Unknown: 1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			retained, deletable, counts := Categorise(test.backups, test.policy, now)
+			if !reflect.DeepEqual(names(retained), test.retained) {
+				t.Errorf("expected retained backups %v, got %v", test.retained, names(retained))
+			}
+			if !reflect.DeepEqual(names(deletable), test.deletable) {
+				t.Errorf("expected deletable backups %v, got %v", test.deletable, names(deletable))
+			}
+			if counts != test.counts {
+				t.Errorf("expected counts %+v, got %+v", test.counts, counts)
+			}
+			for _, aBackup := range retained {
+				if !aBackup.InUse {
+					t.Errorf("expected retained backup %v to be in use", aBackup.Name)
+				}
+			}
+			for _, aBackup := range deletable {
+				if aBackup.InUse {
+					t.Errorf("expected deletable backup %v not to be in use", aBackup.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestCategoriseGFS(t *testing.T) {
+	now := time.Now().UTC()
+	yesterdayNoon := time.Date(now.Year(), now.Month(), now.Day()-1, 12, 0, 0, 0, time.UTC)
+	var backups []Backup
+	// two completed backups per day for the 10 days up to yesterday
+	for day := 0; day < 10; day++ {
+		for hour := 0; hour < 2; hour++ {
+			backups = append(backups, Backup{
+				Name:     fmt.Sprintf("backup-%02d-%d", day, hour),
+				Schedule: "daily",
+				Status:   "complete",
+				Time:     yesterdayNoon.AddDate(0, 0, -day).Add(time.Duration(-hour) * time.Minute),
+			})
+		}
+	}
+
+	policy := Policy{KeepDaily: 3}
+	retained, deletable, _ := Categorise(backups, policy, now)
+	if len(retained) != 3 {
+		t.Fatalf("expected 3 retained backups, got %v", len(retained))
+	}
+	if len(deletable) != len(backups)-3 {
+		t.Fatalf("expected %v deletable backups, got %v", len(backups)-3, len(deletable))
+	}
+	for _, aBackup := range retained {
+		if !aBackup.InUse || !strings.HasSuffix(aBackup.Name, "-0") {
+			t.Fatalf("expected the newest backup of each day to be retained, got %v", aBackup.Name)
+		}
+	}
+
+	// a backup retained by the daily bucket also counts towards the weekly bucket
+	policy.KeepWeekly = 1
+	retained, _, _ = Categorise(backups, policy, now)
+	if len(retained) != 3 {
+		t.Fatalf("expected 3 retained backups, got %v", len(retained))
+	}
+}
+
+func TestCategoriseKeepWeekday(t *testing.T) {
+	// Sunday, 31 March 2024
+	now := time.Date(2024, time.March, 31, 12, 0, 0, 0, time.UTC)
+	var backups []Backup
+	// two completed backups per day for the 28 days up to yesterday
+	for day := 1; day <= 28; day++ {
+		for hour := 0; hour < 2; hour++ {
+			backups = append(backups, Backup{
+				Name:     fmt.Sprintf("backup-%02d-%d", day, hour),
+				Schedule: "daily",
+				Status:   "complete",
+				Time:     now.AddDate(0, 0, -day).Add(time.Duration(-hour) * time.Hour),
+			})
+		}
+	}
+
+	sunday := time.Sunday
+	tests := []struct {
+		name       string
+		keepWeekly int
+		expected   []string
+	}{
+		{"every week", 0, []string{"backup-28-0", "backup-21-0", "backup-14-0", "backup-07-0"}},
+		{"most recent weeks", 2, []string{"backup-14-0", "backup-07-0"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the newest backup is not taken on a Sunday
+			retained, _, _ := Categorise(backups, Policy{KeepWeekly: test.keepWeekly, KeepWeekday: &sunday, AllowDeletingNewest: true}, now)
+			var names []string
+			for _, aBackup := range retained {
+				names = append(names, aBackup.Name)
+			}
+			if fmt.Sprint(names) != fmt.Sprint(test.expected) {
+				t.Fatalf("expected the newest Sunday backups %v to be retained, got %v", test.expected, names)
+			}
+		})
+	}
+}
+
+func TestCategoriseNewestBackup(t *testing.T) {
+	now := time.Now()
+	backups := []Backup{
+		{Name: "backup-a", Status: "complete", Time: now.Add(-3 * 24 * time.Hour)},
+		{Name: "backup-b", Status: "complete", Time: now.Add(-2 * 24 * time.Hour)},
+		{Name: "backup-c", Status: "failed", Time: now.Add(-time.Hour)},
+	}
+	// the completed backups within the retention period are in excess of the max backup count, which the failed backup fills
+	retained, deletable, counts := Categorise(backups, Policy{Backups: 1, Days: 7}, now)
+	if !reflect.DeepEqual(names(retained), []string{"backup-b", "backup-c"}) || !reflect.DeepEqual(names(deletable), []string{"backup-a"}) || !counts.NewestProtected {
+		t.Fatalf("expected the newest completed backup to be protected, got retained %v, deletable %v, counts %+v", names(retained), names(deletable), counts)
+	}
+
+	retained, deletable, counts = Categorise(backups, Policy{Backups: 1, Days: 7, AllowDeletingNewest: true}, now)
+	if len(deletable) != 2 || counts.NewestProtected {
+		t.Fatalf("expected both completed backups to be deletable, got retained %v, deletable %v, counts %+v", names(retained), names(deletable), counts)
+	}
+
+	// the newest completed backup is already retained
+	_, _, counts = Categorise(backups, Policy{Backups: 2, Days: 7}, now)
+	if counts.NewestProtected {
+		t.Fatalf("expected the safety rule not to activate if the newest completed backup is retained")
+	}
+}
+
+func TestCategoriseMinBackups(t *testing.T) {
+	now := time.Now().UTC()
+	var backups []Backup
+	for i := 0; i < 5; i++ {
+		backups = append(backups, Backup{Name: fmt.Sprintf("backup-%d", i), Schedule: "daily", Status: "complete", Time: now.Add(time.Duration(-i) * time.Hour)})
+	}
+	// an old backup outside of the retention period
+	backups = append(backups, Backup{Name: "backup-old", Schedule: "daily", Status: "complete", Time: now.AddDate(0, 0, -30)})
+
+	policy := Policy{Backups: 1, Days: 7, MinBackups: 3}
+	retained, deletable, _ := Categorise(backups, policy, now)
+	if len(retained) != 3 || len(deletable) != 2 {
+		t.Fatalf("expected 3 retained and 2 deletable backups, got %v and %v", len(retained), len(deletable))
+	}
+	for _, aBackup := range deletable {
+		if aBackup.Name != "backup-3" && aBackup.Name != "backup-4" {
+			t.Fatalf("expected only the oldest backups within the retention period to be deletable, got %v", aBackup.Name)
+		}
+	}
+
+	// minBackups overrides a retention period which has expired every backup
+	policy.Days = 0
+	policy.Minutes = 1
+	policy.KeepDaily = 1
+	backups[0].Time = now.Add(-2 * time.Minute)
+	retained, _, _ = Categorise(backups, policy, now)
+	if len(retained) != 3 {
+		t.Fatalf("expected 3 retained backups, got %v", len(retained))
+	}
+}
+
+func TestCategoriseTimezone(t *testing.T) {
+	backupTime := func(value string) time.Time {
+		parsed, _ := time.Parse(time.RFC3339, value)
+		return parsed
+	}
+	// in Europe/Zurich (UTC+1 in January), backup-b and backup-c are taken on the same day
+	backups := []Backup{
+		{Name: "backup-a", Schedule: "daily", Status: "complete", Time: backupTime("2022-01-01T22:30:00Z")},
+		{Name: "backup-b", Schedule: "daily", Status: "complete", Time: backupTime("2022-01-01T23:30:00Z")},
+		{Name: "backup-c", Schedule: "daily", Status: "complete", Time: backupTime("2022-01-02T00:30:00Z")},
+	}
+	zurich, err := time.LoadLocation("Europe/Zurich")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		location *time.Location
+		expected []string
+	}{
+		{name: "unset", location: nil, expected: []string{"backup-b", "backup-c"}},
+		{name: "UTC", location: time.UTC, expected: []string{"backup-b", "backup-c"}},
+		{name: "Europe/Zurich", location: zurich, expected: []string{"backup-a", "backup-c"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			retained, _, _ := Categorise(append([]Backup{}, backups...), Policy{KeepDaily: 2, Location: test.location}, time.Now())
+			if !reflect.DeepEqual(names(retained), test.expected) {
+				t.Fatalf("expected retained backups %v, got %v", test.expected, names(retained))
+			}
+		})
+	}
+}
+
+func TestCategoriseTiers(t *testing.T) {
+	// the week-long buckets are aligned to the Unix epoch, a Thursday, so the most recent one starts on Thursday January 25
+	now := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	backups := []Backup{
+		{Name: "backup-a", Status: "complete", Time: time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC)},
+		{Name: "backup-b", Status: "complete", Time: time.Date(2024, 1, 31, 6, 0, 0, 0, time.UTC)},
+		{Name: "backup-c", Status: "complete", Time: time.Date(2024, 1, 30, 10, 0, 0, 0, time.UTC)},
+		{Name: "backup-d", Status: "complete", Time: time.Date(2024, 1, 26, 10, 0, 0, 0, time.UTC)},
+		{Name: "backup-e", Status: "complete", Time: time.Date(2024, 1, 24, 10, 0, 0, 0, time.UTC)},
+		{Name: "backup-f", Status: "complete", Time: time.Date(2024, 1, 20, 10, 0, 0, 0, time.UTC)},
+		{Name: "backup-g", Status: "complete", Time: time.Date(2024, 1, 12, 10, 0, 0, 0, time.UTC)},
+	}
+	policy := Policy{Tiers: []Tier{{Interval: 24 * time.Hour, Keep: 2}, {Interval: 7 * 24 * time.Hour, Keep: 2}}}
+
+	retained, deletable, _ := Categorise(backups, policy, now)
+	if expected := []string{"backup-e", "backup-c", "backup-a"}; !reflect.DeepEqual(names(retained), expected) {
+		t.Errorf("expected retained backups %v, got %v", expected, names(retained))
+	}
+	if expected := []string{"backup-g", "backup-f", "backup-d", "backup-b"}; !reflect.DeepEqual(names(deletable), expected) {
+		t.Errorf("expected deletable backups %v, got %v", expected, names(deletable))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		valid  bool
+	}{
+		{name: "empty retention", policy: Policy{}, valid: false},
+		{name: "backup count", policy: Policy{Backups: 7}, valid: true},
+		{name: "retention period", policy: Policy{Days: 7}, valid: true},
+		{name: "gfs bucket", policy: Policy{KeepMonthly: 12}, valid: true},
+		{name: "min backups", policy: Policy{MinBackups: 3}, valid: true},
+		{name: "negative value", policy: Policy{Backups: 7, Hours: -1}, valid: false},
+		{name: "only keep failed", policy: Policy{KeepFailed: 3}, valid: false},
+		{name: "tier", policy: Policy{Tiers: []Tier{{Interval: time.Hour, Keep: 24}}}, valid: true},
+		{name: "tier without interval", policy: Policy{Tiers: []Tier{{Keep: 24}}}, valid: false},
+		{name: "negative keep failed", policy: Policy{Backups: 7, KeepFailed: -1}, valid: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.policy.Validate(); (err == nil) != test.valid {
+				t.Fatalf("expected valid %v, got error %v", test.valid, err)
+			}
+		})
+	}
+}
+
+func TestSort(t *testing.T) {
+	now := time.Now()
+	backups := []Backup{
+		{Name: "backup-b", Time: now.Add(-time.Hour)},
+		{Name: "backup-c", Time: now},
+		{Name: "backup-a", Time: now.Add(-2 * time.Hour)},
+	}
+	if sorted := names(Sort(backups)); !reflect.DeepEqual(sorted, []string{"backup-a", "backup-b", "backup-c"}) {
+		t.Fatalf("expected the oldest backups first, got %v", sorted)
+	}
+
+	// backups created in a burst share their creation timestamp
+	burst := now.Truncate(time.Second)
+	backups = []Backup{
+		{Name: "backup-c", Time: burst},
+		{Name: "backup-a", Time: burst},
+		{Name: "backup-d", Time: burst.Add(-time.Second)},
+		{Name: "backup-b", Time: burst},
+	}
+	if sorted := names(Sort(backups)); !reflect.DeepEqual(sorted, []string{"backup-d", "backup-a", "backup-b", "backup-c"}) {
+		t.Fatalf("expected backups with identical times to be sorted by name, got %v", sorted)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"strings"
+	"time"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// durations of the leader election, the defaults of the Kubernetes controllers: a lease is held for 15s and renewed every 2s, a
+// leader which cannot renew it within 10s stops leading
+const leaseDuration time.Duration = 15 * time.Second
+const leaseRenewDeadline time.Duration = 10 * time.Second
+const leaseRetryPeriod time.Duration = 2 * time.Second
+
+// returns the namespace and name of the leader election lease, given as namespace/name or as name in the first config namespace
+func leaderElectionLease(taweretSettings taweretsettings) (string, string) {
+	namespace, name, ok := strings.Cut(taweretSettings.leaderElectionLease, "/")
+	if !ok {
+		return taweretSettings.configNamespaces[0], taweretSettings.leaderElectionLease
+	}
+	return namespace, name
+}
+
+// competes for the leader election lease until ctx is done, lead is called once this instance becomes the leader and starts the
+// evaluations, the other instances stay followers which only serve the metrics and introspection endpoints
+// a leader which loses the lease exits, so that its evaluations do not overlap with those of the new leader, the lease is released
+// once ctx is done
+func runLeaderElection(ctx context.Context, clientSet kubernetes.Interface, taweretMetrics taweretmetrics, taweretSettings taweretsettings, taweretStatus *taweretstatus, lead func()) {
+	namespace, name := leaderElectionLease(taweretSettings)
+	identity := hostname()
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  v1.ObjectMeta{Name: name, Namespace: namespace},
+		Client:     clientSet.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+	log.Printf("running leader election with lease %v/%v as %v", namespace, name, identity)
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   leaseRenewDeadline,
+		RetryPeriod:     leaseRetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				log.Printf("became the leader, starting evaluations")
+				taweretStatus.setFollower(false)
+				taweretMetrics.leader.Set(1)
+				lead()
+			},
+			OnStoppedLeading: func() {
+				taweretStatus.setFollower(true)
+				taweretMetrics.leader.Set(0)
+				if ctx.Err() == nil {
+					log.Fatalf("lost the leader election lease %v/%v, exiting so that no evaluation overlaps with the new leader", namespace, name)
+				}
+				log.Printf("released the leader election lease %v/%v", namespace, name)
+			},
+			OnNewLeader: func(newLeader string) {
+				if newLeader != identity {
+					slog.Info("following the leader", "leader", newLeader, "lease", namespace+"/"+name)
+				}
+			},
+		},
+	})
+}
@@ -1,75 +1,62 @@
-// Main is the only package for Taweret
+// Taweret defines retention periods for Kanister backups and deletes them once they expire
 package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"sort"
-	"strconv"
-	"strings"
-	"time"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"syscall"
+	// embed the time zone database, the container image does not ship one
+	_ "time/tzdata"
 
 	"github.com/go-co-op/gocron"
-	"github.com/kanisterio/kanister/pkg/apis/cr/v1alpha1"
-	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"gopkg.in/yaml.v2"
-	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/schema"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 )
 
-type backup struct {
-	name, schedule, status, backupLocation string
-	time                                   time.Time
-	inUse                                  bool
-}
-
-type backupconfig struct {
-	Name              string `yaml:"name"`
-	KanisterNamespace string `yaml:"kanisterNamespace"`
-	BlueprintName     string `yaml:"blueprintName"`
-	ProfileName       string `yaml:"profileName"`
-	Retention         struct {
-		Backups StringInt `yaml:"backups"`
-		Minutes StringInt `yaml:"minutes"`
-		Hours   StringInt `yaml:"hours"`
-		Days    StringInt `yaml:"days"`
-		Months  StringInt `yaml:"months"`
-		Years   StringInt `yaml:"years"`
-	}
-}
-
-// StringInt is a type for custom YAML unmarshalling
-type StringInt int
+// build information, set at build time with -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=..."
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
 
-type taweretmetrics struct {
-	backupCount  *prometheus.GaugeVec
-	oldestBackup *prometheus.GaugeVec
-	newestBackup *prometheus.GaugeVec
-}
+func main() {
+	taweretSettings := loadSettings()
+	setupLogging(taweretSettings)
+	log.Printf("starting Taweret version %v, commit %v, built %v with %v", version, commit, buildDate, runtime.Version())
+	logSettings(taweretSettings)
 
-type backupcounts struct {
-	pending  int
-	running  int
-	failed   int
-	skipped  int
-	deleting int
-}
+	if taweretSettings.dryRun {
+		log.Printf("dry run enabled: backups will not be deleted")
+	}
+	if taweretSettings.reportOnly {
+		log.Printf("report only mode enabled: backups are only reported in the metrics and never deleted")
+	}
 
-func main() {
-	// creates the in-cluster config
-	config, err := rest.InClusterConfig()
+	// creates the in-cluster config, or a config from a kubeconfig file when running outside of a cluster
+	config, err := buildConfig()
 	if err != nil {
 		panic(err.Error())
 	}
+	// client-go waits before requests exceeding the rate limit and logs the waits as client-side throttling
+	config.QPS = float32(taweretSettings.k8sQPS)
+	config.Burst = taweretSettings.k8sBurst
+	log.Printf("Kubernetes client rate limit: %v requests per second, burst: %v", config.QPS, config.Burst)
 
 	// initialise dynamicClient
 	dynamicClient, err := dynamic.NewForConfig(config)
@@ -83,575 +70,207 @@ func main() {
 		panic(err.Error())
 	}
 
-	// specify the crds which should be queried
-	gvr := schema.GroupVersionResource{
-		Group:    "cr.kanister.io",
-		Version:  "v1alpha1",
-		Resource: "actionsets",
+	// specify the crds which should be queried, a missing actionset resource would fail every evaluation
+	gvr := taweretSettings.actionSetGVR
+	if err := checkActionSetResource(clientSet, gvr); apierrors.IsNotFound(err) {
+		log.Fatalf("actionset resource %v not found, check TAWERET_ACTIONSET_GROUP, TAWERET_ACTIONSET_VERSION and TAWERET_ACTIONSET_RESOURCE: %v", gvr, err)
+	} else if err != nil {
+		slog.Warn("error checking the actionset resource", "resource", gvr, "error", err)
 	}
 
-	taweretMetrics := initialiseMetrics()
-
-	scheduleEvaluations(dynamicClient, gvr, clientSet, taweretMetrics)
-
-	http.Handle("/metrics", promhttp.Handler())
-	http.ListenAndServe(":2112", nil)
-}
-
-func scheduleEvaluations(dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, clientSet *kubernetes.Clientset, taweretMetrics taweretmetrics) {
-	// set evaluation schedule
-	const evalSchedule string = "*/10 * * * *"
-
-	// schedule backup evaluations
-	s := gocron.NewScheduler(time.UTC)
-	job, err := s.Cron(evalSchedule).Do(startEvaluation, dynamicClient, gvr, clientSet, taweretMetrics)
-	if err != nil {
-		log.Fatalf("error creating job: %v", err)
-	}
-	s.StartAsync()
-	log.Printf("first evaluation scheduled: %v, evaluation schedule: %v", job.NextRun(), evalSchedule)
-
-}
-
-func startEvaluation(dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, clientSet *kubernetes.Clientset, taweretMetrics taweretmetrics) {
-	log.Printf("starting backup config evaluations\n")
-
-	// get backupConfigs
-	backupConfigs := getBackupConfigs(clientSet, gvr)
-
-	// evaluate backupConfigs
-	for _, backupConfig := range backupConfigs {
-		evaluateBackups(dynamicClient, gvr, taweretMetrics, backupConfig)
-	}
-	log.Printf("backup config evaluations complete\n---\n")
-}
-
-func evaluateBackups(dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, taweretMetrics taweretmetrics, backupConfig backupconfig) {
-
-	log.Printf("%v: evaluating backups\n", backupConfig.Name)
-
-	backups := getBackups(dynamicClient, gvr, backupConfig)
-
-	categorisedBackups, backupCounts := categoriseBackups(backups, backupConfig)
-
-	// if there are excess daily backups, delete the oldest excess, then refetch and recategorise the backups
-	if len(categorisedBackups) > int(backupConfig.Retention.Backups) {
-		deleteOldestBackups(categorisedBackups, (len(categorisedBackups) - int(backupConfig.Retention.Backups)), dynamicClient, gvr, backupConfig)
-		backups = getBackups(dynamicClient, gvr, backupConfig)
-		categorisedBackups, backupCounts = categoriseBackups(backups, backupConfig)
-	} else {
-		log.Printf("%v: no backups deleted: current: %v limit: %v\n", backupConfig.Name, len(categorisedBackups), backupConfig.Retention.Backups)
+	// the broadcaster is shut down last, so that the events of a running evaluation are still sent
+	var broadcaster record.EventBroadcaster
+	if taweretSettings.events {
+		taweretSettings.eventRecorder, broadcaster = newEventRecorder(clientSet)
+		defer broadcaster.Shutdown()
 	}
 
-	taweretMetrics.setMetrics(categorisedBackups, backupConfig, backupCounts)
-
-	log.Printf("%v: backup evaluation complete\n", backupConfig.Name)
-}
-
-func getBackupConfigs(clientset *kubernetes.Clientset, gvr schema.GroupVersionResource) []backupconfig {
-	var backupConfigs []backupconfig
-	// get configmaps
-	configmaps, err := clientset.CoreV1().ConfigMaps("kanister").List(context.TODO(), v1.ListOptions{})
+	// the spans still buffered are exported on shutdown, after the evaluations have stopped
+	shutdownTracing, err := setupTracing(context.Background())
 	if err != nil {
-		log.Printf("error getting actionsets: %v\n", err)
-		os.Exit(1)
+		log.Fatalf("error setting up tracing: %v", err)
 	}
-
-	for _, configmap := range configmaps.Items {
-		if configmap.Data["backup-config.yaml"] != "" {
-			var backupConfig backupconfig
-
-			err = yaml.Unmarshal([]byte(configmap.Data["backup-config.yaml"]), &backupConfig)
-			if err != nil {
-				log.Printf("error unmarshalling backup-config.yaml: %v\n", err)
-				os.Exit(1)
-			}
-
-			backupConfigs = append(backupConfigs, backupConfig)
-
-			log.Printf("backup config:\n name: %v\n kanister namespace: %v\n blueprint name: %v\n profile name: %v\n retention:\n backups: %v\n years: %v months: %v days: %v hours %v minutes: %v", backupConfig.Name, backupConfig.KanisterNamespace, backupConfig.BlueprintName, backupConfig.ProfileName, backupConfig.Retention.Backups, backupConfig.Retention.Years, backupConfig.Retention.Months, backupConfig.Retention.Days, backupConfig.Retention.Hours, backupConfig.Retention.Minutes)
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			slog.Warn("error shutting down tracing", "error", err)
 		}
-	}
-	return backupConfigs
-}
-
-// queries Kubernetes for Actionsets, adds the actionsets with action name 'backup' to a slice of backup objects and returns the slice
-// func getBackups(dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, backupConfig backupconfig) []backup {
-// 	var backups []backup
-
-// 	log.Printf("%v: retrieving actionsets from Kubernetes", backupConfig.Name)
-
-// 	// get actionsets
-// 	actionsets, err := dynamicClient.Resource(gvr).Namespace(backupConfig.KanisterNamespace).List(context.Background(), v1.ListOptions{})
-// 	if err != nil {
-// 		log.Printf("%v: error getting actionsets: %v\n", backupConfig.Name, err)
-// 		os.Exit(1)
-// 	}
-
-// 	log.Printf("%v: filtering backup actionsets from Kubernetes", backupConfig.Name)
+	}()
 
-// 	// loop through actionsets
-// 	for _, actionset := range actionsets.Items {
-// 		actionSpec := actionset.Object["spec"].(map[string]interface{})["actions"].([]interface{})[0].(map[string]interface{})
-// 		actionMetadata := actionset.Object["metadata"].(map[string]interface{})
-
-// 		// skip ahead if the ActionSet is not a backup
-// 		if actionSpec["name"] != "backup" {
-// 			continue
-// 		}
-
-// 		// check for the existence of the keys, if they do not exist, return early. The if statements are split up to avoid runtime errors.
-// 		if _, ok := actionSpec["options"]; !ok {
-// 			continue
-// 		}
-// 		if _, ok := actionSpec["options"].(map[string]interface{})["backup-schedule"]; !ok {
-// 			continue
-// 		}
-// 		if _, ok := actionset.Object["status"].(map[string]interface{})["actions"].([]interface{})[0].(map[string]interface{})["artifacts"].(map[string]interface{})["cloudObject"].(map[string]interface{})["keyValue"].(map[string]interface{})["backupLocation"]; !ok {
-// 			continue
-// 		}
-
-// 		thisBackup := backup{
-// 			name:           fmt.Sprintf("%v", actionMetadata["name"]),
-// 			status:         fmt.Sprintf("%v", actionset.Object["status"].(map[string]interface{})["state"]),
-// 			schedule:       fmt.Sprintf("%v", actionSpec["options"].(map[string]interface{})["backup-schedule"]),
-// 			backupLocation: fmt.Sprintf("%v", actionset.Object["status"].(map[string]interface{})["actions"].([]interface{})[0].(map[string]interface{})["artifacts"].(map[string]interface{})["cloudObject"].(map[string]interface{})["keyValue"].(map[string]interface{})["backupLocation"]),
-// 		}
-// 		thisBackup.time, _ = time.Parse(time.RFC3339, fmt.Sprintf("%v", actionMetadata["creationTimestamp"]))
-// 		if thisBackup.schedule == backupConfig.Name {
-// 			backups = append(backups, thisBackup)
-// 		}
-// 	}
-// 	return backups
-// }
+	if taweretSettings.auditConfigMap != "" {
+		taweretSettings.auditLog = newAuditLog(clientSet, taweretSettings)
+		log.Printf("recording deletions in audit configmap %v/%v", taweretSettings.auditLog.namespace, taweretSettings.auditLog.name)
+	}
 
-// queries Kubernetes for Actionsets, adds the actionsets with action name 'backup' to a slice of backup objects and returns the slice
-func getBackups(dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, backupConfig backupconfig) []backup {
-	var backups []backup
+	taweretMetrics := initialiseMetrics()
+	taweretMetrics.buildInfo.WithLabelValues(version, commit, buildDate, runtime.Version()).Set(1)
+	taweretStatus := &taweretstatus{}
+	checkStartupPermissions(dynamicClient, gvr, clientSet, taweretMetrics, taweretSettings)
 
-	log.Printf("%v: retrieving actionsets from Kubernetes", backupConfig.Name)
+	// the pod is terminated with SIGTERM
+	signalCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 
-	// get actionsets
-	actionsets, err := dynamicClient.Resource(gvr).Namespace(backupConfig.KanisterNamespace).List(context.Background(), v1.ListOptions{})
-	if err != nil {
-		log.Printf("%v: error getting actionsets: %v\n", backupConfig.Name, err)
-		os.Exit(1)
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler(clientSet, taweretStatus))
+	// the introspection endpoints and the evaluation endpoint, which deletes backups, require the admin token if one is set
+	http.HandleFunc("/schedule", requireAdminToken(taweretSettings, scheduleHandler(taweretSettings, taweretStatus)))
+	http.HandleFunc("/config", requireAdminToken(taweretSettings, configHandler(dynamicClient, clientSet, taweretSettings)))
+	http.HandleFunc("/backups", requireAdminToken(taweretSettings, backupsHandler(dynamicClient, gvr, clientSet, taweretSettings)))
+	http.HandleFunc("/evaluate", requireAdminToken(taweretSettings, evaluateHandler(dynamicClient, gvr, clientSet, taweretMetrics, taweretSettings, taweretStatus)))
+	http.HandleFunc("/audit", requireAdminToken(taweretSettings, auditHandler(taweretSettings)))
+	http.HandleFunc("/debug/categorise", requireAdminToken(taweretSettings, categoriseHandler(dynamicClient, gvr, clientSet, taweretSettings)))
+	server := &http.Server{Addr: taweretSettings.metricsAddr}
+	if server.TLSConfig, err = serverTLSConfig(taweretSettings); err != nil {
+		log.Fatalf("error configuring TLS: %v", err)
 	}
-
-	log.Printf("%v: filtering backup actionsets from Kubernetes", backupConfig.Name)
-
-	// loop through actionsets
-	for _, actionset := range actionsets.Items {
-		actionSpec, ok := actionset.Object["spec"].(map[string]interface{})["actions"].([]interface{})[0].(map[string]interface{})
-		if !ok {
-			continue
+	go func() {
+		var err error
+		if taweretSettings.tlsCert != "" {
+			log.Printf("serving metrics over TLS on %v", taweretSettings.metricsAddr)
+			err = server.ListenAndServeTLS(taweretSettings.tlsCert, taweretSettings.tlsKey)
+		} else {
+			log.Printf("serving metrics on %v", taweretSettings.metricsAddr)
+			err = server.ListenAndServe()
 		}
-		actionMetadata, ok := actionset.Object["metadata"].(map[string]interface{})
-		if !ok {
-			continue
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("error serving metrics on %v: %v", taweretSettings.metricsAddr, err)
 		}
+	}()
 
-		// skip ahead if the ActionSet does not start with "backup"
-		if !strings.HasPrefix(actionSpec["name"].(string), "backup") {
-			continue
+	// in a CronJob, a single evaluation is run and its outcome is reported by the exit code
+	if taweretSettings.runOnce {
+		succeeded := runOnce(signalCtx, dynamicClient, gvr, clientSet, taweretMetrics, taweretSettings, taweretStatus)
+		if err := server.Shutdown(context.Background()); err != nil {
+			slog.Warn("error shutting down HTTP server", "error", err)
 		}
-
-		// check for the existence of the keys, if they do not exist, return early. The if statements are split up to avoid runtime errors.
-		options, ok := actionSpec["options"].(map[string]interface{})
-		if !ok {
-			continue
-		}
-		backupSchedule, ok := options["backup-schedule"].(string)
-		if !ok {
-			continue
-		}
-
-		var backupLocation string
-		if artifacts, ok := actionset.Object["status"].(map[string]interface{})["actions"].([]interface{})[0].(map[string]interface{})["artifacts"].(map[string]interface{}); ok {
-			if cloudObject, ok := artifacts["cloudObject"].(map[string]interface{}); ok {
-				backupLocation, _ = cloudObject["backupLocation"].(string)
-				if !ok {
-					backupLocation = ""
-				}
+		if !succeeded {
+			// os.Exit skips the deferred calls
+			if broadcaster != nil {
+				broadcaster.Shutdown()
 			}
+			_ = shutdownTracing(context.Background())
+			os.Exit(1)
 		}
+		return
+	}
 
-		thisBackup := backup{
-			name:     fmt.Sprintf("%v", actionMetadata["name"]),
-			status:   fmt.Sprintf("%v", actionset.Object["status"].(map[string]interface{})["state"]),
-			schedule: backupSchedule,
-			// backupLocation: backupLocation,
+	// with leader election, the evaluations are started once this instance becomes the leader, the lease is released after the shutdown
+	var schedulerMutex sync.Mutex
+	var scheduler *gocron.Scheduler
+	startEvaluations := func() {
+		schedulerMutex.Lock()
+		defer schedulerMutex.Unlock()
+		if signalCtx.Err() != nil {
+			return
 		}
-		if backupLocation != "" {
-			thisBackup.backupLocation = backupLocation
-		}
-		thisBackup.time, _ = time.Parse(time.RFC3339, fmt.Sprintf("%v", actionMetadata["creationTimestamp"]))
-		if thisBackup.schedule == backupConfig.Name {
-			log.Printf("Selected actionset: %v", thisBackup.name)
-			backups = append(backups, thisBackup)
+		scheduler = scheduleEvaluations(signalCtx, dynamicClient, gvr, clientSet, taweretMetrics, taweretSettings, taweretStatus)
+		if taweretSettings.watchConfigs {
+			changes := make(chan struct{}, 1)
+			watchBackupConfigs(signalCtx, dynamicClient, clientSet, taweretSettings, changes)
+			go debounceChanges(signalCtx, changes, taweretSettings.watchDebounce, func() {
+				startConfigChangeEvaluation(dynamicClient, gvr, clientSet, taweretMetrics, taweretSettings, taweretStatus)
+			})
 		}
 	}
-	return backups
-}
-
-// determine whether individual backups are required based on max retention dates and their category (daily, weekly, none)
-func categoriseBackups(uncategorisedBackups []backup, backupConfig backupconfig) ([]backup, backupcounts) {
-	var categorisedBackups []backup
-	backupCounts := backupcounts{
-		pending:  0,
-		running:  0,
-		failed:   0,
-		skipped:  0,
-		deleting: 0,
+	leaderElectionCtx, releaseLease := context.WithCancel(context.Background())
+	leaderElectionDone := make(chan struct{})
+	if taweretSettings.leaderElection {
+		taweretStatus.setFollower(true)
+		go func() {
+			runLeaderElection(leaderElectionCtx, clientSet, taweretMetrics, taweretSettings, taweretStatus, startEvaluations)
+			close(leaderElectionDone)
+		}()
+	} else {
+		taweretMetrics.leader.Set(1)
+		close(leaderElectionDone)
+		startEvaluations()
 	}
 
-	log.Printf("%v: categorising backups\n", backupConfig.Name)
-
-	maxBackupDateTime := time.Now()
-
-	maxBackupDateTime = maxBackupDateTime.Add(time.Minute * time.Duration(backupConfig.Retention.Minutes) * -1)
-	maxBackupDateTime = maxBackupDateTime.Add(time.Hour * time.Duration(backupConfig.Retention.Hours) * -1)
-	maxBackupDateTime = maxBackupDateTime.AddDate(int(backupConfig.Retention.Years)*-1, int(backupConfig.Retention.Months)*-1, int(backupConfig.Retention.Days)*-1)
+	// block until the pod is terminated, then shut down without interrupting a running deletion
+	<-signalCtx.Done()
+	schedulerMutex.Lock()
+	shutdown(server, scheduler, taweretSettings, taweretStatus)
+	releaseLease()
+	<-leaderElectionDone
+}
 
-	for _, aBackup := range uncategorisedBackups {
-		if aBackup.time.After(maxBackupDateTime) && (aBackup.status == "complete" || aBackup.status == "failed") {
-			aBackup.inUse = true
-			categorisedBackups = append(categorisedBackups, aBackup)
-		} else if aBackup.status == "pending" {
-			backupCounts.pending++
-		} else if aBackup.status == "running" {
-			backupCounts.running++
-		} else if aBackup.status == "failed" || aBackup.status == "attemptfailed" {
-			backupCounts.failed++
-		} else if aBackup.status == "skipped" {
-			backupCounts.skipped++
-		} else if aBackup.status == "deleting" {
-			backupCounts.deleting++
+// stops the scheduler and the HTTP server, waiting at most the shutdown grace period for a running evaluation to finish
+func shutdown(server *http.Server, scheduler *gocron.Scheduler, taweretSettings taweretsettings, taweretStatus *taweretstatus) {
+	log.Printf("shutting down, waiting up to %v for a running evaluation to finish", taweretSettings.shutdownGracePeriod)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), taweretSettings.shutdownGracePeriod)
+	defer cancel()
+
+	evaluationsStopped := make(chan struct{})
+	go func() {
+		// stopping the scheduler waits for a running scheduled evaluation, the evaluation mutex is held by on-demand evaluations
+		// and is not released again, so that no further evaluation is started before the process exits
+		// a follower has no scheduler
+		if scheduler != nil {
+			scheduler.Stop()
 		}
-	}
-
-	categorisedAndSortedBackups := sortBackups(categorisedBackups, backupConfig)
-	log.Printf("%v: categorised backups: %v\n", backupConfig.Name, len(categorisedAndSortedBackups))
-	return categorisedAndSortedBackups, backupCounts
-}
+		taweretStatus.evaluationMutex.Lock()
+		close(evaluationsStopped)
+	}()
 
-// delete a specified number of the oldest backups in a backup slice
-func deleteOldestBackups(backups []backup, count int, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, backupConfig backupconfig) {
-	backups = sortBackups(backups, backupConfig)
-	for i := 0; i < count; i++ {
-		log.Printf("%v: deleting backup %v, backup time: %v, deletion nr %v, total to delete %v, total backups in category: %v\n", backupConfig.Name, backups[i].name, backups[i].time.UTC(), i+1, count, len(backups))
-		deleteBackup(backups[i], dynamicClient, gvr, backupConfig)
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("error shutting down HTTP server", "error", err)
 	}
-}
-
-// sort the backup slices with the oldest backups placed at the start of the slice
-func sortBackups(backups []backup, backupConfig backupconfig) []backup {
-	log.Printf("%v: sorting backups chronologically\n", backupConfig.Name)
-	sort.Slice(backups, func(q, p int) bool {
-		return backups[p].time.After(backups[q].time)
-	})
-	return backups
-}
-
-// deletes a specified backup by creating an actionset with the action 'delete'
-// func deleteBackup(unusedBackup backup, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, backupConfig backupconfig) {
-
-// 	// set name of deletion actionset
-// 	deletionActionsetName := fmt.Sprintf("delete-%v", unusedBackup.name)
-
-// 	// check if the deletion actionset already exists
-//     _, err := dynamicClient.Resource(gvr).Namespace(backupConfig.KanisterNamespace).Get(context.Background(), deletionActionsetName, v1.GetOptions{})
-//     if err == nil {
-//         log.Printf("Deletion actionset %v already exists, skipping creation", deletionActionsetName)
-//         return
-//     }
-// 	// construct actionset crd manifest to delete backup
-// 	deletionActionSet := v1alpha1.ActionSet{
-// 		Spec: &v1alpha1.ActionSetSpec{
-// 			Actions: []v1alpha1.ActionSpec{
-// 				{
-// 					Name:      "delete",
-// 					Blueprint: backupConfig.BlueprintName,
-// 					Artifacts: map[string]v1alpha1.Artifact{
-// 						"cloudObject": {
-// 							KeyValue: map[string]string{
-// 								"backupLocation": unusedBackup.backupLocation,
-// 							},
-// 						},
-// 					},
-// 					Object: v1alpha1.ObjectReference{
-// 						Kind:      "namespace",
-// 						Name:      backupConfig.KanisterNamespace,
-// 						Namespace: backupConfig.KanisterNamespace,
-// 					},
-// 					Profile: &v1alpha1.ObjectReference{
-// 						Name:      backupConfig.ProfileName,
-// 						Namespace: backupConfig.KanisterNamespace,
-// 					},
-// 				},
-// 			},
-// 		},
-// 		TypeMeta: v1.TypeMeta{
-// 			APIVersion: "cr.kanister.io/v1alpha1",
-// 			Kind:       "ActionSet",
-// 		},
-// 		ObjectMeta: v1.ObjectMeta{
-// 			Name:      deletionActionsetName,
-// 			Namespace: backupConfig.KanisterNamespace,
-// 		},
-// 	}
-
-// 	// convert to unstructured to apply with dynamicClient
-// 	myCRAsUnstructured, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&deletionActionSet)
-// 	if err != nil {
-// 		panic(err.Error())
-// 	}
-// 	myCRUnstructured := &unstructured.Unstructured{Object: myCRAsUnstructured}
-
-// 	// apply deletion actionset
-// 	appliedActionSet, err := dynamicClient.Resource(gvr).Namespace(backupConfig.KanisterNamespace).Create(context.Background(), myCRUnstructured, v1.CreateOptions{})
-// 	log.Printf("Applying the following deletion actionset: %v", appliedActionSet)
-// 	if err != nil {
-// 		panic(err.Error())
-// 	}
-
-// 	// loop to check status of deletion actionset whilst actionset is running
-// 	for {
-// 		log.Printf("%v: waiting for %v to complete... ", backupConfig.Name, deletionActionsetName)
-// 		time.Sleep(5 * time.Second)
-
-// 		// get deletion actionset
-// 		actionset, err := dynamicClient.Resource(gvr).Namespace(backupConfig.KanisterNamespace).Get(context.Background(), deletionActionsetName, v1.GetOptions{})
-// 		if err != nil {
-// 			log.Printf("%v: error retrieving deletion actionset: %v\n", backupConfig.Name, err)
-// 			os.Exit(1)
-// 		}
-
-// 		// check if deletion actionset status is "complete"
-// 		if actionset.Object["status"].(map[string]interface{})["state"] == "complete" {
-// 			log.Printf("%v: %v has completed\n", backupConfig.Name, deletionActionsetName)
-// 			break
-// 		}
-
-// 		// check if deletion actionset status is "failed"
-// 		if actionset.Object["status"].(map[string]interface{})["state"] == "failed" {
-// 			log.Printf("%v: error deleting backup with actionset %v, error: %v\n", backupConfig.Name, deletionActionsetName, actionset.Object["status"].(map[string]interface{})["error"].(map[string]interface{})["message"])
-// 			break
-// 		}
-
-// 		// print current state of deletion actionset
-// 		log.Printf("%v\n", actionset.Object["status"].(map[string]interface{})["state"])
-// 	}
-
-// 	// delete backup actionset
-// 	err = dynamicClient.Resource(gvr).Namespace(backupConfig.KanisterNamespace).Delete(context.Background(), unusedBackup.name, v1.DeleteOptions{})
-// 	if err != nil {
-// 		log.Printf("%v: error deleting backup actionset: %v\n", backupConfig.Name, err)
-// 		os.Exit(1)
-// 	}
-
-// }
-
-// deletes a specified backup by creating an actionset with the action 'delete'
-func deleteBackup(unusedBackup backup, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, backupConfig backupconfig) {
-	// set name of deletion actionset
-	deletionActionsetName := fmt.Sprintf("delete-%v", unusedBackup.name)
-
-	// check if the deletion actionset already exists
-	_, err := dynamicClient.Resource(gvr).Namespace(backupConfig.KanisterNamespace).Get(context.Background(), deletionActionsetName, v1.GetOptions{})
-	if err == nil {
-		log.Printf("Deletion actionset %v already exists, skipping creation", deletionActionsetName)
-		return
+	select {
+	case <-evaluationsStopped:
+		log.Printf("shutdown complete")
+	case <-shutdownCtx.Done():
+		slog.Warn("running evaluation did not finish within the shutdown grace period, exiting anyway", "grace_period", taweretSettings.shutdownGracePeriod)
 	}
+}
 
-	// construct actionset crd manifest to delete backup
-	deletionActionSet := v1alpha1.ActionSet{
-		Spec: &v1alpha1.ActionSetSpec{
-			Actions: []v1alpha1.ActionSpec{
-				{
-					Name:      "delete",
-					Blueprint: backupConfig.BlueprintName,
-					Object: v1alpha1.ObjectReference{
-						Kind:      "namespace",
-						Name:      backupConfig.KanisterNamespace,
-						Namespace: backupConfig.KanisterNamespace,
-					},
-				},
-			},
-		},
-		TypeMeta: v1.TypeMeta{
-			APIVersion: "cr.kanister.io/v1alpha1",
-			Kind:       "ActionSet",
-		},
-		ObjectMeta: v1.ObjectMeta{
-			Name:      deletionActionsetName,
-			Namespace: backupConfig.KanisterNamespace,
-		},
+// returns the TLS config of the HTTP server requiring client certificates signed by the client CA, or nil if no client CA is set
+// the server certificate is loaded by ListenAndServeTLS
+func serverTLSConfig(taweretSettings taweretsettings) (*tls.Config, error) {
+	if taweretSettings.tlsClientCA == "" {
+		return nil, nil
 	}
-
-	// Add Artifacts if backupLocation exists
-	if unusedBackup.backupLocation != "" {
-		deletionActionSet.Spec.Actions[0].Artifacts = map[string]v1alpha1.Artifact{
-			"cloudObject": {
-				KeyValue: map[string]string{
-					"backupLocation": unusedBackup.backupLocation,
-				},
-			},
-		}
+	caCertificates, err := os.ReadFile(taweretSettings.tlsClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("error reading client CA %v: %w", taweretSettings.tlsClientCA, err)
 	}
-
-	// Add Profile if provided
-	if backupConfig.ProfileName != "" {
-		deletionActionSet.Spec.Actions[0].Profile = &v1alpha1.ObjectReference{
-			Name:      backupConfig.ProfileName,
-			Namespace: backupConfig.KanisterNamespace,
-		}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caCertificates) {
+		return nil, fmt.Errorf("no PEM certificates found in client CA %v", taweretSettings.tlsClientCA)
 	}
+	return &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
 
-	// convert to unstructured to apply with dynamicClient
-	myCRAsUnstructured, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&deletionActionSet)
-	if err != nil {
-		panic(err.Error())
+// builds the Kubernetes client config, preferring the in-cluster config and falling back to KUBECONFIG or ~/.kube/config
+func buildConfig() (*rest.Config, error) {
+	config, err := rest.InClusterConfig()
+	if err == nil {
+		log.Printf("using in-cluster Kubernetes config")
+		return config, nil
 	}
-	myCRUnstructured := &unstructured.Unstructured{Object: myCRAsUnstructured}
 
-	// apply deletion actionset
-	appliedActionSet, err := dynamicClient.Resource(gvr).Namespace(backupConfig.KanisterNamespace).Create(context.Background(), myCRUnstructured, v1.CreateOptions{})
-	log.Printf("Applying the following deletion actionset: %v", appliedActionSet)
-	if err != nil {
-		panic(err.Error())
+	// KUBECONFIG may contain a list of paths, use the first one which exists
+	var kubeconfigs []string
+	if os.Getenv("KUBECONFIG") != "" {
+		kubeconfigs = filepath.SplitList(os.Getenv("KUBECONFIG"))
+	} else if home, homeErr := os.UserHomeDir(); homeErr == nil {
+		kubeconfigs = []string{filepath.Join(home, ".kube", "config")}
 	}
-
-	// loop to check status of deletion actionset whilst actionset is running
-	for {
-		log.Printf("%v: waiting for %v to complete... ", backupConfig.Name, deletionActionsetName)
-		time.Sleep(5 * time.Second)
-
-		// get deletion actionset
-		actionset, err := dynamicClient.Resource(gvr).Namespace(backupConfig.KanisterNamespace).Get(context.Background(), deletionActionsetName, v1.GetOptions{})
-		if err != nil {
-			// handle not found error gracefully (actionset deleted while checking)
-			if strings.Contains(err.Error(), "not found") {
-				log.Printf("%v: deletion actionset %v no longer exists (may have been deleted), continuing to next.", backupConfig.Name, deletionActionsetName)
-				return // continue to next actionset in caller
-			}
-			log.Printf("%v: error retrieving deletion actionset: %v\n", backupConfig.Name, err)
-			os.Exit(1)
-		}
-
-		status, ok := actionset.Object["status"].(map[string]interface{})
-		if !ok || status == nil {
-			log.Printf("%v: status not available yet for %v, waiting...", backupConfig.Name, deletionActionsetName)
+	for _, kubeconfig := range kubeconfigs {
+		if _, statErr := os.Stat(kubeconfig); statErr != nil {
 			continue
 		}
-
-		state, _ := status["state"].(string)
-		if state == "complete" {
-			log.Printf("%v: %v has completed\n", backupConfig.Name, deletionActionsetName)
-			break
-		}
-		if state == "failed" {
-			var errMsg interface{} = ""
-			if errVal, ok := status["error"].(map[string]interface{}); ok {
-				errMsg = errVal["message"]
-			}
-			log.Printf("%v: error deleting backup with actionset %v, error: %v\n", backupConfig.Name, deletionActionsetName, errMsg)
-			break
-		}
-		log.Printf("%v\n", state)
-	}
-
-	// delete backup actionset
-	err = dynamicClient.Resource(gvr).Namespace(backupConfig.KanisterNamespace).Delete(context.Background(), unusedBackup.name, v1.DeleteOptions{})
-	if err != nil {
-		log.Printf("%v: error deleting backup actionset: %v\n", backupConfig.Name, err)
-		os.Exit(1)
-	}
-}
-
-// UnmarshalYAML is a custom YAML unmarshaller to allow string to stringint type conversion
-func (st *StringInt) UnmarshalYAML(b []byte) error {
-	var item interface{}
-	if err := yaml.Unmarshal(b, &item); err != nil {
-		return err
-	}
-	switch v := item.(type) {
-	case int:
-		*st = StringInt(v)
-	case float64:
-		*st = StringInt(int(v))
-	case string:
-		i, err := strconv.Atoi(v)
-		if err != nil {
-			return err
+		config, kubeconfigErr := clientcmd.BuildConfigFromFlags("", kubeconfig)
+		if kubeconfigErr != nil {
+			return nil, fmt.Errorf("error building config from kubeconfig %v: %w", kubeconfig, kubeconfigErr)
 		}
-		*st = StringInt(i)
-	}
-	return nil
-}
-
-// initialise Prometheus metrics
-func initialiseMetrics() taweretmetrics {
-	var taweretMetrics taweretmetrics
-	taweretMetrics.backupCount = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "backup_count",
-			Help: "The amount of backups",
-		},
-		[]string{
-			// which backup config
-			"backup_config_name",
-			// state of the backups
-			"backup_status",
-		},
-	)
-	taweretMetrics.oldestBackup = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "oldest_backup_timestamp",
-			Help: "The amount of backups",
-		},
-		[]string{
-			// which backup config
-			"backup_config_name",
-		},
-	)
-	taweretMetrics.newestBackup = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "newest_backup_timestamp",
-			Help: "The amount of backups",
-		},
-		[]string{
-			// which backup config
-			"backup_config_name",
-		},
-	)
-
-	prometheus.MustRegister(taweretMetrics.backupCount)
-	prometheus.MustRegister(taweretMetrics.oldestBackup)
-	prometheus.MustRegister(taweretMetrics.newestBackup)
-
-	return taweretMetrics
-}
-
-// set Prometheus metrics values
-func (taweretMetrics *taweretmetrics) setMetrics(backups []backup, backupConfig backupconfig, backupCounts backupcounts) {
-	log.Printf("%v: setting Prometheus metrics\n", backupConfig.Name)
-
-	// set newestBackup and oldestBackup to corresponding backup timestamps if backups are present
-	if len(backups) > 0 {
-		taweretMetrics.oldestBackup.WithLabelValues(backupConfig.Name).Set(float64(backups[0].time.Unix()))
-		taweretMetrics.newestBackup.WithLabelValues(backupConfig.Name).Set(float64(backups[len(backups)-1].time.Unix()))
-
-	} else {
-		taweretMetrics.oldestBackup.WithLabelValues(backupConfig.Name).Set(0)
-		taweretMetrics.newestBackup.WithLabelValues(backupConfig.Name).Set(0)
+		log.Printf("using Kubernetes config from kubeconfig %v", kubeconfig)
+		return config, nil
 	}
 
-	// set backupCount for completed, pending, running, failed, skipped and deleting state backups
-	taweretMetrics.backupCount.WithLabelValues(backupConfig.Name, "completed").Set(float64(len(backups)))
-	taweretMetrics.backupCount.WithLabelValues(backupConfig.Name, "pending").Set(float64(backupCounts.pending))
-	taweretMetrics.backupCount.WithLabelValues(backupConfig.Name, "running").Set(float64(backupCounts.running))
-	taweretMetrics.backupCount.WithLabelValues(backupConfig.Name, "failed").Set(float64(backupCounts.failed))
-	taweretMetrics.backupCount.WithLabelValues(backupConfig.Name, "skipped").Set(float64(backupCounts.skipped))
-	taweretMetrics.backupCount.WithLabelValues(backupConfig.Name, "deleting").Set(float64(backupCounts.deleting))
+	return nil, fmt.Errorf("error building in-cluster config and no kubeconfig found: %w", err)
 }
@@ -1,17 +1,53 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-co-op/gocron"
+	"github.com/kanisterio/kanister/pkg/apis/cr/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/swissdatasciencecenter/taweret/internal/retention"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gopkg.in/yaml.v2"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
 	fake "k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
 )
 
 func newUnstructuredBackup(name, namespace, creationTimestamp, actionName, schedule, status, backupLocation string) *unstructured.Unstructured {
+	return newUnstructuredBackupWithLabels(name, namespace, creationTimestamp, actionName, schedule, status, backupLocation, nil)
+}
+
+func newUnstructuredBackupWithLabels(name, namespace, creationTimestamp, actionName, schedule, status, backupLocation string, labels map[string]interface{}) *unstructured.Unstructured {
 	return &unstructured.Unstructured{
 		Object: map[string]interface{}{
 			"apiVersion": "cr.kanister.io/v1alpha1",
@@ -20,6 +56,7 @@ func newUnstructuredBackup(name, namespace, creationTimestamp, actionName, sched
 				"namespace":         namespace,
 				"name":              name,
 				"creationTimestamp": creationTimestamp,
+				"labels":            labels,
 			},
 			"spec": map[string]interface{}{
 				"actions": []interface{}{
@@ -51,11 +88,11 @@ func newUnstructuredBackup(name, namespace, creationTimestamp, actionName, sched
 
 func TestGetBackups(t *testing.T) {
 	defaultTime, _ := time.Parse(time.RFC3339, "2022-01-01T02:03:04.52Z")
-	expectedBackups := []backup{
-		{name: "backup-foo", schedule: "weekly", status: "complete", time: defaultTime, backupLocation: "pg_backups/renku/renku-postgresql/2022-01-01T02:03:04.52Z/backup.sql.gz"},
-		{name: "backup-bar", schedule: "daily", status: "complete", time: defaultTime, backupLocation: "pg_backups/renku/renku-postgresql/2022-01-01T02:03:04.52Z/backup.sql.gz"},
+	expectedBackups := []retention.Backup{
+		{Name: "backup-foo", Schedule: "weekly", Status: "complete", Time: defaultTime, BackupLocation: "pg_backups/renku/renku-postgresql/2022-01-01T02:03:04.52Z/backup.sql.gz", LocationArtifact: "cloudObject", LocationKey: "backupLocation"},
+		{Name: "backup-bar", Schedule: "daily", Status: "complete", Time: defaultTime, BackupLocation: "pg_backups/renku/renku-postgresql/2022-01-01T02:03:04.52Z/backup.sql.gz", LocationArtifact: "cloudObject", LocationKey: "backupLocation"},
 	}
-	sort.Slice(expectedBackups, func(i, j int) bool { return expectedBackups[i].name < expectedBackups[j].name })
+	sort.Slice(expectedBackups, func(i, j int) bool { return expectedBackups[i].Name < expectedBackups[j].Name })
 	gvr := schema.GroupVersionResource{
 		Group:    "cr.kanister.io",
 		Version:  "v1alpha1",
@@ -76,14 +113,2729 @@ func TestGetBackups(t *testing.T) {
 	backupConfig.KanisterNamespace = "kanister"
 	backupConfig.Name = "daily"
 
-	backups := getBackups(client, gvr, backupConfig)
+	backups, err := getBackups(context.Background(), client, gvr, newMetrics(), taweretsettings{apiTimeout: defaultAPITimeout}, backupConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(backups) < 1 {
 		t.Fatal("Empty backups")
 	}
-	sort.Slice(backups, func(i, j int) bool { return expectedBackups[i].name < expectedBackups[j].name })
+	sort.Slice(backups, func(i, j int) bool { return expectedBackups[i].Name < expectedBackups[j].Name })
 	for i, backup := range backups {
 		if backup != expectedBackups[i] {
 			t.Fatal("Returned backup different from the expected one.")
 		}
 	}
 }
+
+func TestUnfinishedBackups(t *testing.T) {
+	now := time.Date(2024, time.March, 31, 12, 0, 0, 0, time.UTC)
+	backups := []retention.Backup{
+		{Name: "backup-complete", Status: "complete", Time: now.Add(-48 * time.Hour)},
+		{Name: "backup-running", Status: "running", Time: now.Add(-7 * time.Hour)},
+		{Name: "backup-new", Status: "", Time: now.Add(-time.Minute)},
+	}
+	var backupConfig backupconfig
+	backupConfig.Name = "daily"
+
+	tests := []struct {
+		name      string
+		threshold time.Duration
+		stuck     float64
+	}{
+		{name: "stuck", threshold: 6 * time.Hour, stuck: 1},
+		{name: "within threshold", threshold: 8 * time.Hour, stuck: 0},
+		{name: "disabled", threshold: 0, stuck: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			taweretSettings := taweretsettings{clock: func() time.Time { return now }, stuckBackupThreshold: test.threshold}
+			oldestAge, stuck := unfinishedBackups(backups, backupConfig, taweretSettings)
+			// the completed backup is older, but only pending and running backups are considered
+			if oldestAge != 7*time.Hour {
+				t.Fatalf("expected the oldest unfinished backup to be 7h old, got %v", oldestAge)
+			}
+			if stuck != test.stuck {
+				t.Fatalf("expected stuck %v, got %v", test.stuck, stuck)
+			}
+		})
+	}
+}
+
+func TestCategoriseBackupsClock(t *testing.T) {
+	// the end of March, a month earlier is the 2nd of March as February has no 31st
+	now := time.Date(2024, time.March, 31, 12, 0, 0, 0, time.UTC)
+	taweretSettings := taweretsettings{clock: func() time.Time { return now }}
+
+	tests := []struct {
+		name      string
+		retention backupretention
+		cutoff    time.Time
+	}{
+		{name: "minutes", retention: backupretention{Minutes: 90}, cutoff: now.Add(-90 * time.Minute)},
+		{name: "hours", retention: backupretention{Hours: 36}, cutoff: now.Add(-36 * time.Hour)},
+		{name: "days", retention: backupretention{Days: 7}, cutoff: time.Date(2024, time.March, 24, 12, 0, 0, 0, time.UTC)},
+		{name: "months", retention: backupretention{Months: 1}, cutoff: time.Date(2024, time.March, 2, 12, 0, 0, 0, time.UTC)},
+		{name: "years", retention: backupretention{Years: 1}, cutoff: time.Date(2023, time.March, 31, 12, 0, 0, 0, time.UTC)},
+		// the day and month are subtracted together, the 30th of February is the 1st of March
+		{name: "combined", retention: backupretention{Hours: 12, Days: 1, Months: 1}, cutoff: time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var backupConfig backupconfig
+			backupConfig.Name = "daily"
+			backupConfig.Retention = test.retention
+			backupConfig.Retention.Backups = 10
+			// backups a minute inside and outside of the retention period, and a newest one which is always retained, without
+			// grandfather-father-son buckets the backup outside of the retention period is neither retained nor deletable
+			backups := []retention.Backup{
+				{Name: "outside", Status: "complete", Time: test.cutoff.Add(-time.Minute)},
+				{Name: "inside", Status: "complete", Time: test.cutoff.Add(time.Minute)},
+				{Name: "newest", Status: "complete", Time: now.Add(-time.Second)},
+			}
+
+			retainedBackups, deletableBackups, _ := categoriseBackups(backups, backupConfig, taweretSettings)
+			if len(retainedBackups) != 2 || retainedBackups[0].Name != "inside" || retainedBackups[1].Name != "newest" {
+				t.Errorf("expected the inside and newest backups to be retained, got %v", retainedBackups)
+			}
+			if len(deletableBackups) != 0 {
+				t.Errorf("expected no deletable backups, got %v", deletableBackups)
+			}
+		})
+	}
+}
+
+func TestParseBackupMalformed(t *testing.T) {
+	var backupConfig backupconfig
+	backupConfig.Name = "daily"
+
+	tests := []struct {
+		name   string
+		mutate func(object map[string]interface{})
+		parsed bool
+	}{
+		{name: "valid", mutate: func(object map[string]interface{}) {}, parsed: true},
+		{name: "without status", mutate: func(object map[string]interface{}) { delete(object, "status") }, parsed: true},
+		{name: "without spec", mutate: func(object map[string]interface{}) { delete(object, "spec") }},
+		{name: "without actions", mutate: func(object map[string]interface{}) {
+			object["spec"] = map[string]interface{}{"actions": []interface{}{}}
+		}},
+		{name: "malformed action", mutate: func(object map[string]interface{}) {
+			object["spec"] = map[string]interface{}{"actions": []interface{}{"backup"}}
+		}},
+		{name: "non-string action name", mutate: func(object map[string]interface{}) {
+			object["spec"].(map[string]interface{})["actions"].([]interface{})[0].(map[string]interface{})["name"] = 1
+		}},
+		{name: "malformed status", mutate: func(object map[string]interface{}) { object["status"] = "complete" }, parsed: true},
+		{name: "without metadata", mutate: func(object map[string]interface{}) { delete(object, "metadata") }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actionset := newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "backup.sql.gz")
+			test.mutate(actionset.Object)
+			if _, parsed := parseBackup(*actionset, backupConfig); parsed != test.parsed {
+				t.Fatalf("expected parsed %v, got %v", test.parsed, parsed)
+			}
+		})
+	}
+}
+
+func TestGetBackupsInvalidTimestamp(t *testing.T) {
+	gvr := schema.GroupVersionResource{
+		Group:    "cr.kanister.io",
+		Version:  "v1alpha1",
+		Resource: "actionsets",
+	}
+	scheme := runtime.NewScheme()
+
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(scheme,
+		map[schema.GroupVersionResource]string{
+			{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}: "ActionSetsList",
+		},
+		newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", ""),
+		newUnstructuredBackup("backup-bar", "kanister", "yesterday", "backup", "daily", "complete", ""),
+	)
+
+	var backupConfig backupconfig
+	backupConfig.KanisterNamespace = "kanister"
+	backupConfig.Name = "daily"
+
+	taweretMetrics := newMetrics()
+	backups, err := getBackups(context.Background(), client, gvr, taweretMetrics, taweretsettings{apiTimeout: defaultAPITimeout}, backupConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0].Name != "backup-foo" {
+		t.Fatalf("expected the backup with a malformed timestamp to be skipped, got %v", backups)
+	}
+	if invalid := testutil.ToFloat64(taweretMetrics.invalidTimestamps.WithLabelValues(backupConfig.metricLabels()...)); invalid != 1 {
+		t.Fatalf("expected 1 backup with an invalid timestamp, got %v", invalid)
+	}
+}
+
+func TestNormaliseState(t *testing.T) {
+	taweretSettings := taweretsettings{completeStates: []string{"succeeded"}, failedStates: []string{"Error"}}
+	tests := map[string]string{
+		"complete":  "complete",
+		"Complete":  "complete",
+		"Succeeded": "complete",
+		"error":     "failed",
+		"failed":    "failed",
+		"running":   "running",
+		"":          "",
+	}
+	for state, expected := range tests {
+		if normalised := normaliseState(state, taweretSettings); normalised != expected {
+			t.Errorf("expected state %q to be normalised to %q, got %q", state, expected, normalised)
+		}
+	}
+}
+
+func TestIsPinned(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		labels      map[string]string
+		pinned      bool
+	}{
+		{name: "not pinned"},
+		{name: "annotation", annotations: map[string]string{"taweret.io/retain": "true"}, pinned: true},
+		{name: "label", labels: map[string]string{"taweret.io/retain": "true"}, pinned: true},
+		{name: "not true", annotations: map[string]string{"taweret.io/retain": "false"}},
+		{name: "other key", annotations: map[string]string{"example.com/retain": "true"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actionset := newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "pg_backups/backup.sql.gz")
+			actionset.SetAnnotations(test.annotations)
+			actionset.SetLabels(test.labels)
+			if pinned := isPinned(*actionset, defaultRetainKey); pinned != test.pinned {
+				t.Errorf("expected pinned %v, got %v", test.pinned, pinned)
+			}
+		})
+	}
+}
+
+func TestParseBackupLocationPaths(t *testing.T) {
+	actionset := newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "")
+	actionset.Object["status"].(map[string]interface{})["actions"] = []interface{}{
+		map[string]interface{}{
+			"artifacts": map[string]interface{}{
+				"s3Dump": map[string]interface{}{"keyValue": map[string]interface{}{"path": "s3://backups/backup.sql.gz"}},
+			},
+		},
+	}
+
+	var backupConfig backupconfig
+	backupConfig.Name = "daily"
+	backupConfig.BackupLocationPaths = []string{"cloudObject.backupLocation", "s3Dump.path"}
+	backup, ok := parseBackup(*actionset, backupConfig)
+	if !ok {
+		t.Fatalf("expected the actionset to be parsed as a backup")
+	}
+	if backup.BackupLocation != "s3://backups/backup.sql.gz" || backup.LocationArtifact != "s3Dump" || backup.LocationKey != "path" {
+		t.Fatalf("expected the backup location to be read from s3Dump.path, got %+v", backup)
+	}
+
+	// with the default backup location path, the artifacts hold no backup location and the backup is not deleted
+	backupConfig.BackupLocationPaths = nil
+	backupConfig.KanisterNamespace = "kanister"
+	backup, _ = parseBackup(*actionset, backupConfig)
+	if !backup.MissingLocation {
+		t.Fatalf("expected the backup location to be missing, got %+v", backup)
+	}
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{gvr: "ActionSetsList"}, actionset)
+	taweretMetrics := newMetrics()
+	deleted, err := deleteOldestBackups(context.Background(), []retention.Backup{backup}, 1, newDeletedLocations(), client, gvr, taweretMetrics, taweretsettings{apiTimeout: defaultAPITimeout}, backupConfig)
+	if err != nil || deleted != 0 || len(client.Actions()) != 0 {
+		t.Fatalf("expected the deletion to be skipped, got %v, %v and actions %v", deleted, err, client.Actions())
+	}
+	if skipped := testutil.ToFloat64(taweretMetrics.missingLocations.WithLabelValues(backupConfig.metricLabels()...)); skipped != 1 {
+		t.Fatalf("expected 1 skipped deletion, got %v", skipped)
+	}
+
+	// the skipped backup does not count towards the max deletions per run, so the newer backup is still deleted
+	if _, err := client.Resource(gvr).Namespace("kanister").Create(context.Background(), newUnstructuredBackup("backup-bar", "kanister", "2022-01-02T02:03:04.52Z", "backup", "daily", "complete", "bar.sql.gz"), v1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	client.PrependReactor("create", "actionsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		deletionActionSet := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+		if err := unstructured.SetNestedField(deletionActionSet.Object, "complete", "status", "state"); err != nil {
+			t.Fatal(err)
+		}
+		return false, nil, nil
+	})
+	backup.Time = time.Date(2022, 1, 1, 2, 3, 4, 0, time.UTC)
+	located := retention.Backup{Name: "backup-bar", Schedule: "daily", Status: "complete", Time: backup.Time.Add(24 * time.Hour), BackupLocation: "bar.sql.gz"}
+	taweretSettings := taweretsettings{apiTimeout: defaultAPITimeout, maxDeletionsPerRun: 1, deletionPollInterval: time.Millisecond, deletionTimeout: time.Second}
+	if deleted, err = deleteOldestBackups(context.Background(), []retention.Backup{located, backup}, 2, newDeletedLocations(), client, gvr, taweretMetrics, taweretSettings, backupConfig); err != nil || deleted != 1 {
+		t.Fatalf("expected backup-bar to be deleted, got %v deleted and error %v", deleted, err)
+	}
+	if skipped := testutil.ToFloat64(taweretMetrics.missingLocations.WithLabelValues(backupConfig.metricLabels()...)); skipped != 2 {
+		t.Fatalf("expected 2 skipped deletions, got %v", skipped)
+	}
+
+	backupConfig.BackupLocationPaths = []string{"s3Dump"}
+	if err := backupConfig.validate(); err == nil {
+		t.Fatalf("expected a backup location path without a key to be rejected")
+	}
+}
+
+func TestParseBackupMultipleActions(t *testing.T) {
+	// a blueprint validating the database before the backup, the backup location is an artifact of the second action
+	actionset := newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "validate", "", "complete", "")
+	actionset.Object["spec"].(map[string]interface{})["actions"] = []interface{}{
+		map[string]interface{}{"name": "validate"},
+		map[string]interface{}{"name": "backup", "options": map[string]interface{}{"backup-schedule": "daily"}},
+	}
+	actionset.Object["status"].(map[string]interface{})["actions"] = []interface{}{
+		map[string]interface{}{
+			"artifacts": map[string]interface{}{
+				"cloudObject": map[string]interface{}{"keyValue": map[string]interface{}{"backupLocation": "validation.log"}},
+			},
+		},
+		map[string]interface{}{
+			"artifacts": map[string]interface{}{
+				"cloudObject": map[string]interface{}{"keyValue": map[string]interface{}{"backupLocation": "backup.sql.gz", "backupSize": "1Ki"}},
+			},
+		},
+	}
+
+	var backupConfig backupconfig
+	backupConfig.Name = "daily"
+	backupConfig.BackupSizePath = "cloudObject.backupSize"
+	backup, ok := parseBackup(*actionset, backupConfig)
+	if !ok {
+		t.Fatalf("expected the actionset to be parsed as a backup")
+	}
+	// the artifacts of the backup action take precedence over those of the validation
+	if backup.BackupLocation != "backup.sql.gz" || backup.Size != 1024 {
+		t.Fatalf("expected the backup location and size of the backup action, got %+v", backup)
+	}
+
+	// the artifacts of the other actions are used for the paths the backup action does not set
+	backupConfig.BackupLocationPaths = []string{"s3Dump.path"}
+	actionset.Object["status"].(map[string]interface{})["actions"].([]interface{})[0] = map[string]interface{}{
+		"artifacts": map[string]interface{}{
+			"s3Dump": map[string]interface{}{"keyValue": map[string]interface{}{"path": "s3://backups/backup.sql.gz"}},
+		},
+	}
+	if backup, _ = parseBackup(*actionset, backupConfig); backup.BackupLocation != "s3://backups/backup.sql.gz" {
+		t.Fatalf("expected the backup location of the validation action, got %+v", backup)
+	}
+
+	// artifacts of only the action after the backup action do not make the backup location missing
+	backupConfig.BackupLocationPaths = nil
+	actionset.Object["spec"].(map[string]interface{})["actions"] = []interface{}{
+		map[string]interface{}{"name": "backup", "options": map[string]interface{}{"backup-schedule": "daily"}},
+		map[string]interface{}{"name": "validate"},
+	}
+	actionset.Object["status"].(map[string]interface{})["actions"] = []interface{}{
+		map[string]interface{}{},
+		map[string]interface{}{
+			"artifacts": map[string]interface{}{
+				"validation": map[string]interface{}{"keyValue": map[string]interface{}{"result": "ok"}},
+			},
+		},
+	}
+	if backup, _ = parseBackup(*actionset, backupConfig); backup.MissingLocation || backup.BackupLocation != "" {
+		t.Fatalf("expected no backup location, without it being reported missing, got %+v", backup)
+	}
+	// while they do if the backup action reports artifacts without a backup location
+	actionset.Object["status"].(map[string]interface{})["actions"].([]interface{})[0] = map[string]interface{}{
+		"artifacts": map[string]interface{}{
+			"cloudObject": map[string]interface{}{"keyValue": map[string]interface{}{"backupSize": "1Ki"}},
+		},
+	}
+	if backup, _ = parseBackup(*actionset, backupConfig); !backup.MissingLocation {
+		t.Fatalf("expected the backup location to be reported missing, got %+v", backup)
+	}
+
+	// an actionset without a backup action is not a backup
+	actionset.Object["spec"].(map[string]interface{})["actions"] = []interface{}{map[string]interface{}{"name": "validate"}}
+	if _, ok := parseBackup(*actionset, backupConfig); ok {
+		t.Fatalf("expected an actionset without a backup action to be skipped")
+	}
+}
+
+func TestParseBackupSize(t *testing.T) {
+	var backupConfig backupconfig
+	backupConfig.Name = "daily"
+	backupConfig.KanisterNamespace = "kanister"
+	backupConfig.BackupSizePath = "cloudObject.backupSize"
+
+	var backups []retention.Backup
+	for _, size := range []string{"1Ki", "2048", "malformed"} {
+		actionset := newUnstructuredBackup("backup-"+size, "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "")
+		actionset.Object["status"].(map[string]interface{})["actions"] = []interface{}{
+			map[string]interface{}{
+				"artifacts": map[string]interface{}{
+					"cloudObject": map[string]interface{}{"keyValue": map[string]interface{}{"backupSize": size}},
+				},
+			},
+		}
+		backup, ok := parseBackup(*actionset, backupConfig)
+		if !ok {
+			t.Fatalf("expected the actionset to be parsed as a backup")
+		}
+		backups = append(backups, backup)
+	}
+	if backups[0].Size != 1024 || backups[1].Size != 2048 || backups[2].Size != 0 {
+		t.Fatalf("expected backup sizes 1024, 2048 and 0, got %v, %v and %v", backups[0].Size, backups[1].Size, backups[2].Size)
+	}
+
+	// the malformed size of the newest backup is skipped
+	taweretMetrics := newMetrics()
+	taweretMetrics.setMetrics(backups, backupConfig, retention.Counts{})
+	if size := testutil.ToFloat64(taweretMetrics.backupSize.WithLabelValues(backupConfig.metricLabels()...)); size != 2048 {
+		t.Errorf("expected a newest backup size of 2048, got %v", size)
+	}
+	if size := testutil.ToFloat64(taweretMetrics.retainedSize.WithLabelValues(backupConfig.metricLabels()...)); size != 3072 {
+		t.Errorf("expected a retained backups size of 3072, got %v", size)
+	}
+
+	backupConfig.BackupSizePath = "backupSize"
+	if err := backupConfig.validate(); err == nil {
+		t.Fatalf("expected a backup size path without a key to be rejected")
+	}
+}
+
+func TestGetBackupsDeletionState(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "ActionSetsList"},
+		newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "foo.sql.gz"),
+		newUnstructuredBackup("backup-bar", "kanister", "2022-01-02T02:03:04.52Z", "backup", "daily", "complete", "bar.sql.gz"),
+		newUnstructuredBackup("delete-backup-foo", "kanister", "2022-01-03T02:03:04.52Z", "delete", "", "failed", ""),
+	)
+
+	var backupConfig backupconfig
+	backupConfig.KanisterNamespace = "kanister"
+	backupConfig.Name = "daily"
+
+	backups, err := getBackups(context.Background(), client, gvr, newMetrics(), taweretsettings{apiTimeout: defaultAPITimeout}, backupConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 backups, got %v", len(backups))
+	}
+	for _, aBackup := range backups {
+		expected := map[string]string{"backup-foo": "failed", "backup-bar": ""}[aBackup.Name]
+		if aBackup.DeletionState != expected {
+			t.Fatalf("%v: expected deletion state %q, got %q", aBackup.Name, expected, aBackup.DeletionState)
+		}
+	}
+}
+
+func TestParseBackupActionPrefixes(t *testing.T) {
+	var backupConfig backupconfig
+	backupConfig.Name = "daily"
+
+	tests := []struct {
+		actionName string
+		prefixes   []string
+		parsed     bool
+	}{
+		{actionName: "backup", parsed: true},
+		{actionName: "backup-full", parsed: true},
+		{actionName: "snapshot", parsed: false},
+		{actionName: "snapshot", prefixes: []string{"snapshot", "full-backup"}, parsed: true},
+		{actionName: "full-backup", prefixes: []string{"snapshot", "full-backup"}, parsed: true},
+		{actionName: "backup", prefixes: []string{"snapshot"}, parsed: false},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%v %v", test.actionName, test.prefixes), func(t *testing.T) {
+			backupConfig.BackupActionPrefixes = test.prefixes
+			actionset := newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", test.actionName, "daily", "complete", "backup.sql.gz")
+			if _, parsed := parseBackup(*actionset, backupConfig); parsed != test.parsed {
+				t.Fatalf("expected parsed %v, got %v", test.parsed, parsed)
+			}
+		})
+	}
+}
+
+func TestParseBackupScheduleSource(t *testing.T) {
+	// the backup-schedule option is weekly, the label and the annotation are daily
+	actionset := newUnstructuredBackupWithLabels("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "weekly", "complete", "backup.sql.gz", map[string]interface{}{"example.com/schedule": "daily"})
+	actionset.SetAnnotations(map[string]string{"example.com/schedule": "daily"})
+
+	tests := []struct {
+		scheduleSource string
+		parsed         bool
+	}{
+		{scheduleSource: "", parsed: false},
+		{scheduleSource: "option:backup-schedule", parsed: false},
+		{scheduleSource: "label:example.com/schedule", parsed: true},
+		{scheduleSource: "annotation:example.com/schedule", parsed: true},
+		{scheduleSource: "label:example.com/other", parsed: false},
+	}
+	for _, test := range tests {
+		t.Run(test.scheduleSource, func(t *testing.T) {
+			backupConfig := backupconfig{Name: "daily", ScheduleSource: test.scheduleSource}
+			if _, parsed := parseBackup(*actionset, backupConfig); parsed != test.parsed {
+				t.Fatalf("expected parsed %v, got %v", test.parsed, parsed)
+			}
+		})
+	}
+
+	for _, invalid := range []string{"backup-schedule", "status:state", "label:"} {
+		if _, err := parseScheduleSource(invalid); err == nil {
+			t.Fatalf("expected an error for the schedule source %q", invalid)
+		}
+	}
+}
+
+func TestStringIntUnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected StringInt
+		valid    bool
+	}{
+		{value: "7", expected: 7, valid: true},
+		{value: `"7"`, expected: 7, valid: true},
+		{value: "7.0", expected: 7, valid: true},
+		{value: "7.9", valid: false},
+		{value: "1e30", valid: false},
+		{value: ".nan", valid: false},
+		{value: "9223372036854775807", expected: math.MaxInt64, valid: true},
+		{value: "18446744073709551615", valid: false},
+		{value: "seven", valid: false},
+		{value: "true", valid: false},
+		{value: "[7]", valid: false},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			var backupConfig backupconfig
+			err := yaml.Unmarshal([]byte("retention:\n  backups: "+test.value+"\n"), &backupConfig)
+			if (err == nil) != test.valid {
+				t.Fatalf("expected valid %v, got error %v", test.valid, err)
+			}
+			if test.valid && backupConfig.Retention.Backups != test.expected {
+				t.Fatalf("expected %v, got %v", test.expected, backupConfig.Retention.Backups)
+			}
+		})
+	}
+}
+
+func TestSettingsValidate(t *testing.T) {
+	valid := taweretsettings{maxConcurrency: 1, auditSize: 1, configSource: "configmap", webhookFormat: "json", k8sQPS: defaultK8sQPS, k8sBurst: defaultK8sBurst}
+	if err := valid.validate(); err != nil {
+		t.Fatalf("expected the settings to be valid, got %v", err)
+	}
+
+	tests := map[string]func(*taweretsettings){
+		"TAWERET_MAX_DELETIONS_PER_RUN must not be negative":    func(s *taweretsettings) { s.maxDeletionsPerRun = -1 },
+		"TAWERET_MAX_CONCURRENT_DELETIONS must not be negative": func(s *taweretsettings) { s.maxConcurrentDeletions = -1 },
+		"TAWERET_MAX_CONCURRENCY must be at least 1":            func(s *taweretsettings) { s.maxConcurrency = 0 },
+	}
+	for expected, invalidate := range tests {
+		taweretSettings := valid
+		invalidate(&taweretSettings)
+		if err := taweretSettings.validate(); err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("expected error %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestSettingsFlags(t *testing.T) {
+	// the settings as read from the environment variables
+	taweretSettings := taweretsettings{evalSchedule: defaultEvalSchedule, configNamespaces: []string{"kanister"}, apiTimeout: defaultAPITimeout}
+	flags := settingsFlags(&taweretSettings, flag.ContinueOnError)
+	err := flags.Parse([]string{"--config-namespace=kanister,renku", "--dry-run", "--api-timeout=1m", "--default-retention={backups: 7, days: \"7\"}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if taweretSettings.evalSchedule != defaultEvalSchedule {
+		t.Errorf("expected the eval schedule to keep its value without a flag, got %q", taweretSettings.evalSchedule)
+	}
+	if !reflect.DeepEqual(taweretSettings.configNamespaces, []string{"kanister", "renku"}) || !taweretSettings.dryRun || taweretSettings.apiTimeout != time.Minute {
+		t.Errorf("expected the flags to override the settings, got %+v", taweretSettings)
+	}
+	if taweretSettings.defaultRetention.Backups != 7 || taweretSettings.defaultRetention.Days != 7 {
+		t.Errorf("expected the default retention to be parsed, got %+v", taweretSettings.defaultRetention)
+	}
+
+	// the printed value of a flag can be passed to the flag again
+	var reparsed taweretsettings
+	if err := settingsFlags(&reparsed, flag.ContinueOnError).Parse([]string{"--default-retention=" + flags.Lookup("default-retention").Value.String()}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reparsed.defaultRetention, taweretSettings.defaultRetention) {
+		t.Errorf("expected the printed default retention to parse to %+v, got %+v", taweretSettings.defaultRetention, reparsed.defaultRetention)
+	}
+
+	if err := settingsFlags(&taweretSettings, flag.ContinueOnError).Parse([]string{"--default-retention={backup: 7}"}); err == nil {
+		t.Error("expected an error for an unknown retention field")
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		list     string
+		expected []string
+	}{
+		{list: "kanister", expected: []string{"kanister"}},
+		{list: "kanister, backups ,tenant-a", expected: []string{"kanister", "backups", "tenant-a"}},
+		{list: " ,kanister,,", expected: []string{"kanister"}},
+		{list: "", expected: nil},
+	}
+	for _, test := range tests {
+		elements := splitList(test.list)
+		if len(elements) != len(test.expected) {
+			t.Fatalf("splitList(%q) returned %v, expected %v", test.list, elements, test.expected)
+		}
+		for i := range elements {
+			if elements[i] != test.expected[i] {
+				t.Fatalf("splitList(%q) returned %v, expected %v", test.list, elements, test.expected)
+			}
+		}
+	}
+}
+
+func TestDeleteBackupDryRun(t *testing.T) {
+	gvr := schema.GroupVersionResource{
+		Group:    "cr.kanister.io",
+		Version:  "v1alpha1",
+		Resource: "actionsets",
+	}
+	scheme := runtime.NewScheme()
+
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(scheme,
+		map[schema.GroupVersionResource]string{
+			{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}: "ActionSetsList",
+		},
+		newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "pg_backups/renku/renku-postgresql/2022-01-01T02:03:04.52Z/backup.sql.gz"),
+	)
+
+	var backupConfig backupconfig
+	backupConfig.KanisterNamespace = "kanister"
+	backupConfig.Name = "daily"
+
+	deleted, err := deleteBackup(context.Background(), retention.Backup{Name: "backup-foo", Schedule: "daily", Status: "complete"}, client, gvr, taweretmetrics{}, taweretsettings{dryRun: true}, backupConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted {
+		t.Fatal("dry run reported the backup as deleted")
+	}
+
+	for _, action := range client.Actions() {
+		if action.GetVerb() != "get" && action.GetVerb() != "list" {
+			t.Fatalf("dry run performed a %v action", action.GetVerb())
+		}
+	}
+}
+
+func TestDeleteBackupResumesCompletedDeletion(t *testing.T) {
+	gvr := schema.GroupVersionResource{
+		Group:    "cr.kanister.io",
+		Version:  "v1alpha1",
+		Resource: "actionsets",
+	}
+	scheme := runtime.NewScheme()
+
+	// the deletion actionset completed, but the backup actionset was not deleted before a restart
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(scheme,
+		map[schema.GroupVersionResource]string{
+			{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}: "ActionSetsList",
+		},
+		newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "pg_backups/renku/renku-postgresql/2022-01-01T02:03:04.52Z/backup.sql.gz"),
+		newUnstructuredBackup("delete-backup-foo", "kanister", "2022-01-02T02:03:04.52Z", "delete", "", "complete", ""),
+	)
+
+	var backupConfig backupconfig
+	backupConfig.KanisterNamespace = "kanister"
+	backupConfig.Name = "daily"
+	taweretSettings := taweretsettings{apiTimeout: defaultAPITimeout, deletionPollInterval: time.Millisecond, deletionTimeout: time.Second}
+
+	deleted, err := deleteBackup(context.Background(), retention.Backup{Name: "backup-foo", Schedule: "daily", Status: "complete"}, client, gvr, newMetrics(), taweretSettings, backupConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !deleted {
+		t.Fatal("expected the backup to be deleted")
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "create" {
+			t.Fatal("expected no new deletion actionset to be created")
+		}
+	}
+	if _, err := client.Resource(gvr).Namespace("kanister").Get(context.Background(), "backup-foo", v1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected the backup actionset to be deleted, got %v", err)
+	}
+}
+
+func TestDeleteBackupCreationConflict(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "ActionSetsList"},
+		newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "backup.sql.gz"),
+	)
+	// the first creation fails with a transient error, the retried one finds the deletion actionset created by another instance
+	creations := 0
+	client.PrependReactor("create", "actionsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		creations++
+		if creations == 1 {
+			return true, nil, apierrors.NewServiceUnavailable("unavailable")
+		}
+		deletionActionSet := newUnstructuredBackup("delete-backup-foo", "kanister", "2022-01-02T02:03:04.52Z", "delete", "", "complete", "")
+		if err := client.Tracker().Add(deletionActionSet); err != nil {
+			t.Fatal(err)
+		}
+		return true, nil, apierrors.NewAlreadyExists(gvr.GroupResource(), "delete-backup-foo")
+	})
+
+	var backupConfig backupconfig
+	backupConfig.KanisterNamespace = "kanister"
+	backupConfig.Name = "daily"
+	taweretSettings := taweretsettings{apiTimeout: defaultAPITimeout, apiRetries: 1, apiRetryBackoff: time.Millisecond, deletionPollInterval: time.Millisecond, deletionTimeout: time.Second}
+
+	deleted, err := deleteBackup(context.Background(), retention.Backup{Name: "backup-foo", Schedule: "daily", Status: "complete"}, client, gvr, newMetrics(), taweretSettings, backupConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !deleted || creations != 2 {
+		t.Fatalf("expected the backup to be deleted after 2 creations, got %v after %v creations", deleted, creations)
+	}
+
+	// a permanent error is returned instead of crashing
+	client.PrependReactor("create", "actionsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(gvr.GroupResource(), "delete-backup-bar", fmt.Errorf("denied"))
+	})
+	if _, err := deleteBackup(context.Background(), retention.Backup{Name: "backup-bar", Schedule: "daily", Status: "complete"}, client, gvr, newMetrics(), taweretSettings, backupConfig); !apierrors.IsForbidden(err) {
+		t.Fatalf("expected the forbidden error to be returned, got %v", err)
+	}
+}
+
+func TestDeleteBackupSoftDelete(t *testing.T) {
+	gvr := schema.GroupVersionResource{
+		Group:    "cr.kanister.io",
+		Version:  "v1alpha1",
+		Resource: "actionsets",
+	}
+	scheme := runtime.NewScheme()
+
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(scheme,
+		map[schema.GroupVersionResource]string{
+			{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}: "ActionSetsList",
+		},
+		newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "pg_backups/renku/renku-postgresql/2022-01-01T02:03:04.52Z/backup.sql.gz"),
+	)
+
+	var backupConfig backupconfig
+	backupConfig.KanisterNamespace = "kanister"
+	backupConfig.Name = "daily"
+	taweretSettings := taweretsettings{apiTimeout: defaultAPITimeout, softDelete: true}
+
+	deleted, err := deleteBackup(context.Background(), retention.Backup{Name: "backup-foo", Schedule: "daily", Status: "complete"}, client, gvr, newMetrics(), taweretSettings, backupConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted {
+		t.Fatal("soft delete reported the backup as deleted")
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "create" || action.GetVerb() == "delete" {
+			t.Fatalf("soft delete performed a %v action", action.GetVerb())
+		}
+	}
+
+	backups, err := getBackups(context.Background(), client, gvr, newMetrics(), taweretSettings, backupConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || !backups[0].Expired {
+		t.Fatalf("expected the backup to be marked as expired, got %v", backups)
+	}
+}
+
+func TestReadyzHandler(t *testing.T) {
+	taweretStatus := &taweretstatus{}
+	handler := readyzHandler(kubefake.NewSimpleClientset(), taweretStatus)
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %v before the first evaluation, got %v", http.StatusServiceUnavailable, recorder.Code)
+	}
+
+	taweretStatus.setLastSuccessfulEvaluation(time.Now())
+	recorder = httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %v after an evaluation, got %v", http.StatusOK, recorder.Code)
+	}
+
+	// followers never evaluate, they are ready without an evaluation
+	followerStatus := &taweretstatus{}
+	followerStatus.setFollower(true)
+	recorder = httptest.NewRecorder()
+	readyzHandler(kubefake.NewSimpleClientset(), followerStatus)(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %v for a follower, got %v", http.StatusOK, recorder.Code)
+	}
+}
+
+func TestRunLeaderElection(t *testing.T) {
+	clientSet := kubefake.NewSimpleClientset()
+	taweretMetrics := newMetrics()
+	taweretSettings := taweretsettings{configNamespaces: []string{"kanister"}, leaderElection: true, leaderElectionLease: "taweret"}
+	taweretStatus := &taweretstatus{}
+	taweretStatus.setFollower(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leading := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		runLeaderElection(ctx, clientSet, taweretMetrics, taweretSettings, taweretStatus, func() { close(leading) })
+		close(done)
+	}()
+	select {
+	case <-leading:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the only instance to become the leader")
+	}
+	if taweretStatus.isFollower() || testutil.ToFloat64(taweretMetrics.leader) != 1 {
+		t.Fatalf("expected the instance to lead, follower: %v, leader metric: %v", taweretStatus.isFollower(), testutil.ToFloat64(taweretMetrics.leader))
+	}
+	if _, err := clientSet.CoordinationV1().Leases("kanister").Get(context.Background(), "taweret", v1.GetOptions{}); err != nil {
+		t.Fatalf("expected the lease to be created: %v", err)
+	}
+
+	// the lease is released once the context is done
+	cancel()
+	<-done
+	if !taweretStatus.isFollower() || testutil.ToFloat64(taweretMetrics.leader) != 0 {
+		t.Fatalf("expected the instance to stop leading, follower: %v, leader metric: %v", taweretStatus.isFollower(), testutil.ToFloat64(taweretMetrics.leader))
+	}
+}
+
+func TestEvaluateHandler(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "ActionSetsList"},
+	)
+	taweretStatus := &taweretstatus{}
+	taweretSettings := taweretsettings{configNamespaces: []string{"kanister"}, apiTimeout: defaultAPITimeout, maxConcurrency: 1}
+	handler := evaluateHandler(client, gvr, kubefake.NewSimpleClientset(), newMetrics(), taweretSettings, taweretStatus)
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		locked   bool
+		follower bool
+		status   int
+	}{
+		{name: "get", method: http.MethodGet, target: "/evaluate", status: http.StatusMethodNotAllowed},
+		{name: "follower", method: http.MethodPost, target: "/evaluate", follower: true, status: http.StatusServiceUnavailable},
+		{name: "running", method: http.MethodPost, target: "/evaluate", locked: true, status: http.StatusConflict},
+		{name: "unknown config", method: http.MethodPost, target: "/evaluate?config=daily", status: http.StatusNotFound},
+		{name: "all configs", method: http.MethodPost, target: "/evaluate", status: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.locked {
+				taweretStatus.evaluationMutex.Lock()
+				defer taweretStatus.evaluationMutex.Unlock()
+			}
+			taweretStatus.setFollower(test.follower)
+			recorder := httptest.NewRecorder()
+			handler(recorder, httptest.NewRequest(test.method, test.target, nil))
+			if recorder.Code != test.status {
+				t.Fatalf("expected status %v, got %v: %v", test.status, recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestRequireAdminToken(t *testing.T) {
+	handler := requireAdminToken(taweretsettings{adminToken: "secret"}, healthzHandler)
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{name: "missing token", status: http.StatusUnauthorized},
+		{name: "wrong token", authorization: "Bearer guess", status: http.StatusUnauthorized},
+		{name: "token without scheme", authorization: "secret", status: http.StatusUnauthorized},
+		{name: "admin token", authorization: "Bearer secret", status: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/config", nil)
+			if test.authorization != "" {
+				request.Header.Set("Authorization", test.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			handler(recorder, request)
+			if recorder.Code != test.status {
+				t.Fatalf("expected status %v, got %v: %v", test.status, recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+
+	// without an admin token, the handler is left open
+	recorder := httptest.NewRecorder()
+	requireAdminToken(taweretsettings{}, healthzHandler)(recorder, httptest.NewRequest(http.MethodGet, "/config", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %v without an admin token, got %v", http.StatusOK, recorder.Code)
+	}
+}
+
+func TestBackupsHandler(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	now := time.Now().UTC()
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "ActionSetsList"},
+		newUnstructuredBackup("backup-old", "kanister", now.Add(-2*time.Hour).Format(time.RFC3339), "backup", "daily", "complete", "old.sql.gz"),
+		newUnstructuredBackup("backup-new", "kanister", now.Add(-time.Hour).Format(time.RFC3339), "backup", "daily", "complete", "new.sql.gz"),
+		newUnstructuredBackup("backup-running", "kanister", now.Format(time.RFC3339), "backup", "daily", "running", ""),
+	)
+	clientSet := kubefake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: "daily", Namespace: "kanister"},
+		Data:       map[string]string{"backup-config.yaml": "name: daily\nkanisterNamespace: kanister\nretention:\n  backups: 1\n  days: 7\n"},
+	}, &corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: "broken", Namespace: "kanister"},
+		Data:       map[string]string{"backup-config.yaml": "name: [broken"},
+	})
+	taweretSettings := taweretsettings{configNamespaces: []string{"kanister"}, apiTimeout: defaultAPITimeout}
+	handler := backupsHandler(client, gvr, clientSet, taweretSettings)
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/backups?config=daily", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %v, got %v: %v", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+	var response []configbackups
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response) != 1 || response[0].Config != "daily" || len(response[0].Backups) != 3 {
+		t.Fatalf("unexpected response %+v", response)
+	}
+	expected := map[string][2]bool{
+		"backup-old":     {false, true},
+		"backup-new":     {true, false},
+		"backup-running": {false, false},
+	}
+	for _, aBackup := range response[0].Backups {
+		if flags := [2]bool{aBackup.InUse, aBackup.Deletable}; flags != expected[aBackup.Name] {
+			t.Errorf("%v: expected inUse, deletable %v, got %v", aBackup.Name, expected[aBackup.Name], flags)
+		}
+		if aBackup.Held {
+			t.Errorf("%v: expected no held deletion without a deletion delay", aBackup.Name)
+		}
+	}
+	expectNoRecordedMetrics(t)
+
+	// with a deletion delay, the deletable backup which was not marked as deletable yet is held back
+	taweretSettings.deletionDelay = 24 * time.Hour
+	recorder = httptest.NewRecorder()
+	backupsHandler(client, gvr, clientSet, taweretSettings)(recorder, httptest.NewRequest(http.MethodGet, "/backups?config=daily", nil))
+	response = nil
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	for _, aBackup := range response[0].Backups {
+		if aBackup.Held != (aBackup.Name == "backup-old") {
+			t.Errorf("%v: expected held %v, got %v", aBackup.Name, aBackup.Name == "backup-old", aBackup.Held)
+		}
+	}
+
+	recorder = httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/backups?config=weekly", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("expected status %v, got %v", http.StatusNotFound, recorder.Code)
+	}
+}
+
+// fails the test if the metrics recorded while listing backup configs and backups were registered with the default registry,
+// which serves the metrics of the evaluations
+func expectNoRecordedMetrics(t *testing.T) {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		switch family.GetName() {
+		case "config_parse_errors_total", "actionsets_scanned_total", "backup_config_invalid", "backup_configs_loaded", "config_maps_scanned", "backups_invalid_timestamp":
+			t.Errorf("expected metric %v not to be recorded, got %v", family.GetName(), family.GetMetric())
+		}
+	}
+}
+
+func TestCategoriseHandler(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	now := time.Now().UTC()
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "ActionSetsList"},
+		newUnstructuredBackup("backup-old", "kanister", now.Add(-2*time.Hour).Format(time.RFC3339), "backup", "daily", "complete", "old.sql.gz"),
+		newUnstructuredBackup("backup-new", "kanister", now.Add(-time.Hour).Format(time.RFC3339), "backup", "daily", "complete", "new.sql.gz"),
+	)
+	clientSet := kubefake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: "daily", Namespace: "kanister"},
+		Data:       map[string]string{"backup-config.yaml": "name: daily\nkanisterNamespace: kanister\nretention:\n  backups: 1\n  days: 7\n"},
+	}, &corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: "broken", Namespace: "kanister"},
+		Data:       map[string]string{"backup-config.yaml": "name: [broken"},
+	})
+	taweretSettings := taweretsettings{configNamespaces: []string{"kanister"}, apiTimeout: defaultAPITimeout}
+	handler := categoriseHandler(client, gvr, clientSet, taweretSettings)
+
+	// with the override both backups are retained, with the retention of the backup config the old one is deletable
+	for body, expected := range map[string]map[string][2]bool{
+		`{"backups": 2, "days": 7}`: {"backup-old": {true, false}, "backup-new": {true, false}},
+		``:                          {"backup-old": {false, true}, "backup-new": {true, false}},
+	} {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodPost, "/debug/categorise?config=daily", strings.NewReader(body)))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("body %q: expected status %v, got %v: %v", body, http.StatusOK, recorder.Code, recorder.Body.String())
+		}
+		var response categorisation
+		if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Config != "daily" || len(response.Backups) != 2 {
+			t.Fatalf("body %q: unexpected response %+v", body, response)
+		}
+		for _, aBackup := range response.Backups {
+			if flags := [2]bool{aBackup.InUse, aBackup.Deletable}; flags != expected[aBackup.Name] {
+				t.Errorf("body %q: %v: expected inUse, deletable %v, got %v", body, aBackup.Name, expected[aBackup.Name], flags)
+			}
+		}
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() != "list" {
+			t.Errorf("expected the backups to only be listed, got %v %v", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+	expectNoRecordedMetrics(t)
+
+	for _, test := range []struct {
+		method, target, body string
+		status               int
+	}{
+		{http.MethodGet, "/debug/categorise?config=daily", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/debug/categorise", "", http.StatusBadRequest},
+		{http.MethodPost, "/debug/categorise?config=daily", `{"weeks": 2}`, http.StatusBadRequest},
+		{http.MethodPost, "/debug/categorise?config=daily", `{"backups": 0}`, http.StatusBadRequest},
+		{http.MethodPost, "/debug/categorise?config=weekly", "", http.StatusNotFound},
+	} {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(test.method, test.target, strings.NewReader(test.body)))
+		if recorder.Code != test.status {
+			t.Errorf("%v %v %q: expected status %v, got %v", test.method, test.target, test.body, test.status, recorder.Code)
+		}
+	}
+}
+
+func TestConfigHandler(t *testing.T) {
+	clientSet := kubefake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: "daily", Namespace: "kanister"},
+		Data:       map[string]string{"backup-config.yaml": "name: daily\nkanisterNamespace: kanister\nretention:\n  backups: \"7\"\n  days: 7\n"},
+	}, &corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: "broken", Namespace: "kanister"},
+		Data:       map[string]string{"backup-config.yaml": "name: [broken"},
+	})
+	taweretSettings := taweretsettings{configNamespaces: []string{"kanister"}, apiTimeout: defaultAPITimeout}
+
+	recorder := httptest.NewRecorder()
+	configHandler(nil, clientSet, taweretSettings)(recorder, httptest.NewRequest(http.MethodGet, "/config", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %v, got %v: %v", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+	var response []map[string]interface{}
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response) != 1 || response[0]["name"] != "daily" {
+		t.Fatalf("expected the daily backup config, got %v", response)
+	}
+	// the quoted backup count is resolved to a number
+	if backups := response[0]["retention"].(map[string]interface{})["backups"]; backups != 7.0 {
+		t.Fatalf("expected the resolved retention backups 7, got %v", backups)
+	}
+	expectNoRecordedMetrics(t)
+}
+
+func TestSendWebhook(t *testing.T) {
+	event := webhookevent{Event: "deleted", BackupConfig: "daily", BackupName: "backup-foo", BackupTime: time.Date(2022, 1, 1, 2, 3, 4, 0, time.UTC)}
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{format: "json", expected: `{"event":"deleted","backupConfig":"daily","backupName":"backup-foo","backupTime":"2022-01-01T02:03:04Z"}`},
+		{format: "slack", expected: `{"text":"Taweret deleted backup backup-foo of backup config daily created at 2022-01-01T02:03:04Z"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			var received string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body := new(strings.Builder)
+				if _, err := io.Copy(body, r.Body); err != nil {
+					t.Error(err)
+				}
+				received = strings.TrimSpace(body.String())
+			}))
+			defer server.Close()
+
+			if err := sendWebhook(taweretsettings{webhookURL: server.URL, webhookFormat: test.format}, event); err != nil {
+				t.Fatal(err)
+			}
+			if received != test.expected {
+				t.Fatalf("expected payload %v, got %v", test.expected, received)
+			}
+		})
+	}
+}
+
+func TestShutdownGracePeriod(t *testing.T) {
+	taweretStatus := &taweretstatus{}
+	// a running evaluation which does not finish
+	taweretStatus.evaluationMutex.Lock()
+
+	start := time.Now()
+	shutdown(&http.Server{}, gocron.NewScheduler(time.UTC), taweretsettings{shutdownGracePeriod: 50 * time.Millisecond}, taweretStatus)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > 5*time.Second {
+		t.Fatalf("expected shutdown to give up after the grace period, took %v", elapsed)
+	}
+}
+
+func TestIsRetryableAPIError(t *testing.T) {
+	resource := schema.GroupResource{Group: "cr.kanister.io", Resource: "actionsets"}
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{name: "too many requests", err: apierrors.NewTooManyRequests("throttled", 1), retryable: true},
+		{name: "service unavailable", err: apierrors.NewServiceUnavailable("unavailable"), retryable: true},
+		{name: "internal error", err: apierrors.NewInternalError(fmt.Errorf("boom")), retryable: true},
+		{name: "timeout", err: apierrors.NewTimeoutError("timeout", 1), retryable: true},
+		{name: "deadline exceeded", err: fmt.Errorf("list: %w", context.DeadlineExceeded), retryable: true},
+		{name: "forbidden", err: apierrors.NewForbidden(resource, "", fmt.Errorf("rbac")), retryable: false},
+		{name: "not found", err: apierrors.NewNotFound(resource, "backup-foo"), retryable: false},
+		{name: "other", err: fmt.Errorf("boom"), retryable: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if retryable := isRetryableAPIError(test.err); retryable != test.retryable {
+				t.Fatalf("expected retryable %v, got %v", test.retryable, retryable)
+			}
+		})
+	}
+}
+
+func TestRetryAPICall(t *testing.T) {
+	taweretSettings := taweretsettings{apiTimeout: defaultAPITimeout, apiRetries: 3, apiRetryBackoff: time.Millisecond}
+
+	// transient errors are retried until the call succeeds
+	attempts := 0
+	err := retryAPICall(context.Background(), taweretSettings, "test", func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return apierrors.NewTooManyRequests("throttled", 1)
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Fatalf("expected success after 3 attempts, got %v after %v attempts", err, attempts)
+	}
+
+	// retries are capped
+	attempts = 0
+	err = retryAPICall(context.Background(), taweretSettings, "test", func(ctx context.Context) error {
+		attempts++
+		return apierrors.NewServiceUnavailable("unavailable")
+	})
+	if err == nil || attempts != 4 {
+		t.Fatalf("expected an error after 4 attempts, got %v after %v attempts", err, attempts)
+	}
+
+	// permanent errors are not retried
+	attempts = 0
+	err = retryAPICall(context.Background(), taweretSettings, "test", func(ctx context.Context) error {
+		attempts++
+		return apierrors.NewForbidden(schema.GroupResource{Resource: "configmaps"}, "", fmt.Errorf("rbac"))
+	})
+	if err == nil || attempts != 1 {
+		t.Fatalf("expected an error after 1 attempt, got %v after %v attempts", err, attempts)
+	}
+}
+
+func TestGetBackupConfigsFromResources(t *testing.T) {
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{backupConfigGVR: "BackupConfigList"},
+		&unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "cr.taweret.io/v1alpha1",
+			"kind":       "BackupConfig",
+			"metadata":   map[string]interface{}{"name": "daily", "namespace": "kanister"},
+			"spec": map[string]interface{}{
+				"kanisterNamespace": "kanister",
+				"blueprintName":     "postgres-bp",
+				"retention":         map[string]interface{}{"backups": int64(7), "days": int64(7)},
+			},
+		}},
+	)
+	taweretMetrics := newMetrics()
+	taweretSettings := taweretsettings{configNamespaces: []string{"kanister"}, configSource: "crd", apiTimeout: defaultAPITimeout}
+
+	backupConfigs, err := getBackupConfigs(context.Background(), client, kubefake.NewSimpleClientset(), taweretMetrics, taweretSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backupConfigs) != 1 {
+		t.Fatalf("expected 1 backup config, got %v", len(backupConfigs))
+	}
+	backupConfig := backupConfigs[0]
+	if backupConfig.Name != "daily" || backupConfig.BlueprintName != "postgres-bp" || backupConfig.Retention.Backups != 7 || backupConfig.Retention.Days != 7 {
+		t.Fatalf("unexpected backup config %+v", backupConfig)
+	}
+}
+
+func TestGetBackupConfigsDefaultRetention(t *testing.T) {
+	clientSet := kubefake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: "daily", Namespace: "kanister"},
+		Data:       map[string]string{"backup-config.yaml": "name: daily\nkanisterNamespace: kanister\nretention:\n  days: 3\n"},
+	})
+	taweretMetrics := newMetrics()
+	taweretSettings := taweretsettings{configNamespaces: []string{"kanister"}, apiTimeout: defaultAPITimeout}
+	if err := yaml.UnmarshalStrict([]byte("{backups: 7, days: 7, minBackups: 2}"), &taweretSettings.defaultRetention); err != nil {
+		t.Fatal(err)
+	}
+
+	backupConfigs, err := getBackupConfigs(context.Background(), nil, clientSet, taweretMetrics, taweretSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backupConfigs) != 1 {
+		t.Fatalf("expected 1 backup config, got %v", len(backupConfigs))
+	}
+	// the days set by the backup config override the default
+	if retention := backupConfigs[0].Retention; retention.Backups != 7 || retention.Days != 3 || retention.MinBackups != 2 {
+		t.Fatalf("expected the default retention to be inherited field by field, got %+v", retention)
+	}
+}
+
+func TestDeletionOwnerReferences(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	clientSet := kubefake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: "daily", Namespace: "kanister", UID: "configmap-uid"},
+		Data:       map[string]string{"backup-config.yaml": "name: daily\nkanisterNamespace: kanister\nretention:\n  days: 3\n"},
+	})
+	taweretSettings := taweretsettings{configNamespaces: []string{"kanister"}, apiTimeout: defaultAPITimeout, deletionOwner: "config"}
+
+	backupConfigs, err := getBackupConfigs(context.Background(), nil, clientSet, newMetrics(), taweretSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backupConfigs) != 1 {
+		t.Fatalf("expected 1 backup config, got %v", len(backupConfigs))
+	}
+	client := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	if err := createDeletionActionSet(context.Background(), retention.Backup{Name: "backup-foo"}, "delete-backup-foo", client, gvr, taweretSettings, backupConfigs[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	deletionActionSet, err := client.Resource(gvr).Namespace("kanister").Get(context.Background(), "delete-backup-foo", v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []v1.OwnerReference{{APIVersion: "v1", Kind: "ConfigMap", Name: "daily", UID: "configmap-uid"}}
+	if owners := deletionActionSet.GetOwnerReferences(); !reflect.DeepEqual(owners, expected) {
+		t.Fatalf("expected owner references %v, got %v", expected, owners)
+	}
+
+	// owners in another namespace are not allowed
+	backupConfigs[0].KanisterNamespace = "other"
+	if owners := backupConfigs[0].deletionOwnerReferences(taweretSettings); owners != nil {
+		t.Fatalf("expected no owner references across namespaces, got %v", owners)
+	}
+	taweretSettings.deletionOwner = ""
+	backupConfigs[0].KanisterNamespace = "kanister"
+	if owners := backupConfigs[0].deletionOwnerReferences(taweretSettings); owners != nil {
+		t.Fatalf("expected no owner references if the deletion owner is unset, got %v", owners)
+	}
+}
+
+func TestGetBackupConfigsMalformedYAML(t *testing.T) {
+	clientSet := kubefake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{Name: "broken", Namespace: "kanister"},
+			Data:       map[string]string{"backup-config.yaml": "name: broken\nretention: [\n"},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{Name: "daily", Namespace: "kanister"},
+			Data:       map[string]string{"backup-config.yaml": "name: daily\nkanisterNamespace: kanister\nretention:\n  days: 3\n"},
+		},
+		// a typo in the key leaves the backup config out silently
+		&corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{Name: "weekly", Namespace: "kanister"},
+			Data:       map[string]string{"backup-configs.yaml": "name: weekly\nkanisterNamespace: kanister\nretention:\n  days: 21\n"},
+		},
+	)
+	taweretMetrics := newMetrics()
+	taweretSettings := taweretsettings{configNamespaces: []string{"kanister"}, apiTimeout: defaultAPITimeout}
+
+	backupConfigs, err := getBackupConfigs(context.Background(), nil, clientSet, taweretMetrics, taweretSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backupConfigs) != 1 || backupConfigs[0].Name != "daily" {
+		t.Fatalf("expected only the backup config daily, got %+v", backupConfigs)
+	}
+	if parseErrors := testutil.ToFloat64(taweretMetrics.configParseErrors.WithLabelValues("kanister", "broken")); parseErrors != 1 {
+		t.Fatalf("expected 1 parse error, got %v", parseErrors)
+	}
+	scanned := testutil.ToFloat64(taweretMetrics.configMapsScanned.WithLabelValues("kanister"))
+	loaded := testutil.ToFloat64(taweretMetrics.configsLoaded.WithLabelValues("kanister"))
+	if scanned != 3 || loaded != 1 {
+		t.Fatalf("expected 3 scanned configmaps and 1 loaded backup config, got %v and %v", scanned, loaded)
+	}
+}
+
+func TestGetBackupConfigsConfigKey(t *testing.T) {
+	clientSet := kubefake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{Name: "schedules", Namespace: "kanister"},
+			Data: map[string]string{"retention.yaml": "- name: daily\n  kanisterNamespace: kanister\n  retention:\n    days: 3\n" +
+				"- name: weekly\n  kanisterNamespace: kanister\n  retention:\n    days: 21\n"},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{Name: "monthly", Namespace: "kanister"},
+			Data:       map[string]string{"retention.yaml": "name: monthly\nkanisterNamespace: kanister\nretention:\n  days: 90\n"},
+		},
+		// the default key is not read once another key is set
+		&corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{Name: "yearly", Namespace: "kanister"},
+			Data:       map[string]string{"backup-config.yaml": "name: yearly\nkanisterNamespace: kanister\nretention:\n  days: 365\n"},
+		},
+	)
+	taweretSettings := taweretsettings{configNamespaces: []string{"kanister"}, apiTimeout: defaultAPITimeout, configKey: "retention.yaml"}
+
+	backupConfigs, err := getBackupConfigs(context.Background(), nil, clientSet, newMetrics(), taweretSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, backupConfig := range backupConfigs {
+		names = append(names, backupConfig.Name)
+		if backupConfig.Name == "weekly" && backupConfig.source.Name != "schedules" {
+			t.Fatalf("expected the backup config weekly to be sourced from the configmap schedules, got %v", backupConfig.source.Name)
+		}
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "daily,monthly,weekly" {
+		t.Fatalf("expected the backup configs daily, monthly and weekly, got %v", names)
+	}
+}
+
+func TestGetBackupConfigsAllowlist(t *testing.T) {
+	var configMaps []runtime.Object
+	for _, name := range []string{"team-a-daily", "team-a-weekly", "team-b-daily"} {
+		configMaps = append(configMaps, &corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{Name: name, Namespace: "kanister"},
+			Data:       map[string]string{"backup-config.yaml": "name: " + name + "\nkanisterNamespace: kanister\nretention:\n  backups: 7\n"},
+		})
+	}
+	clientSet := kubefake.NewSimpleClientset(configMaps...)
+	taweretMetrics := newMetrics()
+	taweretSettings := taweretsettings{configNamespaces: []string{"kanister"}, apiTimeout: defaultAPITimeout, configAllowlist: []string{"team-a-*"}, configDenylist: []string{"*-weekly"}}
+
+	backupConfigs, err := getBackupConfigs(context.Background(), nil, clientSet, taweretMetrics, taweretSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backupConfigs) != 1 || backupConfigs[0].Name != "team-a-daily" {
+		t.Fatalf("expected only the backup config team-a-daily, got %+v", backupConfigs)
+	}
+}
+
+func TestBackupConfigTiers(t *testing.T) {
+	var backupConfig backupconfig
+	if err := yaml.UnmarshalStrict([]byte("name: archive\nretention:\n  tiers:\n    - interval: 12h\n      keep: 2\n    - interval: 2w\n      keep: \"6\"\n"), &backupConfig); err != nil {
+		t.Fatal(err)
+	}
+	if err := backupConfig.validate(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []retention.Tier{{Interval: 12 * time.Hour, Keep: 2}, {Interval: 14 * 24 * time.Hour, Keep: 6}}
+	if tiers := backupConfig.policy().Tiers; !reflect.DeepEqual(tiers, expected) {
+		t.Fatalf("expected tiers %v, got %v", expected, tiers)
+	}
+
+	backupConfig.Retention.Tiers[0].Interval = "daily"
+	if err := backupConfig.validate(); err == nil {
+		t.Fatalf("expected a tier with a malformed interval to be rejected")
+	}
+}
+
+func TestCheckSchedules(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "ActionSetsList"},
+		newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "backup.sql.gz"),
+		newUnstructuredBackup("backup-bar", "kanister", "2022-01-01T02:03:04.52Z", "backup", "hourly", "complete", "backup.sql.gz"),
+		newUnstructuredBackup("delete-backup-foo", "kanister", "2022-01-02T02:03:04.52Z", "delete", "", "complete", ""),
+	)
+	taweretMetrics := newMetrics()
+	taweretSettings := taweretsettings{apiTimeout: defaultAPITimeout}
+
+	var backupConfigs []backupconfig
+	for _, name := range []string{"daily", "weekly"} {
+		var backupConfig backupconfig
+		backupConfig.Name = name
+		backupConfig.KanisterNamespace = "kanister"
+		backupConfigs = append(backupConfigs, backupConfig)
+	}
+	checkSchedules(context.Background(), client, gvr, taweretMetrics, taweretSettings, backupConfigs)
+
+	if found := testutil.ToFloat64(taweretMetrics.scheduleFound.WithLabelValues(backupConfigs[0].metricLabels()...)); found != 1 {
+		t.Errorf("expected the schedule of the daily backup config to be found, got %v", found)
+	}
+	if found := testutil.ToFloat64(taweretMetrics.scheduleFound.WithLabelValues(backupConfigs[1].metricLabels()...)); found != 0 {
+		t.Errorf("expected the schedule of the weekly backup config not to be found, got %v", found)
+	}
+	if unmanaged := testutil.ToFloat64(taweretMetrics.unmanagedSchedules.WithLabelValues("kanister")); unmanaged != 1 {
+		t.Errorf("expected the hourly schedule to be unmanaged, got %v unmanaged schedules", unmanaged)
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "ActionSetsList"},
+		newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "backup.sql.gz"),
+	)
+	clientSet := kubefake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: "daily", Namespace: "kanister"},
+		Data:       map[string]string{"backup-config.yaml": "name: daily\nkanisterNamespace: kanister\nretention:\n  backups: 7\n"},
+	})
+	taweretMetrics := newMetrics()
+	taweretSettings := taweretsettings{configNamespaces: []string{"kanister"}, apiTimeout: defaultAPITimeout, maxConcurrency: 1, dryRun: true}
+
+	if !runOnce(context.Background(), client, gvr, clientSet, taweretMetrics, taweretSettings, &taweretstatus{}) {
+		t.Fatalf("expected the single evaluation to succeed")
+	}
+
+	// a failing evaluation of a backup config fails the single evaluation
+	client.PrependReactor("list", "actionsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("connection refused")
+	})
+	if runOnce(context.Background(), client, gvr, clientSet, taweretMetrics, taweretSettings, &taweretstatus{}) {
+		t.Fatalf("expected the single evaluation to fail")
+	}
+}
+
+func TestPushMetrics(t *testing.T) {
+	var pushedPath, pushedMethod string
+	pushgateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushedPath, pushedMethod = r.URL.Path, r.Method
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer pushgateway.Close()
+
+	taweretMetrics := newMetrics()
+	taweretSettings := taweretsettings{apiTimeout: defaultAPITimeout, pushgatewayURL: pushgateway.URL, pushgatewayInstance: "taweret-0"}
+	taweretMetrics.push(taweretSettings)
+	if pushedMethod != http.MethodPut || pushedPath != "/metrics/job/taweret/instance/taweret-0" {
+		t.Fatalf("expected the metrics to be put to the grouping key of the instance, got %v %v", pushedMethod, pushedPath)
+	}
+
+	// a failed push is only counted
+	pushgateway.Close()
+	taweretMetrics.push(taweretSettings)
+	if pushErrors := testutil.ToFloat64(taweretMetrics.pushErrors); pushErrors != 1 {
+		t.Fatalf("expected 1 push error, got %v", pushErrors)
+	}
+}
+
+func TestRunEvaluationsSummary(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "ActionSetsList"},
+		newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "backup.sql.gz"),
+		newUnstructuredBackup("backup-bar", "kanister", "2022-01-02T02:03:04.52Z", "backup", "daily", "complete", "backup.sql.gz"),
+	)
+	clientSet := kubefake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: "daily", Namespace: "kanister"},
+		Data:       map[string]string{"backup-config.yaml": "name: daily\nkanisterNamespace: kanister\nretention:\n  backups: 1\n  years: 100\n"},
+	})
+	taweretMetrics := newMetrics()
+	taweretSettings := taweretsettings{configNamespaces: []string{"kanister"}, apiTimeout: defaultAPITimeout, maxConcurrency: 1, dryRun: true}
+
+	// in dry run mode, the deletable backup is skipped
+	summary, err := runEvaluations(context.Background(), client, gvr, clientSet, taweretMetrics, taweretSettings, &taweretstatus{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (evaluationsummary{Configs: 1, Backups: 2, Skipped: 1}); !reflect.DeepEqual(summary, expected) {
+		t.Fatalf("expected summary %+v, got %+v", expected, summary)
+	}
+
+	client.PrependReactor("list", "actionsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("connection refused")
+	})
+	summary, err = runEvaluations(context.Background(), client, gvr, clientSet, taweretMetrics, taweretSettings, &taweretstatus{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Errors != 1 || len(summary.Failures) != 1 || summary.Failures[0].BackupConfig != "daily" || summary.Failures[0].Action != "list" {
+		t.Fatalf("expected the failed list of the daily backup config in the summary, got %+v", summary)
+	}
+	marshalled, err := json.Marshal(summary.Failures[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(marshalled), "connection refused") {
+		t.Fatalf("expected the error message in the marshalled failure, got %s", marshalled)
+	}
+}
+
+func TestRunEvaluationsMaxTotalBackups(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	newClient := func() *fake.FakeDynamicClient {
+		client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+			map[schema.GroupVersionResource]string{gvr: "ActionSetsList"},
+			newUnstructuredBackup("daily-a", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "daily-a.sql.gz"),
+			newUnstructuredBackup("daily-b", "kanister", "2022-01-03T02:03:04.52Z", "backup", "daily", "complete", "daily-b.sql.gz"),
+			newUnstructuredBackup("daily-c", "kanister", "2022-01-05T02:03:04.52Z", "backup", "daily", "complete", "daily-c.sql.gz"),
+			newUnstructuredBackup("weekly-a", "kanister", "2022-01-02T02:03:04.52Z", "backup", "weekly", "complete", "weekly-a.sql.gz"),
+			newUnstructuredBackup("weekly-b", "kanister", "2022-01-04T02:03:04.52Z", "backup", "weekly", "complete", "weekly-b.sql.gz"),
+		)
+		// let Kanister complete every deletion actionset
+		client.PrependReactor("create", "actionsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			deletionActionSet := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+			if err := unstructured.SetNestedField(deletionActionSet.Object, "complete", "status", "state"); err != nil {
+				t.Fatal(err)
+			}
+			return false, nil, nil
+		})
+		return client
+	}
+	remainingBackups := func(client *fake.FakeDynamicClient) []string {
+		actionsets, err := client.Resource(gvr).Namespace("kanister").List(context.Background(), v1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var remaining []string
+		for _, actionset := range actionsets.Items {
+			if !strings.HasPrefix(actionset.GetName(), "delete-") {
+				remaining = append(remaining, actionset.GetName())
+			}
+		}
+		sort.Strings(remaining)
+		return remaining
+	}
+	var configMaps []runtime.Object
+	for _, name := range []string{"daily", "weekly"} {
+		configMaps = append(configMaps, &corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{Name: name, Namespace: "kanister"},
+			Data:       map[string]string{"backup-config.yaml": "name: " + name + "\nkanisterNamespace: kanister\nretention:\n  backups: 10\n  years: 100\n"},
+		})
+	}
+	clientSet := kubefake.NewSimpleClientset(configMaps...)
+	taweretSettings := taweretsettings{configNamespaces: []string{"kanister"}, apiTimeout: defaultAPITimeout, maxConcurrency: 1, maxTotalBackups: 2, deletionPollInterval: time.Millisecond, deletionTimeout: time.Second}
+
+	client := newClient()
+	summary, err := runEvaluations(context.Background(), client, gvr, clientSet, newMetrics(), taweretSettings, &taweretstatus{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the newest backup of each backup config is kept, so only 3 of the 5 backups can be deleted
+	if summary.Deleted != 3 {
+		t.Fatalf("expected 3 deleted backups, got %+v", summary)
+	}
+	if remaining, expected := remainingBackups(client), []string{"daily-c", "weekly-b"}; !reflect.DeepEqual(remaining, expected) {
+		t.Fatalf("expected the backups %v to remain, got %v", expected, remaining)
+	}
+
+	// with a deletion delay, the backups over the cap are held back like the deletable backups of a backup config
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	taweretSettings.deletionDelay = time.Hour
+	taweretSettings.clock = func() time.Time { return now }
+	client = newClient()
+	taweretMetrics := newMetrics()
+	summary, err = runEvaluations(context.Background(), client, gvr, clientSet, taweretMetrics, taweretSettings, &taweretstatus{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Deleted != 0 || summary.Skipped != 3 {
+		t.Fatalf("expected 3 held back backups, got %+v", summary)
+	}
+	if held := testutil.ToFloat64(taweretMetrics.heldDeletions.WithLabelValues("daily", "kanister", "")); held != 2 {
+		t.Fatalf("expected 2 held deletions of the daily backup config, got %v", held)
+	}
+	// the held backups stay marked while they are retained by their backup config, and are deleted after the deletion delay
+	now = now.Add(30 * time.Minute)
+	if summary, err = runEvaluations(context.Background(), client, gvr, clientSet, taweretMetrics, taweretSettings, &taweretstatus{}, ""); err != nil || summary.Deleted != 0 {
+		t.Fatalf("expected no deletions within the deletion delay, got %+v, %v", summary, err)
+	}
+	now = now.Add(time.Hour)
+	if summary, err = runEvaluations(context.Background(), client, gvr, clientSet, taweretMetrics, taweretSettings, &taweretstatus{}, ""); err != nil || summary.Deleted != 3 {
+		t.Fatalf("expected 3 deleted backups after the deletion delay, got %+v, %v", summary, err)
+	}
+	if remaining, expected := remainingBackups(client), []string{"daily-c", "weekly-b"}; !reflect.DeepEqual(remaining, expected) {
+		t.Fatalf("expected the backups %v to remain, got %v", expected, remaining)
+	}
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	if tlsConfig, err := serverTLSConfig(taweretsettings{}); err != nil || tlsConfig != nil {
+		t.Fatalf("expected no TLS config without a client CA, got %v, %v", tlsConfig, err)
+	}
+
+	// the certificate of a test server serves as client CA
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	clientCA := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(clientCA, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := serverTLSConfig(taweretsettings{tlsClientCA: clientCA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert || tlsConfig.ClientCAs == nil {
+		t.Fatalf("expected client certificates to be required and verified, got %+v", tlsConfig)
+	}
+
+	if err := os.WriteFile(clientCA, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := serverTLSConfig(taweretsettings{tlsClientCA: clientCA}); err == nil {
+		t.Fatalf("expected a client CA without certificates to be rejected")
+	}
+}
+
+func TestStartEvaluationSkipsOverlappingRuns(t *testing.T) {
+	taweretStatus := &taweretstatus{}
+	taweretMetrics := taweretmetrics{evaluationsSkipped: prometheus.NewCounter(prometheus.CounterOpts{Name: "evaluations_skipped_total"})}
+
+	// a running evaluation
+	taweretStatus.evaluationMutex.Lock()
+	defer taweretStatus.evaluationMutex.Unlock()
+
+	startEvaluation(nil, schema.GroupVersionResource{}, nil, taweretMetrics, taweretsettings{}, taweretStatus)
+	if skipped := testutil.ToFloat64(taweretMetrics.evaluationsSkipped); skipped != 1 {
+		t.Fatalf("expected 1 skipped evaluation, got %v", skipped)
+	}
+}
+
+func TestEvaluateBackupsPaused(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "ActionSetsList"},
+		newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "backup.sql.gz"),
+	)
+	taweretMetrics := newMetrics()
+
+	enabled := false
+	var backupConfig backupconfig
+	backupConfig.Name = "daily"
+	backupConfig.KanisterNamespace = "kanister"
+	backupConfig.Enabled = &enabled
+
+	evaluateBackups(context.Background(), client, gvr, taweretMetrics, taweretsettings{apiTimeout: defaultAPITimeout}, backupConfig, newDeletedLocations())
+	if len(client.Actions()) != 0 {
+		t.Fatalf("expected no API calls for a paused backup config, got %v", client.Actions())
+	}
+	if paused := testutil.ToFloat64(taweretMetrics.pausedConfigs.WithLabelValues(backupConfig.metricLabels()...)); paused != 1 {
+		t.Fatalf("expected the backup config to be reported as paused, got %v", paused)
+	}
+}
+
+func TestEvaluateBackupsOldestDeletableAge(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "ActionSetsList"},
+		newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "backup.sql.gz"),
+		newUnstructuredBackup("backup-bar", "kanister", "2022-01-02T02:03:04.52Z", "backup", "daily", "complete", "backup.sql.gz"),
+		newUnstructuredBackup("backup-baz", "kanister", "2022-01-03T02:03:04.52Z", "backup", "daily", "complete", "backup.sql.gz"),
+	)
+	taweretMetrics := newMetrics()
+
+	var backupConfig backupconfig
+	backupConfig.Name = "daily"
+	backupConfig.KanisterNamespace = "kanister"
+	backupConfig.Retention.Backups = 1
+	backupConfig.Retention.Years = 100
+
+	// in dry run mode the deletable backups are never deleted
+	evaluateBackups(context.Background(), client, gvr, taweretMetrics, taweretsettings{apiTimeout: defaultAPITimeout, dryRun: true}, backupConfig, newDeletedLocations())
+	oldest, _ := time.Parse(time.RFC3339, "2022-01-01T02:03:04.52Z")
+	age := testutil.ToFloat64(taweretMetrics.oldestDeletableAge.WithLabelValues(backupConfig.metricLabels()...))
+	if expected := time.Since(oldest).Seconds(); math.Abs(age-expected) > 60 {
+		t.Fatalf("expected the oldest deletable backup to be %v seconds old, got %v", expected, age)
+	}
+
+	// without deletable backups the age is reset
+	backupConfig.Retention.Backups = 3
+	evaluateBackups(context.Background(), client, gvr, taweretMetrics, taweretsettings{apiTimeout: defaultAPITimeout, dryRun: true}, backupConfig, newDeletedLocations())
+	if age := testutil.ToFloat64(taweretMetrics.oldestDeletableAge.WithLabelValues(backupConfig.metricLabels()...)); age != 0 {
+		t.Fatalf("expected no deletable backups to be reported as 0, got %v", age)
+	}
+}
+
+func TestEvaluateBackupsReportOnly(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "ActionSetsList"},
+		newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "backup.sql.gz"),
+		newUnstructuredBackup("backup-bar", "kanister", "2022-01-02T02:03:04.52Z", "backup", "daily", "complete", "backup.sql.gz"),
+		newUnstructuredBackup("backup-baz", "kanister", "2022-01-03T02:03:04.52Z", "backup", "daily", "complete", "backup.sql.gz"),
+	)
+	taweretMetrics := newMetrics()
+
+	var backupConfig backupconfig
+	backupConfig.Name = "daily"
+	backupConfig.KanisterNamespace = "kanister"
+	backupConfig.Retention.Backups = 1
+	backupConfig.Retention.Years = 100
+
+	tests := []struct {
+		name             string
+		reportOnly       bool
+		configReportOnly bool
+	}{
+		{name: "global", reportOnly: true},
+		{name: "backup config", configReportOnly: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client.ClearActions()
+			backupConfig.ReportOnly = test.configReportOnly
+			// the deletion delay would patch the actionsets, unless the backups are only reported
+			taweretSettings := taweretsettings{apiTimeout: defaultAPITimeout, reportOnly: test.reportOnly, deletionDelay: time.Hour}
+
+			summary, _ := evaluateBackups(context.Background(), client, gvr, taweretMetrics, taweretSettings, backupConfig, newDeletedLocations())
+			if summary.Deleted != 0 || summary.Skipped != 2 {
+				t.Fatalf("expected 2 skipped and no deleted backups, got %+v", summary)
+			}
+			for _, action := range client.Actions() {
+				if action.GetVerb() != "list" {
+					t.Fatalf("expected only list calls, got %v %v", action.GetVerb(), action.GetResource())
+				}
+			}
+			if wouldDelete := testutil.ToFloat64(taweretMetrics.backupsWouldDelete.WithLabelValues(backupConfig.metricLabels()...)); wouldDelete != 2 {
+				t.Fatalf("expected 2 backups reported as would delete, got %v", wouldDelete)
+			}
+		})
+	}
+}
+
+func TestEvaluateBackupsDeletionDelay(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "ActionSetsList"},
+		newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "backup.sql.gz"),
+		newUnstructuredBackup("backup-bar", "kanister", "2022-01-02T02:03:04.52Z", "backup", "daily", "complete", "backup.sql.gz"),
+		newUnstructuredBackup("backup-baz", "kanister", "2022-01-03T02:03:04.52Z", "backup", "daily", "complete", "backup.sql.gz"),
+	)
+	taweretMetrics := newMetrics()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// soft delete marks the due backups as expired, so that no deletion actionsets have to be completed
+	taweretSettings := taweretsettings{apiTimeout: defaultAPITimeout, softDelete: true, deletionDelay: time.Hour, clock: func() time.Time { return now }}
+
+	var backupConfig backupconfig
+	backupConfig.Name = "daily"
+	backupConfig.KanisterNamespace = "kanister"
+	backupConfig.Retention.Backups = 1
+	backupConfig.Retention.Years = 100
+
+	annotations := func(name string) map[string]string {
+		actionset, err := client.Resource(gvr).Namespace("kanister").Get(context.Background(), name, v1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return actionset.GetAnnotations()
+	}
+
+	// the newly deletable backups are marked and held back
+	summary, _ := evaluateBackups(context.Background(), client, gvr, taweretMetrics, taweretSettings, backupConfig, newDeletedLocations())
+	if summary.Skipped != 2 {
+		t.Fatalf("expected 2 skipped backups, got %+v", summary)
+	}
+	if held := testutil.ToFloat64(taweretMetrics.heldDeletions.WithLabelValues(backupConfig.metricLabels()...)); held != 2 {
+		t.Fatalf("expected 2 held deletions, got %v", held)
+	}
+	if annotations("backup-foo")[deletableSinceAnnotation] != "2024-01-01T00:00:00Z" || annotations("backup-foo")[expiredAnnotation] != "" {
+		t.Fatalf("expected backup-foo to be marked as deletable but not expired, got %v", annotations("backup-foo"))
+	}
+
+	// reverting the backup count releases the held backups
+	backupConfig.Retention.Backups = 3
+	evaluateBackups(context.Background(), client, gvr, taweretMetrics, taweretSettings, backupConfig, newDeletedLocations())
+	if _, ok := annotations("backup-foo")[deletableSinceAnnotation]; ok {
+		t.Fatalf("expected the retained backup-foo to be released, got %v", annotations("backup-foo"))
+	}
+
+	// marked again, the backups are only deleted once the deletion delay has passed
+	backupConfig.Retention.Backups = 1
+	evaluateBackups(context.Background(), client, gvr, taweretMetrics, taweretSettings, backupConfig, newDeletedLocations())
+	now = now.Add(30 * time.Minute)
+	evaluateBackups(context.Background(), client, gvr, taweretMetrics, taweretSettings, backupConfig, newDeletedLocations())
+	if expired := annotations("backup-foo")[expiredAnnotation]; expired != "" {
+		t.Fatalf("expected backup-foo to be held back within the deletion delay, got expired %v", expired)
+	}
+	now = now.Add(time.Hour)
+	evaluateBackups(context.Background(), client, gvr, taweretMetrics, taweretSettings, backupConfig, newDeletedLocations())
+	if annotations("backup-foo")[expiredAnnotation] == "" || annotations("backup-bar")[expiredAnnotation] == "" {
+		t.Fatalf("expected backup-foo and backup-bar to be expired after the deletion delay")
+	}
+	if annotations("backup-baz")[deletableSinceAnnotation] != "" {
+		t.Fatalf("expected the retained backup-baz not to be marked, got %v", annotations("backup-baz"))
+	}
+}
+
+func TestEvaluateBackupsSpans(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "ActionSetsList"},
+		newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "backup.sql.gz"),
+		newUnstructuredBackup("backup-bar", "kanister", "2022-01-02T02:03:04.52Z", "backup", "daily", "complete", "backup.sql.gz"),
+	)
+	// the tracer delegates to the first tracer provider installed
+	spanRecorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+
+	var backupConfig backupconfig
+	backupConfig.Name = "daily"
+	backupConfig.KanisterNamespace = "kanister"
+	backupConfig.Retention.Backups = 1
+	backupConfig.Retention.Years = 100
+
+	evaluateBackups(context.Background(), client, gvr, newMetrics(), taweretsettings{apiTimeout: defaultAPITimeout, dryRun: true}, backupConfig, newDeletedLocations())
+	spans := spanRecorder.Ended()
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name())
+	}
+	// the spans end innermost first
+	if strings.Join(names, ",") != "listBackups,deleteBackup,evaluateBackups" {
+		t.Fatalf("expected the spans listBackups, deleteBackup and evaluateBackups, got %v", names)
+	}
+	for _, span := range spans[:2] {
+		if span.Parent().SpanID() != spans[2].SpanContext().SpanID() {
+			t.Errorf("expected %v to be a child of evaluateBackups", span.Name())
+		}
+	}
+}
+
+func TestDeletionTimesSince(t *testing.T) {
+	lastDeletions := &deletiontimes{times: make(map[string]time.Time)}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// the first evaluation starts the measurement even if backups are held back
+	if since := lastDeletions.since("daily", false, start); since != 0 {
+		t.Fatalf("expected 0 seconds in the first evaluation, got %v", since)
+	}
+	if since := lastDeletions.since("daily", false, start.Add(time.Hour)); since != 3600 {
+		t.Fatalf("expected 3600 seconds while backups are held back, got %v", since)
+	}
+	if since := lastDeletions.since("daily", true, start.Add(2*time.Hour)); since != 0 {
+		t.Fatalf("expected a deletion to reset the time, got %v", since)
+	}
+}
+
+func TestSetMetricsLabels(t *testing.T) {
+	taweretMetrics := newMetrics()
+	var backupConfig backupconfig
+	backupConfig.Name = "daily"
+	backupConfig.KanisterNamespace = "kanister"
+	backupConfig.BlueprintName = "postgres-bp"
+	backupConfig.Retention.Backups = 7
+	backupConfig.Retention.Days = 14
+
+	taweretMetrics.setMetrics([]retention.Backup{{Name: "backup-foo", Status: "complete"}}, backupConfig, retention.Counts{Failed: 2})
+	if completed := testutil.ToFloat64(taweretMetrics.backupCount.WithLabelValues("daily", "kanister", "postgres-bp", "completed")); completed != 1 {
+		t.Errorf("expected 1 completed backup, got %v", completed)
+	}
+	if failed := testutil.ToFloat64(taweretMetrics.backupCount.WithLabelValues("daily", "kanister", "postgres-bp", "failed")); failed != 2 {
+		t.Errorf("expected 2 failed backups, got %v", failed)
+	}
+	for parameter, expected := range map[string]float64{"backups": 7, "days": 14, "hours": 0} {
+		if value := testutil.ToFloat64(taweretMetrics.retentionPolicy.WithLabelValues("daily", "kanister", "postgres-bp", parameter)); value != expected {
+			t.Errorf("expected retention %v of %v, got %v", parameter, expected, value)
+		}
+	}
+}
+
+func TestEvaluateBackupsMatched(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "ActionSetsList"},
+		newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "backup.sql.gz"),
+	)
+	taweretMetrics := newMetrics()
+	taweretSettings := taweretsettings{apiTimeout: defaultAPITimeout, dryRun: true}
+
+	for _, name := range []string{"daily", "weekly"} {
+		var backupConfig backupconfig
+		backupConfig.Name = name
+		backupConfig.KanisterNamespace = "kanister"
+		backupConfig.Retention.Backups = 7
+		evaluateBackups(context.Background(), client, gvr, taweretMetrics, taweretSettings, backupConfig, newDeletedLocations())
+	}
+	if matched := testutil.ToFloat64(taweretMetrics.matchedConfigs.WithLabelValues("daily", "kanister", "")); matched != 1 {
+		t.Fatalf("expected the daily backup config to be reported as matched, got %v", matched)
+	}
+	if matched := testutil.ToFloat64(taweretMetrics.matchedConfigs.WithLabelValues("weekly", "kanister", "")); matched != 0 {
+		t.Fatalf("expected the weekly backup config to be reported as unmatched, got %v", matched)
+	}
+}
+
+func TestDeleteBackupFailedDeletion(t *testing.T) {
+	gvr := schema.GroupVersionResource{
+		Group:    "cr.kanister.io",
+		Version:  "v1alpha1",
+		Resource: "actionsets",
+	}
+	scheme := runtime.NewScheme()
+
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(scheme,
+		map[schema.GroupVersionResource]string{
+			{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}: "ActionSetsList",
+		},
+		newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "pg_backups/renku/renku-postgresql/2022-01-01T02:03:04.52Z/backup.sql.gz"),
+	)
+	// let Kanister fail every deletion actionset
+	client.PrependReactor("create", "actionsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		deletionActionSet := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+		if err := unstructured.SetNestedField(deletionActionSet.Object, "failed", "status", "state"); err != nil {
+			t.Fatal(err)
+		}
+		return false, nil, nil
+	})
+
+	var backupConfig backupconfig
+	backupConfig.KanisterNamespace = "kanister"
+	backupConfig.Name = "daily"
+	taweretSettings := taweretsettings{apiTimeout: defaultAPITimeout, deletionPollInterval: time.Millisecond, deletionTimeout: time.Second}
+
+	taweretMetrics := newMetrics()
+
+	_, err := deleteBackup(context.Background(), retention.Backup{Name: "backup-foo", Schedule: "daily", Status: "complete"}, client, gvr, taweretMetrics, taweretSettings, backupConfig)
+	if err == nil {
+		t.Fatal("expected an error for a failed deletion actionset")
+	}
+	if failures := testutil.ToFloat64(taweretMetrics.deletionFailures.WithLabelValues(backupConfig.metricLabels("backup-foo")...)); failures != 1 {
+		t.Fatalf("expected 1 deletion failure, got %v", failures)
+	}
+	if _, err := client.Resource(gvr).Namespace("kanister").Get(context.Background(), "backup-foo", v1.GetOptions{}); err != nil {
+		t.Fatalf("expected backup actionset to be kept, got %v", err)
+	}
+}
+
+func TestDeleteBackupVerification(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	tests := []struct {
+		name       string
+		artifacts  map[string]interface{}
+		unverified float64
+	}{
+		{name: "confirmed", artifacts: map[string]interface{}{"deleteResult": map[string]interface{}{"keyValue": map[string]interface{}{"deleted": "true"}}}, unverified: 0},
+		{name: "not confirmed", artifacts: map[string]interface{}{"deleteResult": map[string]interface{}{"keyValue": map[string]interface{}{"deleted": "false"}}}, unverified: 1},
+		{name: "missing", artifacts: map[string]interface{}{}, unverified: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+				map[schema.GroupVersionResource]string{gvr: "ActionSetsList"},
+				newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "backup.sql.gz"),
+			)
+			// let Kanister complete every deletion actionset with the artifacts of the test
+			client.PrependReactor("create", "actionsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				deletionActionSet := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+				deletionActionSet.Object["status"] = map[string]interface{}{
+					"state":   "complete",
+					"actions": []interface{}{map[string]interface{}{"artifacts": test.artifacts}},
+				}
+				return false, nil, nil
+			})
+
+			backupConfig := backupconfig{Name: "daily", KanisterNamespace: "kanister", DeletionVerificationPath: "deleteResult.deleted"}
+			taweretSettings := taweretsettings{apiTimeout: defaultAPITimeout, deletionPollInterval: time.Millisecond, deletionTimeout: time.Second}
+			taweretMetrics := newMetrics()
+
+			deleted, err := deleteBackup(context.Background(), retention.Backup{Name: "backup-foo", Schedule: "daily", Status: "complete"}, client, gvr, taweretMetrics, taweretSettings, backupConfig)
+			if err != nil || !deleted {
+				t.Fatalf("expected the backup to be deleted, got deleted %v and error %v", deleted, err)
+			}
+			if unverified := testutil.ToFloat64(taweretMetrics.unverifiedDeletions.WithLabelValues(backupConfig.metricLabels()...)); unverified != test.unverified {
+				t.Fatalf("expected %v unverified deletions, got %v", test.unverified, unverified)
+			}
+		})
+	}
+}
+
+func TestDeleteBackupActionSetWaitsForDeletion(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "ActionSetsList"},
+		newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "backup.sql.gz"),
+	)
+	// a finalizer holds the backup actionset for the first two gets, or for good once it is stuck
+	gets, finalizerStuck := 0, false
+	client.PrependReactor("delete", "actionsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, nil
+	})
+	client.PrependReactor("get", "actionsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		if gets < 3 || finalizerStuck {
+			return false, nil, nil
+		}
+		return true, nil, apierrors.NewNotFound(gvr.GroupResource(), action.(k8stesting.GetAction).GetName())
+	})
+	backupConfig := backupconfig{Name: "daily", KanisterNamespace: "kanister"}
+	taweretSettings := taweretsettings{apiTimeout: defaultAPITimeout, deletionPollInterval: time.Millisecond, backupDeletionTimeout: time.Second}
+
+	if err := deleteBackupActionSet(context.Background(), retention.Backup{Name: "backup-foo"}, client, gvr, newMetrics(), taweretSettings, backupConfig); err != nil {
+		t.Fatal(err)
+	}
+	if gets != 3 {
+		t.Fatalf("expected the backup actionset to be polled until it is gone, got %v gets", gets)
+	}
+
+	// a backup actionset which is never gone is awaited until the timeout
+	finalizerStuck = true
+	taweretSettings.backupDeletionTimeout = 10 * time.Millisecond
+	if err := deleteBackupActionSet(context.Background(), retention.Backup{Name: "backup-foo"}, client, gvr, newMetrics(), taweretSettings, backupConfig); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteBackupDeletionSlots(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "ActionSetsList"},
+		newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "backup.sql.gz"),
+	)
+	client.PrependReactor("create", "actionsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		deletionActionSet := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+		if err := unstructured.SetNestedField(deletionActionSet.Object, "complete", "status", "state"); err != nil {
+			t.Fatal(err)
+		}
+		return false, nil, nil
+	})
+	backupConfig := backupconfig{Name: "daily", KanisterNamespace: "kanister"}
+	taweretSettings := taweretsettings{apiTimeout: defaultAPITimeout, deletionPollInterval: time.Millisecond, deletionTimeout: time.Minute, deletionActionSetRetention: time.Hour, deletionSlots: make(chan struct{}, 1)}
+	aBackup := retention.Backup{Name: "backup-foo"}
+
+	// while the only slot is held by another deletion, the deletion waits and does not touch any actionset
+	taweretSettings.deletionSlots <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := deleteBackup(ctx, aBackup, client, gvr, newMetrics(), taweretSettings, backupConfig); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deletion to wait for a slot until the context is done, got %v", err)
+	}
+	if len(client.Actions()) != 0 {
+		t.Fatalf("expected no API calls while waiting for a slot, got %v", client.Actions())
+	}
+
+	// once the slot is free, the deletion goes ahead and releases the slot again
+	<-taweretSettings.deletionSlots
+	deleted, err := deleteBackup(context.Background(), aBackup, client, gvr, newMetrics(), taweretSettings, backupConfig)
+	if err != nil || !deleted {
+		t.Fatalf("expected the backup to be deleted, got %v, %v", deleted, err)
+	}
+	if len(taweretSettings.deletionSlots) != 0 {
+		t.Fatalf("expected the deletion slot to be released, %v slots held", len(taweretSettings.deletionSlots))
+	}
+}
+
+func TestDeletionWindow(t *testing.T) {
+	tests := []struct {
+		window string
+		at     string
+		inside bool
+	}{
+		{window: "", at: "12:00", inside: true},
+		{window: "02:00-04:00", at: "01:59", inside: false},
+		{window: "02:00-04:00", at: "02:00", inside: true},
+		{window: "02:00-04:00", at: "03:59", inside: true},
+		{window: "02:00-04:00", at: "04:00", inside: false},
+		{window: "22:00-02:00", at: "23:30", inside: true},
+		{window: "22:00-02:00", at: "01:00", inside: true},
+		{window: "22:00-02:00", at: "12:00", inside: false},
+	}
+	for _, test := range tests {
+		var backupConfig backupconfig
+		backupConfig.DeletionWindow = test.window
+		// the window is read in the time zone of the backup config, 02:30 in Zurich is 00:30 in UTC in summer
+		backupConfig.Timezone = "Europe/Zurich"
+		at, err := time.ParseInLocation("2006-01-02 15:04", "2024-07-01 "+test.at, backupConfig.location())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if inside := backupConfig.inDeletionWindow(at.UTC()); inside != test.inside {
+			t.Errorf("window %q at %v: expected inside %v, got %v", test.window, test.at, test.inside, inside)
+		}
+	}
+
+	for _, window := range []string{"02:00", "02:00-02:00", "2am-4am", "25:00-04:00"} {
+		var backupConfig backupconfig
+		backupConfig.Retention.Backups = 1
+		backupConfig.DeletionWindow = window
+		if err := backupConfig.validate(); err == nil {
+			t.Errorf("expected the deletion window %q to be rejected", window)
+		}
+	}
+}
+
+func TestDeleteOldestBackupsDeletionWindow(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "ActionSetsList"},
+		newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "backup.sql.gz"),
+	)
+	var backupConfig backupconfig
+	backupConfig.Name = "daily"
+	backupConfig.KanisterNamespace = "kanister"
+	backupConfig.DeletionWindow = "02:00-04:00"
+	taweretMetrics := newMetrics()
+	taweretSettings := taweretsettings{apiTimeout: defaultAPITimeout, clock: func() time.Time { return time.Date(2024, time.July, 1, 12, 0, 0, 0, time.UTC) }}
+
+	backups := []retention.Backup{{Name: "backup-foo", Status: "complete", BackupLocation: "backup.sql.gz"}}
+	deleted, err := deleteOldestBackups(context.Background(), backups, 1, newDeletedLocations(), client, gvr, taweretMetrics, taweretSettings, backupConfig)
+	if err != nil || deleted != 0 || len(client.Actions()) != 0 {
+		t.Fatalf("expected the deletion to be deferred, got %v deleted, error %v and actions %v", deleted, err, client.Actions())
+	}
+	if deferred := testutil.ToFloat64(taweretMetrics.deferredDeletions.WithLabelValues(backupConfig.metricLabels()...)); deferred != 1 {
+		t.Fatalf("expected 1 deferred deletion, got %v", deferred)
+	}
+}
+
+func TestDeleteOldestBackupsSharedLocation(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	location := "pg_backups/renku/renku-postgresql/2022-01-01T02:03:04.52Z/backup.sql.gz"
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "ActionSetsList"},
+		newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", location),
+		newUnstructuredBackup("backup-foo-recreated", "kanister", "2022-01-01T02:03:05.52Z", "backup", "daily", "complete", location),
+	)
+	var createdDeletions []string
+	client.PrependReactor("create", "actionsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		deletionActionSet := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+		createdDeletions = append(createdDeletions, deletionActionSet.GetName())
+		if err := unstructured.SetNestedField(deletionActionSet.Object, "complete", "status", "state"); err != nil {
+			t.Fatal(err)
+		}
+		return false, nil, nil
+	})
+
+	var backupConfig backupconfig
+	backupConfig.KanisterNamespace = "kanister"
+	backupConfig.Name = "daily"
+	taweretSettings := taweretsettings{apiTimeout: defaultAPITimeout, deletionPollInterval: time.Millisecond, deletionTimeout: time.Second}
+
+	backups := []retention.Backup{
+		{Name: "backup-foo", Schedule: "daily", Status: "complete", Time: time.Date(2022, 1, 1, 2, 3, 4, 0, time.UTC), BackupLocation: location},
+		{Name: "backup-foo-recreated", Schedule: "daily", Status: "complete", Time: time.Date(2022, 1, 1, 2, 3, 5, 0, time.UTC), BackupLocation: location},
+	}
+	deleted, err := deleteOldestBackups(context.Background(), backups, 2, newDeletedLocations(), client, gvr, newMetrics(), taweretSettings, backupConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Fatalf("expected 2 deleted backups, got %v", deleted)
+	}
+	if !reflect.DeepEqual(createdDeletions, []string{"delete-backup-foo"}) {
+		t.Fatalf("expected a single deletion actionset for the shared backup location, got %v", createdDeletions)
+	}
+	if _, err := client.Resource(gvr).Namespace("kanister").Get(context.Background(), "backup-foo-recreated", v1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected the backup actionset sharing the backup location to be deleted, got %v", err)
+	}
+}
+
+func TestRunEvaluationsSharedLocation(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	// the backups of two backup configs share the backup location of their oldest backups
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "ActionSetsList"},
+		newUnstructuredBackup("daily-a", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "shared.sql.gz"),
+		newUnstructuredBackup("daily-b", "kanister", "2022-01-03T02:03:04.52Z", "backup", "daily", "complete", "daily-b.sql.gz"),
+		newUnstructuredBackup("weekly-a", "kanister", "2022-01-02T02:03:04.52Z", "backup", "weekly", "complete", "shared.sql.gz"),
+		newUnstructuredBackup("weekly-b", "kanister", "2022-01-04T02:03:04.52Z", "backup", "weekly", "complete", "weekly-b.sql.gz"),
+	)
+	var createdDeletions []string
+	client.PrependReactor("create", "actionsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		deletionActionSet := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+		createdDeletions = append(createdDeletions, deletionActionSet.GetName())
+		if err := unstructured.SetNestedField(deletionActionSet.Object, "complete", "status", "state"); err != nil {
+			t.Fatal(err)
+		}
+		return false, nil, nil
+	})
+	var configMaps []runtime.Object
+	for _, name := range []string{"daily", "weekly"} {
+		configMaps = append(configMaps, &corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{Name: name, Namespace: "kanister"},
+			Data:       map[string]string{"backup-config.yaml": "name: " + name + "\nkanisterNamespace: kanister\nretention:\n  backups: 1\n  years: 100\n"},
+		})
+	}
+	clientSet := kubefake.NewSimpleClientset(configMaps...)
+	taweretSettings := taweretsettings{configNamespaces: []string{"kanister"}, apiTimeout: defaultAPITimeout, maxConcurrency: 1, deletionPollInterval: time.Millisecond, deletionTimeout: time.Second}
+
+	summary, err := runEvaluations(context.Background(), client, gvr, clientSet, newMetrics(), taweretSettings, &taweretstatus{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Deleted != 2 {
+		t.Fatalf("expected 2 deleted backups, got %+v", summary)
+	}
+	if len(createdDeletions) != 1 {
+		t.Fatalf("expected a single deletion actionset for the shared backup location, got %v", createdDeletions)
+	}
+	for _, name := range []string{"daily-a", "weekly-a"} {
+		if _, err := client.Resource(gvr).Namespace("kanister").Get(context.Background(), name, v1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Fatalf("expected the backup actionset %v to be deleted, got %v", name, err)
+		}
+	}
+}
+
+func TestDeleteOldestBackupsEvents(t *testing.T) {
+	gvr := schema.GroupVersionResource{
+		Group:    "cr.kanister.io",
+		Version:  "v1alpha1",
+		Resource: "actionsets",
+	}
+	scheme := runtime.NewScheme()
+
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(scheme,
+		map[schema.GroupVersionResource]string{
+			{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}: "ActionSetsList",
+		},
+		newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "pg_backups/renku/renku-postgresql/2022-01-01T02:03:04.52Z/backup.sql.gz"),
+		newUnstructuredBackup("backup-bar", "kanister", "2022-01-02T02:03:04.52Z", "backup", "daily", "complete", "pg_backups/renku/renku-postgresql/2022-01-02T02:03:04.52Z/backup.sql.gz"),
+	)
+	// let Kanister complete the deletion of backup-foo and fail the deletion of backup-bar
+	client.PrependReactor("create", "actionsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		deletionActionSet := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+		state := "complete"
+		if deletionActionSet.GetName() == "delete-backup-bar" {
+			state = "failed"
+		}
+		if err := unstructured.SetNestedField(deletionActionSet.Object, state, "status", "state"); err != nil {
+			t.Fatal(err)
+		}
+		return false, nil, nil
+	})
+
+	var backupConfig backupconfig
+	backupConfig.KanisterNamespace = "kanister"
+	backupConfig.Name = "daily"
+	recorder := record.NewFakeRecorder(10)
+	taweretSettings := taweretsettings{apiTimeout: defaultAPITimeout, deletionPollInterval: time.Millisecond, deletionTimeout: time.Second, eventRecorder: recorder}
+	taweretMetrics := newMetrics()
+
+	backups := []retention.Backup{
+		{Name: "backup-foo", Schedule: "daily", Status: "complete", Time: time.Date(2022, 1, 1, 2, 3, 4, 0, time.UTC)},
+		{Name: "backup-bar", Schedule: "daily", Status: "complete", Time: time.Date(2022, 1, 2, 2, 3, 4, 0, time.UTC)},
+	}
+	deleted, err := deleteOldestBackups(context.Background(), backups, 2, newDeletedLocations(), client, gvr, taweretMetrics, taweretSettings, backupConfig)
+	if err == nil {
+		t.Fatal("expected an error for the failed deletion of backup-bar")
+	}
+	if deleted != 1 {
+		t.Fatalf("expected 1 deleted backup, got %v", deleted)
+	}
+
+	close(recorder.Events)
+	var events []string
+	for event := range recorder.Events {
+		events = append(events, event)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %v", events)
+	}
+	if !strings.HasPrefix(events[0], "Normal BackupDeleted Deleted backup backup-foo") {
+		t.Errorf("expected a BackupDeleted event for backup-foo, got %v", events[0])
+	}
+	if !strings.HasPrefix(events[1], "Warning BackupDeletionFailed Deleting backup backup-bar") {
+		t.Errorf("expected a BackupDeletionFailed event for backup-bar, got %v", events[1])
+	}
+}
+
+func TestBackupConfigDeletionReferences(t *testing.T) {
+	var backupConfig backupconfig
+	backupConfig.KanisterNamespace = "kanister"
+	if object := backupConfig.deletionObject(); object != (v1alpha1.ObjectReference{Kind: "namespace", Name: "kanister", Namespace: "kanister"}) {
+		t.Errorf("expected the kanister namespace as default object, got %+v", object)
+	}
+	if profile := backupConfig.deletionProfile(); profile != nil {
+		t.Errorf("expected no profile without a profile name, got %+v", profile)
+	}
+	if action := backupConfig.deleteAction(); action != "delete" {
+		t.Errorf("expected the delete action by default, got %v", action)
+	}
+
+	backupConfig.ProfileName = "default-profile"
+	if profile := backupConfig.deletionProfile(); *profile != (v1alpha1.ObjectReference{Name: "default-profile", Namespace: "kanister"}) {
+		t.Errorf("expected the profile in the kanister namespace, got %+v", profile)
+	}
+
+	backupConfig.ProfileNamespace = "kanister-profiles"
+	backupConfig.ObjectKind = "statefulset"
+	backupConfig.ObjectName = "renku-postgresql"
+	backupConfig.ObjectNamespace = "renku"
+	if object := backupConfig.deletionObject(); object != (v1alpha1.ObjectReference{Kind: "statefulset", Name: "renku-postgresql", Namespace: "renku"}) {
+		t.Errorf("expected the configured object, got %+v", object)
+	}
+	if profile := backupConfig.deletionProfile(); *profile != (v1alpha1.ObjectReference{Name: "default-profile", Namespace: "kanister-profiles"}) {
+		t.Errorf("expected the profile in the profile namespace, got %+v", profile)
+	}
+	backupConfig.DeleteActionName = "cleanup"
+	if action := backupConfig.deleteAction(); action != "cleanup" {
+		t.Errorf("expected the configured delete action, got %v", action)
+	}
+}
+
+func TestBackupConfigPolicy(t *testing.T) {
+	var backupConfig backupconfig
+	backupConfig.Retention.Backups = 7
+	backupConfig.Retention.Days = 3
+	backupConfig.Retention.KeepWeekly = 4
+	backupConfig.Retention.MinBackups = 2
+
+	backupConfig.Timezone = "Europe/Zurich"
+	policy := backupConfig.policy()
+	if policy.Backups != 7 || policy.Days != 3 || policy.KeepWeekly != 4 || policy.MinBackups != 2 || policy.Location.String() != "Europe/Zurich" {
+		t.Fatalf("unexpected policy %+v", policy)
+	}
+	if err := backupConfig.validate(); err != nil {
+		t.Fatalf("expected time zone %q to be valid, got %v", backupConfig.Timezone, err)
+	}
+
+	// unknown time zones fall back to UTC
+	backupConfig.Timezone = "Mars/Olympus_Mons"
+	if location := backupConfig.policy().Location; location != time.UTC {
+		t.Fatalf("expected UTC for an unknown time zone, got %v", location)
+	}
+	// but the backup config is invalid
+	if err := backupConfig.validate(); err == nil {
+		t.Fatalf("expected unknown time zone %q to be rejected", backupConfig.Timezone)
+	}
+}
+
+func TestBackupConfigKeepWeekday(t *testing.T) {
+	var backupConfig backupconfig
+	backupConfig.Retention.Days = 7
+
+	for _, name := range []string{"Sunday", "sunday", "Sun"} {
+		backupConfig.Retention.KeepWeekday = name
+		if err := backupConfig.validate(); err != nil {
+			t.Fatalf("expected keep weekday %q to be valid, got %v", name, err)
+		}
+		if keepWeekday := backupConfig.policy().KeepWeekday; keepWeekday == nil || *keepWeekday != time.Sunday {
+			t.Fatalf("expected keep weekday %q to be parsed as Sunday, got %v", name, keepWeekday)
+		}
+	}
+
+	backupConfig.Retention.KeepWeekday = "Sundae"
+	if err := backupConfig.validate(); err == nil {
+		t.Fatalf("expected an unknown keep weekday to be rejected")
+	}
+}
+
+func TestGetBackupsLabelSelector(t *testing.T) {
+	gvr := schema.GroupVersionResource{
+		Group:    "cr.kanister.io",
+		Version:  "v1alpha1",
+		Resource: "actionsets",
+	}
+	scheme := runtime.NewScheme()
+
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(scheme,
+		map[schema.GroupVersionResource]string{
+			{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}: "ActionSetsList",
+		},
+		newUnstructuredBackupWithLabels("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "", map[string]interface{}{"app": "postgres"}),
+		newUnstructuredBackupWithLabels("backup-bar", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "", map[string]interface{}{"app": "redis"}),
+	)
+
+	var backupConfig backupconfig
+	backupConfig.KanisterNamespace = "kanister"
+	backupConfig.Name = "daily"
+	backupConfig.LabelSelector = "app=postgres"
+
+	backups, err := getBackups(context.Background(), client, gvr, newMetrics(), taweretsettings{apiTimeout: defaultAPITimeout}, backupConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0].Name != "backup-foo" {
+		t.Fatalf("expected only backup-foo to match the label selector, got %v", backups)
+	}
+}
+
+func TestGetBackupsLabelSelectorDeletions(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	newDeletion := func(name, status string) *unstructured.Unstructured {
+		deletion := newUnstructuredBackup(name, "kanister", "2022-01-05T02:03:04Z", "delete", "", status, "")
+		deletion.Object["spec"].(map[string]interface{})["actions"].([]interface{})[0].(map[string]interface{})["blueprint"] = "postgres-bp"
+		return deletion
+	}
+	// the deletion actionsets carry none of the labels of the backups
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "ActionSetsList"},
+		newUnstructuredBackupWithLabels("backup-foo", "kanister", "2022-01-01T02:03:04Z", "backup", "daily", "complete", "foo.sql.gz", map[string]interface{}{"app": "postgres"}),
+		newUnstructuredBackupWithLabels("backup-bar", "kanister", "2022-01-01T02:03:04Z", "backup", "daily", "complete", "bar.sql.gz", map[string]interface{}{"app": "redis"}),
+		newDeletion("delete-backup-foo", "running"),
+		// the backup of the deletion is not selected, but it still exists
+		newDeletion("delete-backup-bar", "complete"),
+		newDeletion("delete-backup-baz", "complete"),
+	)
+
+	var backupConfig backupconfig
+	backupConfig.KanisterNamespace = "kanister"
+	backupConfig.Name = "daily"
+	backupConfig.BlueprintName = "postgres-bp"
+	backupConfig.LabelSelector = "app=postgres"
+
+	backups, orphanedDeletions, err := listBackups(context.Background(), client, gvr, newMetrics(), taweretsettings{apiTimeout: defaultAPITimeout}, backupConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0].Name != "backup-foo" || backups[0].DeletionState != "running" {
+		t.Fatalf("expected only backup-foo with a running deletion, got %+v", backups)
+	}
+	if len(orphanedDeletions) != 1 || orphanedDeletions[0].name != "delete-backup-baz" {
+		t.Fatalf("expected delete-backup-baz to be the only orphaned deletion, got %v", orphanedDeletions)
+	}
+}
+
+// pagedActionSets serves a fixed list of actionset pages, recording the list options of every call
+type pagedActionSets struct {
+	dynamic.Interface
+	dynamic.NamespaceableResourceInterface
+	pages       []*unstructured.UnstructuredList
+	listOptions []v1.ListOptions
+}
+
+func (p *pagedActionSets) Resource(schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return p
+}
+
+func (p *pagedActionSets) Namespace(string) dynamic.ResourceInterface {
+	return p
+}
+
+func (p *pagedActionSets) List(ctx context.Context, opts v1.ListOptions) (*unstructured.UnstructuredList, error) {
+	p.listOptions = append(p.listOptions, opts)
+	return p.pages[len(p.listOptions)-1], nil
+}
+
+func TestGetBackupsPagination(t *testing.T) {
+	gvr := schema.GroupVersionResource{
+		Group:    "cr.kanister.io",
+		Version:  "v1alpha1",
+		Resource: "actionsets",
+	}
+	// serve two pages of actionsets, the first one with a continue token
+	client := &pagedActionSets{pages: []*unstructured.UnstructuredList{
+		{Items: []unstructured.Unstructured{*newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "")}},
+		{Items: []unstructured.Unstructured{*newUnstructuredBackup("backup-bar", "kanister", "2022-01-02T02:03:04.52Z", "backup", "daily", "complete", "")}},
+	}}
+	client.pages[0].SetContinue("page-2")
+
+	var backupConfig backupconfig
+	backupConfig.KanisterNamespace = "kanister"
+	backupConfig.Name = "daily"
+
+	taweretMetrics := newMetrics()
+	backups, err := getBackups(context.Background(), client, gvr, taweretMetrics, taweretsettings{apiTimeout: defaultAPITimeout, listPageSize: 1}, backupConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(client.listOptions) != 2 {
+		t.Fatalf("expected 2 list calls, got %v", len(client.listOptions))
+	}
+	if client.listOptions[0].Limit != 1 || client.listOptions[1].Continue != "page-2" {
+		t.Fatalf("expected paginated list calls, got %v", client.listOptions)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("expected backups from both pages, got %v", backups)
+	}
+	if scanned := testutil.ToFloat64(taweretMetrics.actionSetsScanned.WithLabelValues("kanister")); scanned != 2 {
+		t.Fatalf("expected 2 scanned actionsets, got %v", scanned)
+	}
+}
+
+func TestWatchBackupConfigs(t *testing.T) {
+	clientSet := kubefake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: "daily", Namespace: "kanister"},
+		Data:       map[string]string{"backup-config.yaml": "name: daily"},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan struct{}, 1)
+	watchBackupConfigs(ctx, nil, clientSet, taweretsettings{configNamespaces: []string{"kanister"}, configSource: "configmap"}, changes)
+
+	// the existing configmap is part of the initial listing
+	select {
+	case <-changes:
+		t.Fatalf("expected the initial listing not to be reported as a change")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// configmaps without a backup config are ignored
+	_, err := clientSet.CoreV1().ConfigMaps("kanister").Create(ctx, &corev1.ConfigMap{ObjectMeta: v1.ObjectMeta{Name: "unrelated", Namespace: "kanister"}}, v1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("error creating configmap: %v", err)
+	}
+	_, err = clientSet.CoreV1().ConfigMaps("kanister").Update(ctx, &corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: "daily", Namespace: "kanister"},
+		Data:       map[string]string{"backup-config.yaml": "name: daily\nretention:\n  backups: 3"},
+	}, v1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("error updating configmap: %v", err)
+	}
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the changed backup config to be reported")
+	}
+	select {
+	case <-changes:
+		t.Fatalf("expected the configmap without a backup config to be ignored")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestDebounceChanges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan struct{})
+	evaluations := make(chan struct{}, 10)
+	go debounceChanges(ctx, changes, 100*time.Millisecond, func() { evaluations <- struct{}{} })
+
+	// rapid changes are debounced into a single evaluation
+	for i := 0; i < 5; i++ {
+		changes <- struct{}{}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-evaluations:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected an evaluation after the changes settled")
+	}
+	select {
+	case <-evaluations:
+		t.Fatalf("expected a single evaluation for rapid changes")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestMissingPermissions(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	clientSet := kubefake.NewSimpleClientset()
+	// the ServiceAccount may do anything but delete actionsets
+	clientSet.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		review.Status.Allowed = review.Spec.ResourceAttributes.Resource != "actionsets" || review.Spec.ResourceAttributes.Verb != "delete"
+		return true, review, nil
+	})
+	taweretSettings := taweretsettings{apiTimeout: defaultAPITimeout, configNamespaces: []string{"kanister"}, configSource: "configmap"}
+
+	var backupConfigs []backupconfig
+	for _, kanisterNamespace := range []string{"kanister", "postgres", "kanister", "reports"} {
+		var backupConfig backupconfig
+		backupConfig.KanisterNamespace = kanisterNamespace
+		// a report only backup config only needs to read the actionsets
+		backupConfig.ReportOnly = kanisterNamespace == "reports"
+		backupConfigs = append(backupConfigs, backupConfig)
+	}
+	permissions := requiredPermissions(gvr, taweretSettings, backupConfigs)
+	if len(permissions) != 11 {
+		t.Fatalf("expected 1 configmap and 10 actionset permissions, got %v", permissions)
+	}
+	missing, err := missingPermissions(context.Background(), clientSet, taweretSettings, permissions)
+	if err != nil {
+		t.Fatalf("error checking permissions: %v", err)
+	}
+	expected := []permission{
+		{namespace: "kanister", group: "cr.kanister.io", resource: "actionsets", verb: "delete"},
+		{namespace: "postgres", group: "cr.kanister.io", resource: "actionsets", verb: "delete"},
+	}
+	if fmt.Sprint(missing) != fmt.Sprint(expected) {
+		t.Fatalf("expected missing permissions %v, got %v", expected, missing)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "ActionSetsList"},
+		newUnstructuredBackup("backup-1", "kanister", "2022-01-01T02:03:04.52Z", "backup", "daily", "complete", "1.sql.gz"),
+		newUnstructuredBackup("backup-2", "kanister", "2022-01-02T02:03:04.52Z", "backup", "daily", "complete", "2.sql.gz"),
+		newUnstructuredBackup("backup-3", "kanister", "2022-01-03T02:03:04.52Z", "backup", "daily", "complete", "3.sql.gz"),
+	)
+	clientSet := kubefake.NewSimpleClientset()
+	taweretSettings := taweretsettings{apiTimeout: defaultAPITimeout, configNamespaces: []string{"kanister"}, auditConfigMap: "taweret/audit", auditSize: 2}
+	taweretSettings.auditLog = newAuditLog(clientSet, taweretSettings)
+	var backupConfig backupconfig
+	backupConfig.Name = "daily"
+	backupConfig.KanisterNamespace = "kanister"
+	backupConfig.Retention.Days = 7
+
+	// the audit configmap is created by the first deletion and keeps the latest two entries
+	for _, name := range []string{"backup-1", "backup-2", "backup-3"} {
+		if err := deleteBackupActionSet(context.Background(), retention.Backup{Name: name, BackupLocation: name + ".sql.gz"}, client, gvr, newMetrics(), taweretSettings, backupConfig); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recorder := httptest.NewRecorder()
+	auditHandler(taweretSettings)(recorder, httptest.NewRequest(http.MethodGet, "/audit", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %v, got %v: %v", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+	var entries []auditentry
+	if err := json.NewDecoder(recorder.Body).Decode(&entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].BackupName != "backup-2" || entries[1].BackupName != "backup-3" {
+		t.Fatalf("expected the audit entries of backup-2 and backup-3, got %+v", entries)
+	}
+	if entries[1].BackupConfig != "daily" || entries[1].BackupLocation != "backup-3.sql.gz" || entries[1].Retention.Days != 7 {
+		t.Fatalf("unexpected audit entry %+v", entries[1])
+	}
+
+	// a backup actionset is kept if its audit entry cannot be written
+	clientSet.PrependReactor("update", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(corev1.Resource("configmaps"), "audit", fmt.Errorf("denied"))
+	})
+	client.ClearActions()
+	if err := deleteBackupActionSet(context.Background(), retention.Backup{Name: "backup-1"}, client, gvr, newMetrics(), taweretSettings, backupConfig); err == nil {
+		t.Fatalf("expected an error if the audit entry cannot be written")
+	}
+	if len(client.Actions()) != 0 {
+		t.Fatalf("expected the backup actionset to be kept, got %v", client.Actions())
+	}
+
+	recorder = httptest.NewRecorder()
+	auditHandler(taweretsettings{})(recorder, httptest.NewRequest(http.MethodGet, "/audit", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("expected status %v without an audit configmap, got %v", http.StatusNotFound, recorder.Code)
+	}
+}
+
+func TestCheckActionSetResource(t *testing.T) {
+	clientSet := kubefake.NewSimpleClientset()
+	clientSet.Fake.Resources = []*v1.APIResourceList{
+		{GroupVersion: "cr.kanister.io/v1alpha1", APIResources: []v1.APIResource{{Name: "actionsets"}, {Name: "blueprints"}}},
+	}
+
+	if err := checkActionSetResource(clientSet, defaultActionSetGVR); err != nil {
+		t.Fatalf("expected the actionset resource to be found, got %v", err)
+	}
+	missingResource := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionset"}
+	if err := checkActionSetResource(clientSet, missingResource); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected a NotFound error for a missing resource, got %v", err)
+	}
+	missingVersion := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1beta1", Resource: "actionsets"}
+	if err := checkActionSetResource(clientSet, missingVersion); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected a NotFound error for a missing version, got %v", err)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	if jitter := randomDuration(0); jitter != 0 {
+		t.Fatalf("expected no jitter if it is disabled, got %v", jitter)
+	}
+	for i := 0; i < 100; i++ {
+		if jitter := randomDuration(time.Minute); jitter < 0 || jitter >= time.Minute {
+			t.Fatalf("expected a jitter in [0, 1m), got %v", jitter)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if !sleepContext(ctx, time.Millisecond) {
+		t.Fatalf("expected the sleep to complete")
+	}
+	cancel()
+	start := time.Now()
+	if sleepContext(ctx, time.Hour) {
+		t.Fatalf("expected the sleep to be interrupted by the cancelled context")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the interrupted sleep to return immediately, took %v", elapsed)
+	}
+}
+
+func TestScheduleEvaluationsNextEvaluation(t *testing.T) {
+	taweretStatus := &taweretstatus{}
+	taweretMetrics := newMetrics()
+	taweretSettings := taweretsettings{evalSchedule: "0 0 1 1 *", evalJitter: time.Minute}
+
+	scheduler := scheduleEvaluations(context.Background(), nil, schema.GroupVersionResource{}, nil, taweretMetrics, taweretSettings, taweretStatus)
+	defer scheduler.Stop()
+
+	// the next evaluation is on the first of January, delayed by less than the jitter
+	nextEvaluation := taweretStatus.getNextEvaluation()
+	if nextEvaluation.Month() != time.January || nextEvaluation.Day() != 1 || nextEvaluation.Hour() != 0 || !nextEvaluation.After(time.Now()) {
+		t.Fatalf("expected the next evaluation at midnight on the first of January, got %v", nextEvaluation)
+	}
+	if next := testutil.ToFloat64(taweretMetrics.nextEvaluation); next != float64(nextEvaluation.Unix()) {
+		t.Fatalf("expected the next evaluation metric to be %v, got %v", nextEvaluation.Unix(), next)
+	}
+
+	recorder := httptest.NewRecorder()
+	scheduleHandler(taweretSettings, taweretStatus)(recorder, httptest.NewRequest(http.MethodGet, "/schedule", nil))
+	var response map[string]string
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if response["nextEvaluation"] != nextEvaluation.UTC().Format(time.RFC3339) || response["schedule"] != "0 0 1 1 *" {
+		t.Fatalf("expected the schedule and next evaluation in the response, got %v", response)
+	}
+	if _, ok := response["lastSuccessfulEvaluation"]; ok {
+		t.Fatalf("expected no last successful evaluation before the first evaluation, got %v", response)
+	}
+}
+
+func TestCleanupDeletionActionSets(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "cr.kanister.io", Version: "v1alpha1", Resource: "actionsets"}
+	newDeletion := func(name, status, blueprint string) *unstructured.Unstructured {
+		deletion := newUnstructuredBackup(name, "kanister", "2022-01-05T02:03:04Z", "delete", "", status, "")
+		deletion.Object["spec"].(map[string]interface{})["actions"].([]interface{})[0].(map[string]interface{})["blueprint"] = blueprint
+		return deletion
+	}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "ActionSetsList"},
+		newUnstructuredBackup("backup-foo", "kanister", "2022-01-01T02:03:04Z", "backup", "daily", "complete", "backup.sql.gz"),
+		// the backup actionset still exists
+		newDeletion("delete-backup-foo", "complete", "postgres-bp"),
+		newDeletion("delete-backup-bar", "complete", "postgres-bp"),
+		// the deletion of another backup config
+		newDeletion("delete-backup-baz", "complete", "mysql-bp"),
+		newDeletion("delete-backup-qux", "failed", "postgres-bp"),
+	)
+
+	var backupConfig backupconfig
+	backupConfig.Name = "daily"
+	backupConfig.KanisterNamespace = "kanister"
+	backupConfig.BlueprintName = "postgres-bp"
+
+	_, orphanedDeletions, err := listBackups(context.Background(), client, gvr, newMetrics(), taweretsettings{apiTimeout: defaultAPITimeout}, backupConfig)
+	if err != nil {
+		t.Fatalf("error listing backups: %v", err)
+	}
+	if len(orphanedDeletions) != 1 || orphanedDeletions[0].name != "delete-backup-bar" {
+		t.Fatalf("expected delete-backup-bar to be the only orphaned deletion, got %v", orphanedDeletions)
+	}
+
+	// deletion actionsets younger than the retention are kept for auditing
+	cleanupDeletionActionSets(context.Background(), orphanedDeletions, client, gvr, taweretsettings{apiTimeout: defaultAPITimeout, deletionActionSetRetention: 100 * 365 * 24 * time.Hour}, backupConfig)
+	if _, err := client.Resource(gvr).Namespace("kanister").Get(context.Background(), "delete-backup-bar", v1.GetOptions{}); err != nil {
+		t.Fatalf("expected the deletion actionset to be kept within the retention, got %v", err)
+	}
+	cleanupDeletionActionSets(context.Background(), orphanedDeletions, client, gvr, taweretsettings{apiTimeout: defaultAPITimeout}, backupConfig)
+	if _, err := client.Resource(gvr).Namespace("kanister").Get(context.Background(), "delete-backup-bar", v1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected the orphaned deletion actionset to be deleted, got %v", err)
+	}
+}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"log"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/swissdatasciencecenter/taweret/internal/retention"
+)
+
+// labels of the metrics of a backup config, the namespace and the blueprint follow from the backup config and add no series
+var backupConfigLabels = []string{
+	// which backup config
+	"backup_config_name",
+	// kanister namespace of the backup config
+	"namespace",
+	// blueprint of the backup config
+	"blueprint",
+}
+
+// returns the values of the backup config labels of a backup config, followed by the values of the extra labels of a metric
+func (backupConfig backupconfig) metricLabels(extra ...string) []string {
+	return append([]string{backupConfig.Name, backupConfig.KanisterNamespace, backupConfig.BlueprintName}, extra...)
+}
+
+type taweretmetrics struct {
+	backupCount         *prometheus.GaugeVec
+	oldestBackup        *prometheus.GaugeVec
+	newestBackup        *prometheus.GaugeVec
+	backupsWouldDelete  *prometheus.GaugeVec
+	backupsDeleted      *prometheus.CounterVec
+	evaluationDuration  *prometheus.HistogramVec
+	evaluationErrors    *prometheus.CounterVec
+	invalidConfigs      *prometheus.GaugeVec
+	configParseErrors   *prometheus.CounterVec
+	configMapsScanned   *prometheus.GaugeVec
+	configsLoaded       *prometheus.GaugeVec
+	evaluationsSkipped  prometheus.Counter
+	pausedConfigs       *prometheus.GaugeVec
+	deletionFailures    *prometheus.CounterVec
+	unverifiedDeletions *prometheus.CounterVec
+	missingLocations    *prometheus.CounterVec
+	deferredDeletions   *prometheus.CounterVec
+	heldDeletions       *prometheus.GaugeVec
+	stuckDeletions      *prometheus.GaugeVec
+	oldestDeletableAge  *prometheus.GaugeVec
+	oldestUnfinishedAge *prometheus.GaugeVec
+	backupStuck         *prometheus.GaugeVec
+	matchedConfigs      *prometheus.GaugeVec
+	newestProtected     *prometheus.GaugeVec
+	nextEvaluation      prometheus.Gauge
+	leader              prometheus.Gauge
+	lastEvaluation      prometheus.Gauge
+	actionSetsScanned   *prometheus.CounterVec
+	invalidTimestamps   *prometheus.GaugeVec
+	expiredBackups      *prometheus.GaugeVec
+	backupSize          *prometheus.GaugeVec
+	retainedSize        *prometheus.GaugeVec
+	scheduleFound       *prometheus.GaugeVec
+	unmanagedSchedules  *prometheus.GaugeVec
+	pushErrors          prometheus.Counter
+	sinceLastDeletion   *prometheus.GaugeVec
+	buildInfo           *prometheus.GaugeVec
+	retentionPolicy     *prometheus.GaugeVec
+	// times from which sinceLastDeletion is measured, by backup config
+	lastDeletions *deletiontimes
+}
+
+// deletiontimes holds the time of the last deletion of each backup config, or of the last evaluation of the backup config without
+// deletable backups, it is shared by the concurrent evaluations
+type deletiontimes struct {
+	mutex sync.Mutex
+	times map[string]time.Time
+}
+
+// returns the seconds since the last deletion of a backup config, after recording now as its time if reset is set
+// the time of a backup config is first recorded in its first evaluation, as earlier deletions are not known after a restart
+func (lastDeletions *deletiontimes) since(backupConfig string, reset bool, now time.Time) float64 {
+	lastDeletions.mutex.Lock()
+	defer lastDeletions.mutex.Unlock()
+	lastDeletion, ok := lastDeletions.times[backupConfig]
+	if reset || !ok {
+		lastDeletion = now
+		lastDeletions.times[backupConfig] = now
+	}
+	return now.Sub(lastDeletion).Seconds()
+}
+
+// initialise Prometheus metrics and register them with the default registry
+func initialiseMetrics() taweretmetrics {
+	taweretMetrics := newMetrics()
+
+	prometheus.MustRegister(taweretMetrics.backupCount)
+	prometheus.MustRegister(taweretMetrics.oldestBackup)
+	prometheus.MustRegister(taweretMetrics.newestBackup)
+	prometheus.MustRegister(taweretMetrics.backupsWouldDelete)
+	prometheus.MustRegister(taweretMetrics.backupsDeleted)
+	prometheus.MustRegister(taweretMetrics.evaluationDuration)
+	prometheus.MustRegister(taweretMetrics.evaluationErrors)
+	prometheus.MustRegister(taweretMetrics.invalidConfigs)
+	prometheus.MustRegister(taweretMetrics.configParseErrors)
+	prometheus.MustRegister(taweretMetrics.configMapsScanned)
+	prometheus.MustRegister(taweretMetrics.configsLoaded)
+	prometheus.MustRegister(taweretMetrics.evaluationsSkipped)
+	prometheus.MustRegister(taweretMetrics.pausedConfigs)
+	prometheus.MustRegister(taweretMetrics.deletionFailures)
+	prometheus.MustRegister(taweretMetrics.unverifiedDeletions)
+	prometheus.MustRegister(taweretMetrics.missingLocations)
+	prometheus.MustRegister(taweretMetrics.deferredDeletions)
+	prometheus.MustRegister(taweretMetrics.heldDeletions)
+	prometheus.MustRegister(taweretMetrics.stuckDeletions)
+	prometheus.MustRegister(taweretMetrics.oldestDeletableAge)
+	prometheus.MustRegister(taweretMetrics.oldestUnfinishedAge)
+	prometheus.MustRegister(taweretMetrics.backupStuck)
+	prometheus.MustRegister(taweretMetrics.matchedConfigs)
+	prometheus.MustRegister(taweretMetrics.newestProtected)
+	prometheus.MustRegister(taweretMetrics.nextEvaluation)
+	prometheus.MustRegister(taweretMetrics.lastEvaluation)
+	prometheus.MustRegister(taweretMetrics.actionSetsScanned)
+	prometheus.MustRegister(taweretMetrics.invalidTimestamps)
+	prometheus.MustRegister(taweretMetrics.expiredBackups)
+	prometheus.MustRegister(taweretMetrics.backupSize)
+	prometheus.MustRegister(taweretMetrics.retainedSize)
+	prometheus.MustRegister(taweretMetrics.scheduleFound)
+	prometheus.MustRegister(taweretMetrics.unmanagedSchedules)
+	prometheus.MustRegister(taweretMetrics.pushErrors)
+	prometheus.MustRegister(taweretMetrics.sinceLastDeletion)
+	prometheus.MustRegister(taweretMetrics.buildInfo)
+	prometheus.MustRegister(taweretMetrics.leader)
+	prometheus.MustRegister(taweretMetrics.retentionPolicy)
+
+	return taweretMetrics
+}
+
+// creates the Prometheus metrics without registering them
+func newMetrics() taweretmetrics {
+	var taweretMetrics taweretmetrics
+
+	taweretMetrics.backupCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "backup_count",
+			Help: "The amount of backups per state",
+		},
+		append(backupConfigLabels,
+			// state of the backups
+			"backup_status",
+		),
+	)
+	taweretMetrics.oldestBackup = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "oldest_backup_timestamp",
+			Help: "The creation time of the oldest retained backup",
+		},
+		backupConfigLabels,
+	)
+	taweretMetrics.newestBackup = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "newest_backup_timestamp",
+			Help: "The creation time of the newest retained backup",
+		},
+		backupConfigLabels,
+	)
+	taweretMetrics.backupsWouldDelete = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "backups_would_delete",
+			Help: "The amount of backups which would be deleted if dry run mode was disabled",
+		},
+		backupConfigLabels,
+	)
+	taweretMetrics.backupsDeleted = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "backups_deleted_total",
+			Help: "The amount of backups deleted",
+		},
+		backupConfigLabels,
+	)
+	taweretMetrics.evaluationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "evaluation_duration_seconds",
+			Help: "The duration of backup config evaluations, including deletions",
+			// deletions wait for the deletion actionsets to complete, so evaluations may take minutes
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 14),
+		},
+		backupConfigLabels,
+	)
+	taweretMetrics.actionSetsScanned = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "actionsets_scanned_total",
+			Help: "The amount of actionsets listed from the Kubernetes API, including the actionsets which are not backups of the backup config",
+		},
+		[]string{
+			// namespace in which the actionsets are listed
+			"namespace",
+		},
+	)
+	taweretMetrics.invalidTimestamps = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "backups_invalid_timestamp",
+			Help: "The amount of backups skipped due to a missing or malformed creation timestamp",
+		},
+		backupConfigLabels,
+	)
+	taweretMetrics.expiredBackups = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "backups_expired",
+			Help: "The amount of backups marked as expired in soft delete mode which have not been deleted yet",
+		},
+		backupConfigLabels,
+	)
+	taweretMetrics.backupSize = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "backup_size_bytes",
+			Help: "The size of the newest retained backup with a known size",
+		},
+		backupConfigLabels,
+	)
+	taweretMetrics.retainedSize = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "retained_backups_size_bytes",
+			Help: "The total size of the retained backups with a known size",
+		},
+		backupConfigLabels,
+	)
+	taweretMetrics.evaluationErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "evaluation_errors_total",
+			Help: "The amount of evaluations aborted by a failed Kubernetes API call",
+		},
+		// the labels are empty if the backup configs could not be retrieved
+		backupConfigLabels,
+	)
+	taweretMetrics.configParseErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "config_parse_errors_total",
+			Help: "The amount of times a ConfigMap or BackupConfig resource was skipped because its backup config could not be parsed",
+		},
+		// the backup config name is not known if it cannot be parsed
+		[]string{
+			// namespace of the ConfigMap or BackupConfig resource
+			"namespace",
+			// name of the ConfigMap or BackupConfig resource
+			"source_name",
+		},
+	)
+	taweretMetrics.configMapsScanned = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "config_maps_scanned",
+			Help: "The amount of ConfigMaps scanned for backup configs in a config namespace in the last listing",
+		},
+		// namespace of the ConfigMaps
+		[]string{"namespace"},
+	)
+	taweretMetrics.configsLoaded = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "backup_configs_loaded",
+			Help: "The amount of valid backup configs managed by this instance loaded from a config namespace in the last listing",
+		},
+		// namespace of the ConfigMaps or BackupConfig resources
+		[]string{"namespace"},
+	)
+	taweretMetrics.invalidConfigs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "backup_config_invalid",
+			Help: "Whether the backup config is skipped due to an invalid retention (1) or not (0)",
+		},
+		backupConfigLabels,
+	)
+	taweretMetrics.pausedConfigs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "backup_config_paused",
+			Help: "Whether the evaluation of the backup config is paused (1) or not (0)",
+		},
+		backupConfigLabels,
+	)
+	taweretMetrics.matchedConfigs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "backup_config_matched",
+			Help: "Whether the backup config matches at least one backup actionset (1) or none (0)",
+		},
+		backupConfigLabels,
+	)
+	taweretMetrics.scheduleFound = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "backup_config_schedule_found",
+			Help: "Whether the backup schedule of the backup config is found on any actionset (1) or not (0)",
+		},
+		backupConfigLabels,
+	)
+	taweretMetrics.unmanagedSchedules = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "unmanaged_schedules",
+			Help: "The amount of backup schedules found on actionsets which no backup config manages",
+		},
+		[]string{"namespace"},
+	)
+	taweretMetrics.unverifiedDeletions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "backup_deletions_unverified_total",
+			Help: "The amount of completed deletion actionsets which did not confirm the deletion under the deletion verification path",
+		},
+		backupConfigLabels,
+	)
+	taweretMetrics.missingLocations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "backup_deletions_skipped_missing_location_total",
+			Help: "The amount of deletions skipped because the backup actionset has artifacts but no backup location",
+		},
+		backupConfigLabels,
+	)
+	taweretMetrics.deferredDeletions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "backup_deletions_deferred_total",
+			Help: "The amount of deletions deferred because the evaluation ran outside of the deletion window of the backup config",
+		},
+		backupConfigLabels,
+	)
+	taweretMetrics.deletionFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "backup_deletion_failures_total",
+			Help: "The amount of deletion actionsets which failed",
+		},
+		append(backupConfigLabels,
+			// which backup
+			"backup_name",
+		),
+	)
+	taweretMetrics.heldDeletions = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "held_deletions",
+			Help: "The amount of deletable backups held back until they have been deletable for the deletion delay",
+		},
+		backupConfigLabels,
+	)
+	taweretMetrics.stuckDeletions = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "stuck_deletion_actionsets",
+			Help: "The amount of failed deletion actionsets whose backup actionset still exists",
+		},
+		backupConfigLabels,
+	)
+	taweretMetrics.oldestDeletableAge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "oldest_deletable_backup_age_seconds",
+			Help: "The age of the oldest backup which is deletable but has not been deleted yet, 0 if there is none",
+		},
+		backupConfigLabels,
+	)
+	taweretMetrics.oldestUnfinishedAge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "oldest_unfinished_backup_age_seconds",
+			Help: "The age of the oldest pending or running backup, 0 if there is none",
+		},
+		backupConfigLabels,
+	)
+	taweretMetrics.backupStuck = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "backup_stuck",
+			Help: "Whether a backup is pending or running for longer than the stuck backup threshold (1) or not (0)",
+		},
+		backupConfigLabels,
+	)
+	taweretMetrics.sinceLastDeletion = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "seconds_since_last_deletion",
+			Help: "The seconds since a backup of the backup config was last deleted or it last had no deletable backups",
+		},
+		backupConfigLabels,
+	)
+	taweretMetrics.lastDeletions = &deletiontimes{times: make(map[string]time.Time)}
+	taweretMetrics.retentionPolicy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "backup_config_retention",
+			Help: "The retention values of the backup config in effect, after the default retention is applied",
+		},
+		append(backupConfigLabels,
+			// retention value, e.g. backups or days
+			"parameter",
+		),
+	)
+	taweretMetrics.buildInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "taweret_build_info",
+			Help: "Always 1, labelled with the version, commit and build date of the running Taweret",
+		},
+		[]string{"version", "commit", "build_date", "goversion"},
+	)
+	taweretMetrics.leader = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "taweret_leader",
+			Help: "Whether this instance leads and runs the evaluations (1) or follows the leader of the leader election (0)",
+		},
+	)
+	taweretMetrics.evaluationsSkipped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "evaluations_skipped_total",
+			Help: "The amount of scheduled evaluations skipped because the previous evaluation was still running",
+		},
+	)
+	taweretMetrics.pushErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "pushgateway_push_errors_total",
+			Help: "The amount of failed pushes of the metrics to the Pushgateway",
+		},
+	)
+	taweretMetrics.newestProtected = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "newest_backup_protected",
+			Help: "Whether the newest completed backup is only retained by the safety rule (1) or not (0)",
+		},
+		backupConfigLabels,
+	)
+	taweretMetrics.nextEvaluation = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "next_evaluation_timestamp",
+			Help: "The time of the next scheduled evaluation, including the jitter",
+		},
+	)
+	taweretMetrics.lastEvaluation = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "last_evaluation_timestamp",
+			Help: "The time at which the last scheduled evaluation completed",
+		},
+	)
+
+	return taweretMetrics
+}
+
+// set Prometheus metrics values
+func (taweretMetrics *taweretmetrics) setMetrics(backups []retention.Backup, backupConfig backupconfig, backupCounts retention.Counts) {
+	log.Printf("%v: setting Prometheus metrics\n", backupConfig.Name)
+
+	// set newestBackup and oldestBackup to corresponding backup timestamps if backups are present
+	if len(backups) > 0 {
+		taweretMetrics.oldestBackup.WithLabelValues(backupConfig.metricLabels()...).Set(float64(backups[0].Time.Unix()))
+		taweretMetrics.newestBackup.WithLabelValues(backupConfig.metricLabels()...).Set(float64(backups[len(backups)-1].Time.Unix()))
+
+	} else {
+		taweretMetrics.oldestBackup.WithLabelValues(backupConfig.metricLabels()...).Set(0)
+		taweretMetrics.newestBackup.WithLabelValues(backupConfig.metricLabels()...).Set(0)
+	}
+
+	// set backupCount for completed, pending, running, failed, skipped, deleting and unknown state backups
+	taweretMetrics.backupCount.WithLabelValues(backupConfig.metricLabels("completed")...).Set(float64(len(backups)))
+	taweretMetrics.backupCount.WithLabelValues(backupConfig.metricLabels("pending")...).Set(float64(backupCounts.Pending))
+	taweretMetrics.backupCount.WithLabelValues(backupConfig.metricLabels("running")...).Set(float64(backupCounts.Running))
+	taweretMetrics.backupCount.WithLabelValues(backupConfig.metricLabels("failed")...).Set(float64(backupCounts.Failed))
+	taweretMetrics.backupCount.WithLabelValues(backupConfig.metricLabels("skipped")...).Set(float64(backupCounts.Skipped))
+	taweretMetrics.backupCount.WithLabelValues(backupConfig.metricLabels("deleting")...).Set(float64(backupCounts.Deleting))
+	taweretMetrics.backupCount.WithLabelValues(backupConfig.metricLabels("unknown")...).Set(float64(backupCounts.Unknown))
+
+	newestProtected := 0.0
+	if backupCounts.NewestProtected {
+		newestProtected = 1
+	}
+	taweretMetrics.newestProtected.WithLabelValues(backupConfig.metricLabels()...).Set(newestProtected)
+	taweretMetrics.expiredBackups.WithLabelValues(backupConfig.metricLabels()...).Set(float64(backupCounts.Expired))
+
+	// the retention values in effect, 0 for unset values
+	retentionValues := []struct {
+		parameter string
+		value     StringInt
+	}{
+		{"backups", backupConfig.Retention.Backups},
+		{"minutes", backupConfig.Retention.Minutes},
+		{"hours", backupConfig.Retention.Hours},
+		{"days", backupConfig.Retention.Days},
+		{"months", backupConfig.Retention.Months},
+		{"years", backupConfig.Retention.Years},
+		{"keep_daily", backupConfig.Retention.KeepDaily},
+		{"keep_weekly", backupConfig.Retention.KeepWeekly},
+		{"keep_monthly", backupConfig.Retention.KeepMonthly},
+		{"min_backups", backupConfig.Retention.MinBackups},
+		{"keep_failed", backupConfig.Retention.KeepFailed},
+	}
+	for _, retentionValue := range retentionValues {
+		taweretMetrics.retentionPolicy.WithLabelValues(backupConfig.metricLabels(retentionValue.parameter)...).Set(float64(retentionValue.value))
+	}
+
+	// the sizes are only known if the backup config has a backup size path
+	if backupConfig.BackupSizePath != "" {
+		var newestSize, retainedSize int64
+		for _, aBackup := range backups {
+			if aBackup.Size > 0 {
+				newestSize = aBackup.Size
+				retainedSize += aBackup.Size
+			}
+		}
+		taweretMetrics.backupSize.WithLabelValues(backupConfig.metricLabels()...).Set(float64(newestSize))
+		taweretMetrics.retainedSize.WithLabelValues(backupConfig.metricLabels()...).Set(float64(retainedSize))
+	}
+}
+
+// pushes the metrics of the default registry to the Pushgateway, if one is set, replacing the metrics previously pushed by this instance
+// errors are only logged and counted, so that an unavailable Pushgateway does not fail the evaluation
+func (taweretMetrics *taweretmetrics) push(taweretSettings taweretsettings) {
+	if taweretSettings.pushgatewayURL == "" {
+		return
+	}
+	pusher := push.New(taweretSettings.pushgatewayURL, "taweret").
+		Gatherer(prometheus.DefaultGatherer).
+		Grouping("instance", taweretSettings.pushgatewayInstance).
+		Client(&http.Client{Timeout: taweretSettings.apiTimeout})
+	if err := pusher.Push(); err != nil {
+		slog.Warn("error pushing metrics to the Pushgateway", "instance", taweretSettings.pushgatewayInstance, "error", err)
+		taweretMetrics.pushErrors.Inc()
+		return
+	}
+	log.Printf("pushed metrics to the Pushgateway as instance %v", taweretSettings.pushgatewayInstance)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"sort"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// permission is a verb on a resource in a namespace which the ServiceAccount of Taweret needs
+type permission struct {
+	namespace string
+	group     string
+	resource  string
+	verb      string
+}
+
+func (p permission) String() string {
+	resource := p.resource
+	if p.group != "" {
+		resource = p.resource + "." + p.group
+	}
+	return fmt.Sprintf("%v %v in namespace %v", p.verb, resource, p.namespace)
+}
+
+// checks at startup that the ServiceAccount has the permissions needed for the evaluations, logs the missing ones and exits if
+// requirePermissions is set
+func checkStartupPermissions(dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, clientSet kubernetes.Interface, taweretMetrics taweretmetrics, taweretSettings taweretsettings) {
+	ctx := context.Background()
+
+	// the kanister namespaces are only known from the backup configs, their actionset permissions are not checked if the configs cannot be read
+	backupConfigs, err := getBackupConfigs(ctx, dynamicClient, clientSet, taweretMetrics, taweretSettings)
+	if err != nil {
+		slog.Warn("error getting backup configs, not checking actionset permissions", "error", err)
+	}
+
+	missingPermissions, err := missingPermissions(ctx, clientSet, taweretSettings, requiredPermissions(gvr, taweretSettings, backupConfigs))
+	if err != nil {
+		slog.Warn("error checking permissions", "error", err)
+		return
+	}
+	for _, missingPermission := range missingPermissions {
+		slog.Error("missing permission, grant it to the ServiceAccount of Taweret", "namespace", missingPermission.namespace, "api_group", missingPermission.group, "resource", missingPermission.resource, "verb", missingPermission.verb)
+	}
+	if len(missingPermissions) > 0 && taweretSettings.requirePermissions {
+		log.Fatalf("missing %v permissions, first missing permission: %v", len(missingPermissions), missingPermissions[0])
+	}
+}
+
+// returns the permissions needed to read the backup configs in the config namespaces and to manage actionsets in the kanister namespaces
+// of the backup configs, kanister namespaces whose backup configs are all report only only need to read actionsets
+func requiredPermissions(gvr schema.GroupVersionResource, taweretSettings taweretsettings, backupConfigs []backupconfig) []permission {
+	var permissions []permission
+
+	configGroup, configResource := "", "configmaps"
+	if taweretSettings.configSource == "crd" {
+		configGroup, configResource = backupConfigGVR.Group, backupConfigGVR.Resource
+	}
+	configVerbs := []string{"list"}
+	if taweretSettings.watchConfigs {
+		configVerbs = append(configVerbs, "watch")
+	}
+	for _, configNamespace := range taweretSettings.configNamespaces {
+		for _, verb := range configVerbs {
+			permissions = append(permissions, permission{namespace: configNamespace, group: configGroup, resource: configResource, verb: verb})
+		}
+	}
+
+	if taweretSettings.leaderElection {
+		leaseNamespace, _ := leaderElectionLease(taweretSettings)
+		for _, verb := range []string{"get", "create", "update"} {
+			permissions = append(permissions, permission{namespace: leaseNamespace, group: "coordination.k8s.io", resource: "leases", verb: verb})
+		}
+	}
+
+	// the audit configmap is created on the first deletion
+	if taweretSettings.auditLog != nil && !taweretSettings.reportOnly {
+		for _, verb := range []string{"get", "create", "update"} {
+			permissions = append(permissions, permission{namespace: taweretSettings.auditLog.namespace, resource: "configmaps", verb: verb})
+		}
+	}
+
+	// several backup configs usually share a kanister namespace
+	var kanisterNamespaces []string
+	deletingNamespaces := make(map[string]bool)
+	for _, backupConfig := range backupConfigs {
+		kanisterNamespaces = append(kanisterNamespaces, backupConfig.KanisterNamespace)
+		if !backupConfig.reportOnly(taweretSettings) {
+			deletingNamespaces[backupConfig.KanisterNamespace] = true
+		}
+	}
+	sort.Strings(kanisterNamespaces)
+	for i, kanisterNamespace := range kanisterNamespaces {
+		if i > 0 && kanisterNamespace == kanisterNamespaces[i-1] {
+			continue
+		}
+		actionSetVerbs := []string{"list", "get"}
+		if deletingNamespaces[kanisterNamespace] {
+			actionSetVerbs = append(actionSetVerbs, "create", "delete")
+			if taweretSettings.softDelete || taweretSettings.deletionDelay > 0 {
+				actionSetVerbs = append(actionSetVerbs, "patch")
+			}
+		}
+		for _, verb := range actionSetVerbs {
+			permissions = append(permissions, permission{namespace: kanisterNamespace, group: gvr.Group, resource: gvr.Resource, verb: verb})
+		}
+		if taweretSettings.events && deletingNamespaces[kanisterNamespace] {
+			permissions = append(permissions, permission{namespace: kanisterNamespace, resource: "events", verb: "create"})
+		}
+	}
+	return permissions
+}
+
+// returns the permissions which the ServiceAccount is not allowed to use, checked with a SelfSubjectAccessReview each
+func missingPermissions(ctx context.Context, clientSet kubernetes.Interface, taweretSettings taweretsettings, permissions []permission) ([]permission, error) {
+	var missing []permission
+	for _, required := range permissions {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: required.namespace,
+					Group:     required.group,
+					Resource:  required.resource,
+					Verb:      required.verb,
+				},
+			},
+		}
+		reviewCtx, cancel := apiContext(ctx, taweretSettings)
+		review, err := clientSet.AuthorizationV1().SelfSubjectAccessReviews().Create(reviewCtx, review, v1.CreateOptions{})
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("error reviewing permission to %v: %w", required, err)
+		}
+		if !review.Status.Allowed {
+			missing = append(missing, required)
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// cross-references the backup configs with the backup schedules found on the actionsets of their kanister namespaces, warns about
+// backup configs without a corresponding schedule and about schedules which no backup config manages
+// the schedules are read from each schedule source used in a namespace, errors are only logged, as the check does not affect the retention
+func checkSchedules(ctx context.Context, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, taweretMetrics taweretmetrics, taweretSettings taweretsettings, backupConfigs []backupconfig) {
+	configsByNamespace := make(map[string][]backupconfig)
+	for _, backupConfig := range backupConfigs {
+		configsByNamespace[backupConfig.KanisterNamespace] = append(configsByNamespace[backupConfig.KanisterNamespace], backupConfig)
+	}
+
+	for kanisterNamespace, namespaceConfigs := range configsByNamespace {
+		var sources []schedulesource
+		managed := make(map[schedulesource]map[string]bool)
+		for _, backupConfig := range namespaceConfigs {
+			source := backupConfig.scheduleSource()
+			if managed[source] == nil {
+				sources = append(sources, source)
+				managed[source] = make(map[string]bool)
+			}
+			managed[source][backupConfig.Name] = true
+		}
+
+		schedules, err := listSchedules(ctx, dynamicClient, gvr, taweretSettings, kanisterNamespace, sources)
+		if err != nil {
+			slog.Warn("error listing backup schedules, not checking them", "kanister_namespace", kanisterNamespace, "error", err)
+			continue
+		}
+
+		for _, backupConfig := range namespaceConfigs {
+			source := backupConfig.scheduleSource()
+			if schedules[source][backupConfig.Name] {
+				taweretMetrics.scheduleFound.WithLabelValues(backupConfig.metricLabels()...).Set(1)
+				continue
+			}
+			slog.Warn("backup config has no corresponding backup schedule on any actionset", "backup_config", backupConfig.Name, "kanister_namespace", kanisterNamespace, "schedule_source", source, "schedules", sortedKeys(schedules[source]))
+			taweretMetrics.scheduleFound.WithLabelValues(backupConfig.metricLabels()...).Set(0)
+		}
+
+		unmanagedSchedules := 0
+		for _, source := range sources {
+			for schedule := range schedules[source] {
+				if !managed[source][schedule] {
+					slog.Warn("backup schedule is not managed by any backup config", "schedule", schedule, "schedule_source", source, "kanister_namespace", kanisterNamespace)
+					unmanagedSchedules++
+				}
+			}
+		}
+		taweretMetrics.unmanagedSchedules.WithLabelValues(kanisterNamespace).Set(float64(unmanagedSchedules))
+	}
+}
+
+// returns the sets of schedules read from each schedule source of the actionsets in a namespace, deletion actionsets are left out
+func listSchedules(ctx context.Context, dynamicClient dynamic.Interface, gvr schema.GroupVersionResource, taweretSettings taweretsettings, namespace string, sources []schedulesource) (map[schedulesource]map[string]bool, error) {
+	schedules := make(map[schedulesource]map[string]bool, len(sources))
+	for _, source := range sources {
+		schedules[source] = make(map[string]bool)
+	}
+	listOptions := v1.ListOptions{Limit: int64(taweretSettings.listPageSize)}
+	for {
+		var actionsets *unstructured.UnstructuredList
+		err := retryAPICall(ctx, taweretSettings, fmt.Sprintf("list actionsets in namespace %v", namespace), func(callCtx context.Context) error {
+			var err error
+			actionsets, err = dynamicClient.Resource(gvr).Namespace(namespace).List(callCtx, listOptions)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error getting actionsets: %w", err)
+		}
+
+		for _, actionset := range actionsets.Items {
+			if strings.HasPrefix(actionset.GetName(), "delete-") {
+				continue
+			}
+			// the backup action of a multi-action actionset is not necessarily the first one, so the options of all actions count
+			actions, _, _ := unstructured.NestedSlice(actionset.Object, "spec", "actions")
+			for _, action := range actions {
+				action, _ := action.(map[string]interface{})
+				for _, source := range sources {
+					if schedule, ok := source.schedule(actionset, action); ok {
+						schedules[source][schedule] = true
+					}
+				}
+			}
+		}
+
+		if actionsets.GetContinue() == "" {
+			return schedules, nil
+		}
+		listOptions.Continue = actionsets.GetContinue()
+	}
+}
+
+// returns the keys of a set in ascending order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+)
+
+// default cron expression for backup evaluations
+const defaultEvalSchedule string = "*/10 * * * *"
+
+// default listen address for the metrics endpoint
+const defaultMetricsAddr string = ":2112"
+
+// default namespace in which backup config configmaps are looked up
+const defaultConfigNamespace string = "kanister"
+
+// default timeout for a single Kubernetes API call
+const defaultAPITimeout time.Duration = 30 * time.Second
+
+// default amount of backup configs evaluated concurrently
+const defaultMaxConcurrency int = 4
+
+// default interval before the first poll of a deletion actionset, it doubles with every poll
+const defaultDeletionPollInterval time.Duration = time.Second
+
+// default upper bound of the interval between polls of a deletion actionset
+const defaultDeletionPollMaxInterval time.Duration = 30 * time.Second
+
+// default time after which Taweret stops waiting for a deletion actionset to finish
+const defaultDeletionTimeout time.Duration = 30 * time.Minute
+
+// default annotation or label key with which backup actionsets are pinned, pinned backups are never deleted
+const defaultRetainKey string = "taweret.io/retain"
+
+// annotation with which backup actionsets are marked as expired in soft delete mode, holding the time at which they expired
+const expiredAnnotation string = "taweret.io/expired"
+
+// annotation with which backup actionsets held back by the deletion delay are marked, holding the time at which they became deletable
+const deletableSinceAnnotation string = "taweret.io/deletable-since"
+
+// default amount of actionsets listed per API call
+const defaultListPageSize int = 500
+
+// default amount of retries of a Kubernetes API call failing with a transient error
+const defaultAPIRetries int = 5
+
+// default backoff before the first retry of a Kubernetes API call, it doubles with every retry
+const defaultAPIRetryBackoff time.Duration = time.Second
+
+// upper bound of the backoff between retries of a Kubernetes API call
+const maxAPIRetryBackoff time.Duration = 30 * time.Second
+
+// default key of the backup configs in the ConfigMaps
+const defaultConfigKey string = "backup-config.yaml"
+
+// default rate limit of the Kubernetes clients in requests per second and its burst, the defaults of client-go
+const defaultK8sQPS float64 = 5
+const defaultK8sBurst int = 10
+
+// default time by which the retention period is extended, no grace unless it is set
+const defaultRetentionGrace time.Duration = 0
+
+// default time after which a pending or running backup is reported as stuck
+const defaultStuckBackupThreshold time.Duration = 6 * time.Hour
+
+// default time for which a running evaluation is awaited on shutdown
+const defaultShutdownGracePeriod time.Duration = 5 * time.Minute
+
+// default time without further backup config changes after which a watch-triggered evaluation starts
+const defaultWatchDebounce time.Duration = 10 * time.Second
+
+// default group, version and resource of Kanister actionsets
+var defaultActionSetGVR = schema.GroupVersionResource{
+	Group:    "cr.kanister.io",
+	Version:  "v1alpha1",
+	Resource: "actionsets",
+}
+
+// taweretsettings holds the process-wide settings read from environment variables at startup
+type taweretsettings struct {
+	evalSchedule       string
+	metricsAddr        string
+	configNamespaces   []string
+	dryRun             bool
+	maxDeletionsPerRun int
+	apiTimeout         time.Duration
+	// if reportOnly is set, the backups of all backup configs are categorised and reported in the metrics, but never deleted, no
+	// actionsets are created, deleted or patched, so that Taweret only needs to read them
+	reportOnly bool
+	// the oldest retained backups of all backup configs in excess of maxTotalBackups are deleted, 0 for no limit
+	maxTotalBackups int
+	// list calls failing with a transient error are retried up to apiRetries times with exponential backoff
+	apiRetries      int
+	apiRetryBackoff time.Duration
+	logFormat       string
+	maxConcurrency  int
+	// deletion actionsets are polled with an interval doubling from deletionPollInterval up to deletionPollMaxInterval,
+	// until they finish or deletionTimeout has passed
+	deletionPollInterval    time.Duration
+	deletionPollMaxInterval time.Duration
+	deletionTimeout         time.Duration
+	// deleted backup actionsets are polled in the same way until they are gone or backupDeletionTimeout has passed, they are
+	// not awaited if it is 0
+	backupDeletionTimeout time.Duration
+	// at most maxConcurrentDeletions deletions are in flight across all backup configs, each holding a slot of deletionSlots,
+	// which is set up in loadSettings, 0 for no limit
+	maxConcurrentDeletions int
+	deletionSlots          chan struct{}
+	listPageSize           int
+	// backup configs are read from ConfigMaps if configSource is configmap, or from BackupConfig custom resources if it is crd
+	// the ConfigMaps hold a backup config or a list of them under configKey
+	configSource string
+	configKey    string
+	// deletions and deletion failures are posted to webhookURL if set, webhookFormat is either json or slack
+	webhookURL    string
+	webhookFormat string
+	// on SIGTERM or SIGINT, a running evaluation is awaited for at most shutdownGracePeriod
+	shutdownGracePeriod time.Duration
+	// if watchConfigs is set, changes to the backup configs trigger an evaluation once they have settled for watchDebounce
+	watchConfigs  bool
+	watchDebounce time.Duration
+	// missing permissions of the ServiceAccount are logged at startup, and Taweret exits if requirePermissions is set
+	requirePermissions bool
+	// group, version and resource of the Kanister actionsets, which differ between Kanister versions
+	actionSetGVR schema.GroupVersionResource
+	// scheduled evaluations are delayed by a random offset of up to evalJitter picked at startup, and each backup config evaluation
+	// by a random delay of up to configJitter, so that the API server is not hit by many instances and configs at once
+	evalJitter   time.Duration
+	configJitter time.Duration
+	// completed deletion actionsets are kept for deletionActionSetRetention after their creation for auditing, then deleted
+	deletionActionSetRetention time.Duration
+	// unset retention fields of the backup configs are inherited from defaultRetention
+	defaultRetention backupretention
+	// if events is set, deletions and deletion failures are recorded as Kubernetes events by eventRecorder, which is set up in main
+	events        bool
+	eventRecorder record.EventRecorder
+	// backup actionsets with the annotation or label retainKey set to "true" are pinned and never deleted
+	retainKey string
+	// in soft delete mode, deletable backup actionsets are annotated as expired instead of being deleted
+	softDelete bool
+	// if deletionDelay is set, backups are only deleted once they have been deletable for deletionDelay, so that a mistaken change
+	// of a backup config can be reverted before its backups are deleted
+	deletionDelay time.Duration
+	// actionset states which count as complete and as failed, in addition to complete and failed
+	completeStates []string
+	failedStates   []string
+	// only backup configs whose name matches a glob of configAllowlist, if set, and none of configDenylist are managed
+	configAllowlist []string
+	configDenylist  []string
+	// if checkSchedules is set, the backup configs are cross-referenced with the backup schedules found on the actionsets
+	checkSchedules bool
+	// if runOnce is set, a single evaluation is run instead of the scheduled ones, and the metrics are served for runOnceLinger before exiting
+	runOnce       bool
+	runOnceLinger time.Duration
+	// if pushgatewayURL is set, the metrics are pushed to the Pushgateway after each evaluation, grouped by pushgatewayInstance
+	pushgatewayURL      string
+	pushgatewayInstance string
+	// client-side rate limit of the Kubernetes clients in requests per second, with bursts of up to k8sBurst requests
+	k8sQPS   float64
+	k8sBurst int
+	// the metrics endpoint is served over TLS with tlsCert and tlsKey if they are set, and requires client certificates signed by
+	// tlsClientCA if it is set
+	tlsCert     string
+	tlsKey      string
+	tlsClientCA string
+	// if adminToken is set, the introspection and evaluation endpoints require it as bearer token
+	adminToken string
+	// if deletionOwner is config, the deletion actionsets are owned by the ConfigMap or BackupConfig resource of their backup config
+	deletionOwner string
+	// extends the retention period of every backup config by retentionGrace, it does not apply to the backup count
+	retentionGrace time.Duration
+	// backups which are pending or running for longer than stuckBackupThreshold are reported as stuck, 0 to not report them
+	stuckBackupThreshold time.Duration
+	// if auditConfigMap is set, each deletion of a backup actionset is recorded beforehand by auditLog, which is set up in main,
+	// in the ConfigMap namespace/name, or name in the first config namespace, keeping the latest auditSize entries
+	auditConfigMap string
+	auditSize      int
+	auditLog       *auditlog
+	// if leaderElection is set, only the instance holding the Lease leaderElectionLease, given as namespace/name or as name in the
+	// first config namespace, evaluates the backup configs
+	leaderElection      bool
+	leaderElectionLease string
+	// the current time is read from clock, which only tests set, see now
+	clock func() time.Time
+}
+
+// reads the Taweret settings from environment variables and command-line flags, falling back to the defaults for unset variables
+func loadSettings() taweretsettings {
+	taweretSettings := taweretsettings{
+		evalSchedule: getEnv("TAWERET_EVAL_SCHEDULE", defaultEvalSchedule),
+		metricsAddr:  getEnv("TAWERET_METRICS_ADDR", defaultMetricsAddr),
+		// TAWERET_CONFIG_NAMESPACE may hold a comma-separated list of namespaces
+		configNamespaces:           splitList(getEnv("TAWERET_CONFIG_NAMESPACE", defaultConfigNamespace)),
+		configSource:               getEnv("TAWERET_CONFIG_SOURCE", "configmap"),
+		configKey:                  getEnv("TAWERET_CONFIG_KEY", defaultConfigKey),
+		dryRun:                     getEnvBool("TAWERET_DRY_RUN", false),
+		reportOnly:                 getEnvBool("TAWERET_REPORT_ONLY", false),
+		maxDeletionsPerRun:         getEnvInt("TAWERET_MAX_DELETIONS_PER_RUN", 0),
+		maxTotalBackups:            getEnvInt("TAWERET_MAX_TOTAL_BACKUPS", 0),
+		apiTimeout:                 getEnvDuration("TAWERET_API_TIMEOUT", defaultAPITimeout),
+		apiRetries:                 getEnvInt("TAWERET_API_RETRIES", defaultAPIRetries),
+		apiRetryBackoff:            getEnvDuration("TAWERET_API_RETRY_BACKOFF", defaultAPIRetryBackoff),
+		logFormat:                  getEnv("TAWERET_LOG_FORMAT", "text"),
+		maxConcurrency:             getEnvInt("TAWERET_MAX_CONCURRENCY", defaultMaxConcurrency),
+		deletionPollInterval:       getEnvDuration("TAWERET_DELETION_POLL_INTERVAL", defaultDeletionPollInterval),
+		deletionPollMaxInterval:    getEnvDuration("TAWERET_DELETION_POLL_MAX_INTERVAL", defaultDeletionPollMaxInterval),
+		deletionTimeout:            getEnvDuration("TAWERET_DELETION_TIMEOUT", defaultDeletionTimeout),
+		backupDeletionTimeout:      getEnvDuration("TAWERET_BACKUP_DELETION_TIMEOUT", 0),
+		maxConcurrentDeletions:     getEnvInt("TAWERET_MAX_CONCURRENT_DELETIONS", 0),
+		listPageSize:               getEnvInt("TAWERET_LIST_PAGE_SIZE", defaultListPageSize),
+		webhookURL:                 os.Getenv("TAWERET_WEBHOOK_URL"),
+		webhookFormat:              getEnv("TAWERET_WEBHOOK_FORMAT", "json"),
+		shutdownGracePeriod:        getEnvDuration("TAWERET_SHUTDOWN_GRACE_PERIOD", defaultShutdownGracePeriod),
+		watchConfigs:               getEnvBool("TAWERET_WATCH_CONFIGS", false),
+		watchDebounce:              getEnvDuration("TAWERET_WATCH_DEBOUNCE", defaultWatchDebounce),
+		requirePermissions:         getEnvBool("TAWERET_REQUIRE_PERMISSIONS", false),
+		evalJitter:                 getEnvDuration("TAWERET_EVAL_JITTER", 0),
+		deletionActionSetRetention: getEnvDuration("TAWERET_DELETION_ACTIONSET_RETENTION", 0),
+		events:                     getEnvBool("TAWERET_EVENTS", false),
+		configJitter:               getEnvDuration("TAWERET_CONFIG_JITTER", 0),
+		retainKey:                  getEnv("TAWERET_RETAIN_KEY", defaultRetainKey),
+		softDelete:                 getEnvBool("TAWERET_SOFT_DELETE", false),
+		deletionDelay:              getEnvDuration("TAWERET_DELETION_DELAY", 0),
+		completeStates:             splitList(os.Getenv("TAWERET_COMPLETE_STATES")),
+		failedStates:               splitList(os.Getenv("TAWERET_FAILED_STATES")),
+		configAllowlist:            splitList(os.Getenv("TAWERET_CONFIG_ALLOWLIST")),
+		configDenylist:             splitList(os.Getenv("TAWERET_CONFIG_DENYLIST")),
+		checkSchedules:             getEnvBool("TAWERET_CHECK_SCHEDULES", false),
+		runOnce:                    getEnvBool("TAWERET_RUN_ONCE", false),
+		runOnceLinger:              getEnvDuration("TAWERET_RUN_ONCE_LINGER", 0),
+		pushgatewayURL:             os.Getenv("TAWERET_PUSHGATEWAY_URL"),
+		pushgatewayInstance:        getEnv("TAWERET_PUSHGATEWAY_INSTANCE", hostname()),
+		k8sQPS:                     getEnvFloat("TAWERET_K8S_QPS", defaultK8sQPS),
+		k8sBurst:                   getEnvInt("TAWERET_K8S_BURST", defaultK8sBurst),
+		tlsCert:                    os.Getenv("TAWERET_TLS_CERT"),
+		tlsKey:                     os.Getenv("TAWERET_TLS_KEY"),
+		tlsClientCA:                os.Getenv("TAWERET_TLS_CLIENT_CA"),
+		adminToken:                 os.Getenv("TAWERET_ADMIN_TOKEN"),
+		deletionOwner:              os.Getenv("TAWERET_DELETION_OWNER"),
+		retentionGrace:             getEnvDuration("TAWERET_RETENTION_GRACE", defaultRetentionGrace),
+		stuckBackupThreshold:       getEnvDuration("TAWERET_STUCK_BACKUP_THRESHOLD", defaultStuckBackupThreshold),
+		auditConfigMap:             os.Getenv("TAWERET_AUDIT_CONFIGMAP"),
+		auditSize:                  getEnvInt("TAWERET_AUDIT_SIZE", defaultAuditSize),
+		leaderElection:             getEnvBool("TAWERET_LEADER_ELECTION", false),
+		leaderElectionLease:        getEnv("TAWERET_LEADER_ELECTION_LEASE", "taweret"),
+		actionSetGVR: schema.GroupVersionResource{
+			Group:    getEnv("TAWERET_ACTIONSET_GROUP", defaultActionSetGVR.Group),
+			Version:  getEnv("TAWERET_ACTIONSET_VERSION", defaultActionSetGVR.Version),
+			Resource: getEnv("TAWERET_ACTIONSET_RESOURCE", defaultActionSetGVR.Resource),
+		},
+	}
+
+	// TAWERET_DEFAULT_RETENTION holds a retention section in YAML, e.g. {backups: 7, days: 7}
+	if defaultRetention := os.Getenv("TAWERET_DEFAULT_RETENTION"); defaultRetention != "" {
+		if err := yaml.UnmarshalStrict([]byte(defaultRetention), &taweretSettings.defaultRetention); err != nil {
+			log.Fatalf("error parsing TAWERET_DEFAULT_RETENTION=%q: %v", defaultRetention, err)
+		}
+	}
+
+	// command-line flags take precedence over the environment variables, an invalid flag exits with the usage
+	_ = settingsFlags(&taweretSettings, flag.ExitOnError).Parse(os.Args[1:])
+
+	if err := taweretSettings.validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	if taweretSettings.maxConcurrentDeletions > 0 {
+		taweretSettings.deletionSlots = make(chan struct{}, taweretSettings.maxConcurrentDeletions)
+	}
+
+	return taweretSettings
+}
+
+// checks that the settings hold no negative limits, unknown values or conflicting options
+func (taweretSettings taweretsettings) validate() error {
+	if taweretSettings.maxConcurrency < 1 {
+		return fmt.Errorf("TAWERET_MAX_CONCURRENCY must be at least 1, got %v", taweretSettings.maxConcurrency)
+	}
+	if taweretSettings.maxConcurrentDeletions < 0 {
+		return fmt.Errorf("TAWERET_MAX_CONCURRENT_DELETIONS must not be negative, got %v", taweretSettings.maxConcurrentDeletions)
+	}
+	if taweretSettings.maxDeletionsPerRun < 0 {
+		return fmt.Errorf("TAWERET_MAX_DELETIONS_PER_RUN must not be negative, got %v", taweretSettings.maxDeletionsPerRun)
+	}
+	if taweretSettings.listPageSize < 0 {
+		return fmt.Errorf("TAWERET_LIST_PAGE_SIZE must not be negative, got %v", taweretSettings.listPageSize)
+	}
+	if taweretSettings.deletionPollMaxInterval < taweretSettings.deletionPollInterval {
+		return fmt.Errorf("TAWERET_DELETION_POLL_MAX_INTERVAL must not be shorter than TAWERET_DELETION_POLL_INTERVAL, got %v and %v", taweretSettings.deletionPollMaxInterval, taweretSettings.deletionPollInterval)
+	}
+	if taweretSettings.apiRetries < 0 {
+		return fmt.Errorf("TAWERET_API_RETRIES must not be negative, got %v", taweretSettings.apiRetries)
+	}
+	if taweretSettings.configSource != "configmap" && taweretSettings.configSource != "crd" {
+		return fmt.Errorf("unknown config source %q, supported sources are configmap and crd", taweretSettings.configSource)
+	}
+	if taweretSettings.deletionOwner != "" && taweretSettings.deletionOwner != "config" {
+		return fmt.Errorf("unknown deletion owner %q, the supported owner is config", taweretSettings.deletionOwner)
+	}
+	if taweretSettings.webhookFormat != "json" && taweretSettings.webhookFormat != "slack" {
+		return fmt.Errorf("unknown webhook format %q, supported formats are json and slack", taweretSettings.webhookFormat)
+	}
+	if taweretSettings.evalJitter < 0 || taweretSettings.configJitter < 0 {
+		return fmt.Errorf("TAWERET_EVAL_JITTER and TAWERET_CONFIG_JITTER must not be negative, got %v and %v", taweretSettings.evalJitter, taweretSettings.configJitter)
+	}
+	if taweretSettings.backupDeletionTimeout < 0 {
+		return fmt.Errorf("TAWERET_BACKUP_DELETION_TIMEOUT must not be negative, got %v", taweretSettings.backupDeletionTimeout)
+	}
+	if taweretSettings.maxTotalBackups < 0 {
+		return fmt.Errorf("TAWERET_MAX_TOTAL_BACKUPS must not be negative, got %v", taweretSettings.maxTotalBackups)
+	}
+	if taweretSettings.leaderElection && taweretSettings.runOnce {
+		return errors.New("TAWERET_LEADER_ELECTION cannot be combined with TAWERET_RUN_ONCE")
+	}
+	if taweretSettings.auditSize < 1 {
+		return fmt.Errorf("TAWERET_AUDIT_SIZE must be at least 1, got %v", taweretSettings.auditSize)
+	}
+	if taweretSettings.retentionGrace < 0 {
+		return fmt.Errorf("TAWERET_RETENTION_GRACE must not be negative, got %v", taweretSettings.retentionGrace)
+	}
+	if taweretSettings.deletionDelay < 0 {
+		return fmt.Errorf("TAWERET_DELETION_DELAY must not be negative, got %v", taweretSettings.deletionDelay)
+	}
+	if taweretSettings.stuckBackupThreshold < 0 {
+		return fmt.Errorf("TAWERET_STUCK_BACKUP_THRESHOLD must not be negative, got %v", taweretSettings.stuckBackupThreshold)
+	}
+	if taweretSettings.deletionActionSetRetention < 0 {
+		return fmt.Errorf("TAWERET_DELETION_ACTIONSET_RETENTION must not be negative, got %v", taweretSettings.deletionActionSetRetention)
+	}
+	if taweretSettings.k8sQPS <= 0 || taweretSettings.k8sBurst < 1 {
+		return fmt.Errorf("TAWERET_K8S_QPS must be positive and TAWERET_K8S_BURST at least 1, got %v and %v", taweretSettings.k8sQPS, taweretSettings.k8sBurst)
+	}
+	if (taweretSettings.tlsCert == "") != (taweretSettings.tlsKey == "") {
+		return errors.New("TAWERET_TLS_CERT and TAWERET_TLS_KEY must be set together")
+	}
+	if taweretSettings.tlsClientCA != "" && taweretSettings.tlsCert == "" {
+		return errors.New("TAWERET_TLS_CLIENT_CA requires TAWERET_TLS_CERT and TAWERET_TLS_KEY")
+	}
+	if taweretSettings.runOnceLinger < 0 {
+		return fmt.Errorf("TAWERET_RUN_ONCE_LINGER must not be negative, got %v", taweretSettings.runOnceLinger)
+	}
+	if taweretSettings.watchDebounce < 0 {
+		return fmt.Errorf("TAWERET_WATCH_DEBOUNCE must not be negative, got %v", taweretSettings.watchDebounce)
+	}
+	for _, pattern := range append(append([]string{}, taweretSettings.configAllowlist...), taweretSettings.configDenylist...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q in TAWERET_CONFIG_ALLOWLIST or TAWERET_CONFIG_DENYLIST: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// returns the current time of the clock of the settings, the wall clock unless a test sets another one
+// timeouts and durations are measured on the wall clock regardless, as they pair with real waits
+func (taweretSettings taweretsettings) now() time.Time {
+	if taweretSettings.clock == nil {
+		return time.Now()
+	}
+	return taweretSettings.clock()
+}
+
+// returns the key of the backup configs in the ConfigMaps, backup-config.yaml if none is set
+func (taweretSettings taweretsettings) backupConfigKey() string {
+	if taweretSettings.configKey == "" {
+		return defaultConfigKey
+	}
+	return taweretSettings.configKey
+}
+
+// switches the default logger to the format set by TAWERET_LOG_FORMAT, output of the log package is routed through it as well
+func setupLogging(taweretSettings taweretsettings) {
+	switch taweretSettings.logFormat {
+	case "text":
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+	default:
+		log.Fatalf("unknown log format %q, supported formats are text and json", taweretSettings.logFormat)
+	}
+}
+
+// returns the value of the environment variable named by key, or fallback if the variable is unset or empty
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// returns the boolean value of the environment variable named by key, or fallback if the variable is unset or empty
+func getEnvBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("error parsing %v=%q as a boolean: %v", key, value, err)
+	}
+	return parsed
+}
+
+// returns the integer value of the environment variable named by key, or fallback if the variable is unset or empty
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("error parsing %v=%q as an integer: %v", key, value, err)
+	}
+	return parsed
+}
+
+// returns the floating-point value of the environment variable named by key, or fallback if the variable is unset or empty
+func getEnvFloat(key string, fallback float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Fatalf("error parsing %v=%q as a number: %v", key, value, err)
+	}
+	return parsed
+}
+
+// returns the duration value of the environment variable named by key, or fallback if the variable is unset or empty
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("error parsing %v=%q as a duration: %v", key, value, err)
+	}
+	return parsed
+}
+
+// returns the hostname, which is the pod name in a pod, or taweret if it cannot be determined
+func hostname() string {
+	if name, err := os.Hostname(); err == nil && name != "" {
+		return name
+	}
+	return "taweret"
+}
+
+// splits a comma-separated list into its trimmed, non-empty elements
+func splitList(list string) []string {
+	var elements []string
+	for _, element := range strings.Split(list, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer of the evaluation spans, it records nothing until setupTracing has installed a tracer provider
+var tracer = otel.Tracer("github.com/swissdatasciencecenter/taweret")
+
+// installs a tracer provider exporting the spans over OTLP if OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is
+// set, the exporter reads its other settings from the standard OTEL_EXPORTER_OTLP_* environment variables
+// returns the function flushing and stopping the exporter, which does nothing if tracing is disabled
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error creating OTLP trace exporter: %w", err)
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence over the defaults
+	traceResource, err := resource.Merge(
+		resource.NewSchemaless(semconv.ServiceName("taweret"), semconv.ServiceVersion(version)),
+		resource.Environment(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error building trace resource: %w", err)
+	}
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(traceResource))
+	otel.SetTracerProvider(tracerProvider)
+	log.Printf("exporting traces over OTLP")
+	return tracerProvider.Shutdown, nil
+}
+
+// returns the span attributes identifying a backup config, named like the log attributes
+func backupConfigAttributes(backupConfig backupconfig) trace.SpanStartEventOption {
+	return trace.WithAttributes(
+		attribute.String("backup_config", backupConfig.Name),
+		attribute.String("kanister_namespace", backupConfig.KanisterNamespace),
+	)
+}
+
+// marks a span as failed with err, if err is set
+func recordSpanError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// watches the backup config sources in the config namespaces and sends on changes whenever a backup config is added, changed or removed,
+// the informers run until ctx is cancelled
+func watchBackupConfigs(ctx context.Context, dynamicClient dynamic.Interface, clientSet kubernetes.Interface, taweretSettings taweretsettings, changes chan<- struct{}) {
+	notify := func() {
+		// a pending notification already covers this change
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+
+	for _, configNamespace := range taweretSettings.configNamespaces {
+		var informer cache.SharedIndexInformer
+		if taweretSettings.configSource == "crd" {
+			informer = dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicClient, 0, configNamespace, nil).ForResource(backupConfigGVR).Informer()
+		} else {
+			informer = informers.NewSharedInformerFactoryWithOptions(clientSet, 0, informers.WithNamespace(configNamespace)).Core().V1().ConfigMaps().Informer()
+		}
+		// the informer reports itself as synced before the handler has received the last object of the initial listing
+		var synced atomic.Bool
+		informer.AddEventHandler(backupConfigEventHandler(&synced, taweretSettings.backupConfigKey(), notify))
+		go informer.Run(ctx.Done())
+		go func(configNamespace string) {
+			if cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+				synced.Store(true)
+				log.Printf("watching backup configs in namespace %v", configNamespace)
+			}
+		}(configNamespace)
+	}
+}
+
+// returns an event handler calling notify for events which change a backup config, objects added before synced is set are ignored
+// the backup configs of ConfigMaps are read from the config key
+func backupConfigEventHandler(synced *atomic.Bool, configKey string, notify func()) cache.ResourceEventHandlerFuncs {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if synced.Load() && backupConfigData(obj, configKey) != "" {
+				notify()
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if backupConfigData(oldObj, configKey) != backupConfigData(newObj, configKey) {
+				notify()
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if backupConfigData(obj, configKey) != "" {
+				notify()
+			}
+		},
+	}
+}
+
+// returns the part of a watched object which holds the backup config, or an empty string if the object holds no backup config
+func backupConfigData(obj interface{}, configKey string) string {
+	switch object := obj.(type) {
+	case *corev1.ConfigMap:
+		return object.Data[configKey]
+	case *unstructured.Unstructured:
+		// the generation of a custom resource is only incremented by changes to its spec
+		return fmt.Sprintf("%v/%v", object.GetName(), object.GetGeneration())
+	}
+	return ""
+}
+
+// calls evaluate once no change has been received for the debounce period, until ctx is cancelled
+func debounceChanges(ctx context.Context, changes <-chan struct{}, debounce time.Duration, evaluate func()) {
+	// a nil channel blocks until the first change starts the timer
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+			timer = time.After(debounce)
+		case <-timer:
+			timer = nil
+			evaluate()
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// timeout of a single webhook notification
+const webhookTimeout time.Duration = 10 * time.Second
+
+// webhookevent is posted to the webhook after a backup has been deleted or its deletion failed
+type webhookevent struct {
+	Event        string    `json:"event"`
+	BackupConfig string    `json:"backupConfig"`
+	BackupName   string    `json:"backupName"`
+	BackupTime   time.Time `json:"backupTime"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// posts the event to the webhook in the background if a webhook is configured, failures are only logged so that they never affect the evaluation
+func notifyWebhook(taweretSettings taweretsettings, event webhookevent) {
+	if taweretSettings.webhookURL == "" {
+		return
+	}
+	go func() {
+		if err := sendWebhook(taweretSettings, event); err != nil {
+			slog.Warn("error sending webhook notification", "backup_config", event.BackupConfig, "backup_name", event.BackupName, "action", "notify", "error", err)
+		}
+	}()
+}
+
+// posts the event to the webhook, formatted as a Slack message if the webhook format is slack
+func sendWebhook(taweretSettings taweretsettings, event webhookevent) error {
+	var payload interface{} = event
+	if taweretSettings.webhookFormat == "slack" {
+		text := fmt.Sprintf("Taweret deleted backup %v of backup config %v created at %v", event.BackupName, event.BackupConfig, event.BackupTime.Format(time.RFC3339))
+		if event.Event == "deletion_failed" {
+			text = fmt.Sprintf("Taweret failed to delete backup %v of backup config %v created at %v: %v", event.BackupName, event.BackupConfig, event.BackupTime.Format(time.RFC3339), event.Error)
+		}
+		payload = map[string]string{"text": text}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error encoding webhook payload: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, taweretSettings.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating webhook request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("error posting to webhook: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %v", response.Status)
+	}
+	return nil
+}